@@ -0,0 +1,222 @@
+package application
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strconv"
+	"sync"
+	"time"
+	"user-microservice/model"
+	"user-microservice/startup/config"
+)
+
+const (
+	auditVerificationBatch = 500
+	auditRecordAttempts    = 10
+)
+
+type AuditService struct {
+	store           model.UserStore
+	privateKey      ed25519.PrivateKey
+	publicKey       ed25519.PublicKey
+	checkpointEvery int64
+	lock            sync.Mutex
+}
+
+// AuditVerification is the outcome of walking the audit chain. When Valid is
+// false, BrokenAt holds the sequence of the first entry that failed and Reason
+// explains why.
+type AuditVerification struct {
+	Valid       bool
+	Checked     int64
+	Checkpoints int64
+	BrokenAt    int64
+	Reason      string
+}
+
+func NewAuditService(store model.UserStore, config *config.Config) *AuditService {
+	service := &AuditService{
+		store:           store,
+		checkpointEvery: config.AuditCheckpointEvery,
+	}
+	seed, err := hex.DecodeString(config.AuditSigningKey)
+	if err == nil && len(seed) == ed25519.SeedSize {
+		service.privateKey = ed25519.NewKeyFromSeed(seed)
+		service.publicKey = service.privateKey.Public().(ed25519.PublicKey)
+	} else {
+		Log.Warn("AUDIT_SIGNING_KEY is missing or invalid, audit checkpoints will not be signed")
+	}
+	return service
+}
+
+// Record appends an event to the audit chain. Failures are logged rather than
+// returned so that auditing never blocks the operation being audited. The
+// lock only keeps requests of this replica from racing each other; when
+// another replica takes the same sequence first, the unique index rejects the
+// event and it is linked to the new head instead.
+func (service *AuditService) Record(ctx context.Context, eventType model.AuditEventType, userId string, details map[string]string) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		event, err := service.append(ctx, eventType, userId, details)
+		if mongo.IsDuplicateKeyError(err) && attempt < auditRecordAttempts {
+			continue
+		}
+		if err != nil {
+			Log.Error("Cannot store audit event " + string(eventType) + ": " + err.Error())
+			return
+		}
+		if service.checkpointEvery > 0 && event.Sequence%service.checkpointEvery == 0 {
+			service.checkpoint(ctx, event)
+		}
+		return
+	}
+}
+
+// append links an event to the current head of the chain and stores it.
+func (service *AuditService) append(ctx context.Context, eventType model.AuditEventType, userId string, details map[string]string) (*model.AuditEvent, error) {
+	sequence := int64(1)
+	prevHash := ""
+	last, err := service.store.GetLastAuditEvent(ctx)
+	if err == nil {
+		sequence = last.Sequence + 1
+		prevHash = last.Hash
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	event := &model.AuditEvent{
+		Sequence:  sequence,
+		Type:      eventType,
+		UserId:    userId,
+		Details:   details,
+		Timestamp: time.Now().UTC().Truncate(time.Millisecond),
+		PrevHash:  prevHash,
+	}
+	event.Hash = hashAuditEvent(event)
+	return service.store.CreateAuditEvent(ctx, event)
+}
+
+func (service *AuditService) checkpoint(ctx context.Context, event *model.AuditEvent) {
+	if service.privateKey == nil {
+		return
+	}
+	checkpoint := &model.AuditCheckpoint{
+		Sequence:  event.Sequence,
+		Hash:      event.Hash,
+		Signature: hex.EncodeToString(ed25519.Sign(service.privateKey, checkpointMessage(event.Sequence, event.Hash))),
+		PublicKey: hex.EncodeToString(service.publicKey),
+		Timestamp: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	_, err := service.store.CreateAuditCheckpoint(ctx, checkpoint)
+	if err != nil {
+		Log.Error("Cannot store audit checkpoint at sequence " + strconv.FormatInt(event.Sequence, 10) + ": " + err.Error())
+		return
+	}
+	Log.Info("Audit checkpoint signed at sequence " + strconv.FormatInt(event.Sequence, 10))
+}
+
+// Verify walks the whole chain from the first event and stops at the first
+// entry whose sequence, link or content does not match, or whose checkpoint
+// signature is invalid. Without a configured signing key the checkpoints
+// cannot be trusted, so the chain is not verified at all.
+func (service *AuditService) Verify(ctx context.Context) (*AuditVerification, error) {
+	Log.Info("Verifying audit chain")
+	if service.publicKey == nil {
+		return nil, NewFailedPreconditionError("AUDIT_SIGNING_KEY is missing or invalid, the audit chain cannot be verified")
+	}
+	checkpoints, err := service.store.GetAuditCheckpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	checkpointsBySequence := make(map[int64]*model.AuditCheckpoint)
+	for _, checkpoint := range checkpoints {
+		checkpointsBySequence[checkpoint.Sequence] = checkpoint
+	}
+	result := &AuditVerification{Valid: true}
+	expected := int64(1)
+	prevHash := ""
+	for {
+		events, err := service.store.GetAuditEvents(ctx, expected, auditVerificationBatch)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			if reason := service.verifyEvent(event, expected, prevHash, checkpointsBySequence[event.Sequence]); reason != "" {
+				return result.broken(expected, reason), nil
+			}
+			if _, ok := checkpointsBySequence[event.Sequence]; ok {
+				result.Checkpoints++
+			}
+			result.Checked++
+			prevHash = event.Hash
+			expected++
+		}
+		if len(events) < auditVerificationBatch {
+			break
+		}
+	}
+
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Sequence >= expected {
+			return result.broken(expected, "chain ends before signed checkpoint "+strconv.FormatInt(checkpoint.Sequence, 10)), nil
+		}
+	}
+	Log.Info("Audit chain verified, " + strconv.FormatInt(result.Checked, 10) + " events checked")
+	return result, nil
+}
+
+func (service *AuditService) verifyEvent(event *model.AuditEvent, expected int64, prevHash string, checkpoint *model.AuditCheckpoint) string {
+	if event.Sequence != expected {
+		return "event is missing or out of order"
+	}
+	if event.PrevHash != prevHash {
+		return "previous hash does not match the preceding event"
+	}
+	if hashAuditEvent(event) != event.Hash {
+		return "event content does not match its hash"
+	}
+	if checkpoint == nil {
+		return ""
+	}
+	if checkpoint.Hash != event.Hash {
+		return "event hash does not match the signed checkpoint"
+	}
+	signature, err := hex.DecodeString(checkpoint.Signature)
+	if err != nil || !ed25519.Verify(service.publicKey, checkpointMessage(checkpoint.Sequence, checkpoint.Hash), signature) {
+		return "checkpoint signature is invalid"
+	}
+	return ""
+}
+
+func (result *AuditVerification) broken(sequence int64, reason string) *AuditVerification {
+	Log.Warn("Audit chain broken at sequence " + strconv.FormatInt(sequence, 10) + ": " + reason)
+	result.Valid = false
+	result.BrokenAt = sequence
+	result.Reason = reason
+	return result
+}
+
+func hashAuditEvent(event *model.AuditEvent) string {
+	content, _ := json.Marshal(struct {
+		Sequence  int64             `json:"sequence"`
+		Type      string            `json:"type"`
+		UserId    string            `json:"userId"`
+		Details   map[string]string `json:"details"`
+		Timestamp int64             `json:"timestamp"`
+		PrevHash  string            `json:"prevHash"`
+	}{event.Sequence, string(event.Type), event.UserId, event.Details, event.Timestamp.UnixMilli(), event.PrevHash})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func checkpointMessage(sequence int64, hash string) []byte {
+	return []byte(fmt.Sprintf("%d:%s", sequence, hash))
+}
@@ -3,7 +3,9 @@ package application
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base32"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
@@ -13,9 +15,11 @@ import (
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"net/url"
 	"time"
 	"user-microservice/model"
+	"user-microservice/startup/config"
 
 	_ "io/ioutil"
 
@@ -25,40 +29,132 @@ import (
 type AuthService struct {
 	store      model.UserStore
 	jwtManager *token.JwtManager
+	// sessionTTL is how long a token is valid, after which its session is
+	// removed.
+	sessionTTL time.Duration
+	// Tokens issued before sessions were stored have none. They are accepted
+	// until sessionlessUntil, which should lie one token lifetime after the
+	// deploy that introduced sessions; unset, they are rejected and their
+	// users have to log in again.
+	sessionlessUntil time.Time
+	audit            *AuditService
+	devices          *DeviceService
+	completeness     *CompletenessService
+	events           *EventService
 }
 
 var Log = logrus.New()
 
-func NewAuthService(store model.UserStore, manager *token.JwtManager) *AuthService {
+func NewAuthService(store model.UserStore, manager *token.JwtManager, config *config.Config, audit *AuditService, devices *DeviceService, completeness *CompletenessService, events *EventService) *AuthService {
 	return &AuthService{
-		store:      store,
-		jwtManager: manager,
+		store:            store,
+		jwtManager:       manager,
+		sessionTTL:       config.ExpiresIn,
+		sessionlessUntil: config.SessionlessTokensUntil,
+		audit:            audit,
+		devices:          devices,
+		completeness:     completeness,
+		events:           events,
 	}
 }
 
-func (service *AuthService) Login(ctx context.Context, in *userService.CredentialsRequest) (*userService.LoginResponse, error) {
+func (service *AuthService) Login(ctx context.Context, in *userService.CredentialsRequest, client *model.ClientInfo) (*userService.LoginResponse, error) {
 	Log.Info("User with username: " + in.Credentials.Username + " try to login")
 	user, err := service.getUser(ctx, in.Credentials.Username)
 	if err == nil && security.BcryptCompareHashAndPassword(user.Password, in.Credentials.Password) == nil {
 		if user.Confirmed == false {
 			Log.Warn("User with username: " + in.Credentials.Username + " entered wrong password")
-			return nil, errors.New("unconfirmed registration")
-		}
-
-		jwtToken, err := service.jwtManager.GenerateJWT(user.Id.Hex(), user.Email, string(user.Role))
-		if err != nil {
-			Log.Error("User with username: " + in.Credentials.Username + " get error while generating JWT")
-			return nil, err
+			service.audit.Record(ctx, model.LOGIN_FAILED, user.Id.Hex(), map[string]string{"username": in.Credentials.Username, "reason": "unconfirmed registration"})
+			return nil, NewFailedPreconditionError("unconfirmed registration")
 		}
 
 		if user.TFAEnabled {
 			Log.Info("User with username: " + in.Credentials.Username + " started TFA")
+			service.audit.Record(ctx, model.LOGIN_SUCCEEDED, user.Id.Hex(), map[string]string{"username": in.Credentials.Username, "tfaPending": "true"})
 			return &userService.LoginResponse{UserId: user.Id.Hex()}, nil
 		}
+
+		jwtToken, err := service.generateToken(ctx, user)
+		if err != nil {
+			Log.Error("User with username: " + in.Credentials.Username + " get error while generating JWT")
+			return nil, err
+		}
 		Log.Info("User with username: " + in.Credentials.Username + " logged in")
+		service.recordLogin(ctx, user, client)
+		service.audit.Record(ctx, model.LOGIN_SUCCEEDED, user.Id.Hex(), map[string]string{"username": in.Credentials.Username})
 		return &userService.LoginResponse{UserId: user.Id.Hex(), Email: user.Email, Role: string(user.Role), Token: jwtToken, IsPrivate: user.Private, Username: user.Username}, nil
 	}
-	return nil, errors.New("wrong username or password")
+	service.audit.Record(ctx, model.LOGIN_FAILED, "", map[string]string{"username": in.Credentials.Username, "reason": "wrong username or password"})
+	return nil, NewUnauthenticatedError("wrong username or password")
+}
+
+// recordLogin remembers the device of a completed login. It must only run
+// once every factor was checked, otherwise a wrong 2FA code would still mark
+// the device as known.
+func (service *AuthService) recordLogin(ctx context.Context, user *model.User, client *model.ClientInfo) {
+	_, err := service.devices.RecordLogin(ctx, user, client)
+	if err != nil {
+		Log.Error("Cannot record login device for user with id: " + user.Id.Hex())
+	}
+}
+
+// generateToken issues a JWT and stores a session for it so that it can be
+// revoked before it expires.
+func (service *AuthService) generateToken(ctx context.Context, user *model.User) (string, error) {
+	jwtToken, err := service.jwtManager.GenerateJWT(user.Id.Hex(), user.Email, string(user.Role))
+	if err != nil {
+		return "", err
+	}
+	session := &model.Session{
+		UserId:    user.Id.Hex(),
+		TokenHash: hashToken(jwtToken),
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(service.sessionTTL),
+	}
+	_, err = service.store.CreateSession(ctx, session)
+	if err != nil {
+		return "", err
+	}
+	return jwtToken, nil
+}
+
+func hashToken(jwtToken string) string {
+	sum := sha256.Sum256([]byte(jwtToken))
+	return hex.EncodeToString(sum[:])
+}
+
+func (service *AuthService) RevokeSessions(ctx context.Context, userId primitive.ObjectID) error {
+	Log.Info("Revoking all sessions for user with id: " + userId.Hex())
+	err := service.store.RevokeUserSessions(ctx, userId.Hex())
+	if err != nil {
+		Log.Error("Cannot revoke sessions for user with id: " + userId.Hex())
+		return err
+	}
+	return nil
+}
+
+// ReportUnrecognizedLogin handles the "this wasn't me" link from the new
+// device email: it revokes every session and starts password recovery.
+func (service *AuthService) ReportUnrecognizedLogin(ctx context.Context, userId primitive.ObjectID, loginId primitive.ObjectID) error {
+	Log.Warn("User with id: " + userId.Hex() + " reported unrecognized login with id: " + loginId.Hex())
+	_, err := service.devices.GetLoginRecord(ctx, userId, loginId)
+	if err != nil {
+		Log.Warn("Unexciting login with id: " + loginId.Hex())
+		return err
+	}
+	user, err := service.store.Get(ctx, userId)
+	if err != nil {
+		Log.Warn("Unexciting user with id: " + userId.Hex())
+		return err
+	}
+
+	err = service.RevokeSessions(ctx, userId)
+	if err != nil {
+		return err
+	}
+	service.audit.Record(ctx, model.UNRECOGNIZED_LOGIN_REPORTED, userId.Hex(), map[string]string{"loginId": loginId.Hex()})
+
+	return service.CreatePasswordRecoveryRequest(ctx, user.Username)
 }
 
 func (service *AuthService) getUser(ctx context.Context, username string) (*model.User, error) {
@@ -71,7 +167,7 @@ func (service *AuthService) getUser(ctx context.Context, username string) (*mode
 	if err == nil {
 		return user, nil
 	}
-	return nil, err
+	return nil, notFoundOr(err, "user not found")
 }
 
 func (service *AuthService) ConfirmRegistration(ctx context.Context, in *userService.ConfirmationRequest) (*userService.ConfirmationResponse, error) {
@@ -79,10 +175,27 @@ func (service *AuthService) ConfirmRegistration(ctx context.Context, in *userSer
 	user, err := service.store.GetByConfirmationId(ctx, in.ConfirmationId)
 	if err != nil {
 		Log.Error("User with given confirmationId: " + in.ConfirmationId + " does not exist")
-		return &userService.ConfirmationResponse{ResponseMessage: "user with given confirmationId does not exist"}, err
-	}
-	user.Confirmed = true
-	_, err = service.store.Update(ctx, user.Id, user)
+		return &userService.ConfirmationResponse{ResponseMessage: "user with given confirmationId does not exist"}, notFoundOr(err, "user with given confirmationId does not exist")
+	}
+	if user.Confirmed {
+		Log.Info("Registration with id : " + in.ConfirmationId + " is already confirmed")
+		return &userService.ConfirmationResponse{ResponseMessage: "successfully confirmed registration"}, nil
+	}
+	err = service.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		confirmed := false
+		_, err := updateUser(ctx, service.store, user.Id, func(user *model.User) error {
+			if user.Confirmed {
+				return errUserUnchanged
+			}
+			user.Confirmed = true
+			confirmed = true
+			return nil
+		})
+		if err != nil || !confirmed {
+			return err
+		}
+		return service.events.Emit(ctx, &model.UserConfirmed{UserId: user.Id.Hex()})
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -91,17 +204,55 @@ func (service *AuthService) ConfirmRegistration(ctx context.Context, in *userSer
 }
 
 func (service *AuthService) IsAuthenticated(ctx context.Context, jwtToken string) (model.UserRole, error) {
+	_, userRole, err := service.authenticate(ctx, jwtToken)
+	return userRole, err
+}
+
+// Requester returns the id of the user a session token was issued to.
+func (service *AuthService) Requester(ctx context.Context, jwtToken string) (primitive.ObjectID, error) {
+	session, _, err := service.authenticate(ctx, jwtToken)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if session == nil {
+		return primitive.NilObjectID, NewUnauthenticatedError("token has no session, log in again")
+	}
+	userId, err := primitive.ObjectIDFromHex(session.UserId)
+	if err != nil {
+		return primitive.NilObjectID, &DomainError{Kind: Unauthenticated, Message: "invalid session", Err: err}
+	}
+	return userId, nil
+}
+
+func (service *AuthService) authenticate(ctx context.Context, jwtToken string) (*model.Session, model.UserRole, error) {
 	ok := service.jwtManager.IsUserAuthorized(jwtToken)
 	if ok != nil {
 		Log.Warn("Unauthorized user")
-		return "", ok
+		return nil, "", &DomainError{Kind: Unauthenticated, Message: "invalid token", Err: ok}
 	}
 	userRole, err := service.jwtManager.GetRoleFromToken(jwtToken)
 	if err != nil {
 		Log.Warn("Jwt is not valid")
-		return "", err
+		return nil, "", &DomainError{Kind: Unauthenticated, Message: "invalid token", Err: err}
+	}
+	session, err := service.store.GetSessionByTokenHash(ctx, hashToken(jwtToken))
+	if errors.Is(err, mongo.ErrNoDocuments) && time.Now().Before(service.sessionlessUntil) {
+		Log.Warn("Accepting token without a session until " + service.sessionlessUntil.Format(time.RFC3339))
+		return nil, model.UserRole(userRole), nil
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		Log.Warn("No session for token")
+		return nil, "", NewUnauthenticatedError("session not found")
+	}
+	if err != nil {
+		Log.Error("Cannot read session: " + err.Error())
+		return nil, "", err
+	}
+	if session.Revoked {
+		Log.Warn("Session of user with id: " + session.UserId + " was revoked")
+		return nil, "", NewUnauthenticatedError("session revoked")
 	}
-	return model.UserRole(userRole), nil
+	return session, model.UserRole(userRole), nil
 }
 
 func (service *AuthService) CheckPassword(ctx context.Context, password string, userId primitive.ObjectID) (bool, error) {
@@ -112,39 +263,27 @@ func (service *AuthService) CheckPassword(ctx context.Context, password string,
 		return true, nil
 	}
 	Log.Warn("Invalid password of user with id:" + userId.Hex())
-	return false, errors.New("wrong password")
-}
-
-func (service *AuthService) CheckUsername(ctx context.Context, username string) (bool, error) {
-	Log.Info("Checking username: " + username)
-	_, err := service.store.GetByUsername(ctx, username)
-	if err != nil {
-		Log.Info("Valid username: " + username)
-		return true, nil
-	}
-	Log.Info("Invalid username: " + username)
-	return false, nil
+	return false, NewInvalidArgumentError("wrong password", FieldViolation{Field: "password", Description: "must differ from the current password"})
 }
 
 func (service *AuthService) GetQR2FA(ctx context.Context, userId primitive.ObjectID) ([]byte, error) {
 	Log.Info("Getting QR2FA for user with id: " + userId.Hex())
-	user, err := service.store.Get(ctx, userId)
-
-	if err != nil {
-		Log.Warn("User with id: " + userId.Hex() + " doesn't exits")
-		return nil, err
-	}
-
 	secret := make([]byte, 10)
-	_, err = rand.Read(secret)
+	_, err := rand.Read(secret)
 	if err != nil {
 		Log.Error("Secret making stop working")
 		panic(err)
 	}
 
-	user.TFASecret = base32.StdEncoding.EncodeToString(secret)
-	user.TFAEnabled = false
-	service.store.Update(ctx, userId, user)
+	user, err := updateUser(ctx, service.store, userId, func(user *model.User) error {
+		user.TFASecret = base32.StdEncoding.EncodeToString(secret)
+		user.TFAEnabled = false
+		return nil
+	})
+	if err != nil {
+		Log.Warn("User with id: " + userId.Hex() + " doesn't exits")
+		return nil, err
+	}
 
 	URL, err := url.Parse("otpauth://totp")
 	if err != nil {
@@ -170,7 +309,23 @@ func (service *AuthService) GetQR2FA(ctx context.Context, userId primitive.Objec
 	return code.PNG(), nil
 }
 
-func (service *AuthService) Verify2fa(ctx context.Context, userId primitive.ObjectID, code string) (*userService.LoginResponse, error) {
+// Verify2fa completes a login that is waiting for the second factor.
+func (service *AuthService) Verify2fa(ctx context.Context, userId primitive.ObjectID, code string, client *model.ClientInfo) (*userService.LoginResponse, error) {
+	user, err := service.verifyTFACode(ctx, userId, code)
+	if err != nil {
+		return nil, err
+	}
+
+	jwtToken, err := service.generateToken(ctx, user)
+	if err != nil {
+		Log.Warn("Invalid 2FA for user with id: " + userId.Hex())
+		return nil, err
+	}
+	service.recordLogin(ctx, user, client)
+	return &userService.LoginResponse{UserId: user.Id.Hex(), Email: user.Email, Role: string(user.Role), Token: jwtToken, IsPrivate: user.Private}, nil
+}
+
+func (service *AuthService) verifyTFACode(ctx context.Context, userId primitive.ObjectID, code string) (*model.User, error) {
 	Log.Info("Verifying 2FA for user with id: " + userId.Hex())
 	user, err := service.store.Get(ctx, userId)
 
@@ -188,56 +343,55 @@ func (service *AuthService) Verify2fa(ctx context.Context, userId primitive.Obje
 	val, err := otpc.Authenticate(code)
 	if err != nil {
 		Log.Warn("Invalid 2FA for user with id: " + userId.Hex())
-		return nil, err
+		service.audit.Record(ctx, model.TFA_VERIFICATION_FAILED, userId.Hex(), map[string]string{"reason": err.Error()})
+		return nil, NewInvalidArgumentError("malformed code", FieldViolation{Field: "code", Description: err.Error()})
 	}
 	if !val {
 		Log.Warn("Invalid 2FA for user with id: " + userId.Hex())
-		return nil, errors.New("Not recognize code")
-	}
-
-	jwtToken, err := service.jwtManager.GenerateJWT(user.Id.Hex(), user.Email, string(user.Role))
-	if err != nil {
-		Log.Warn("Invalid 2FA for user with id: " + userId.Hex())
-		return nil, err
+		service.audit.Record(ctx, model.TFA_VERIFICATION_FAILED, userId.Hex(), map[string]string{"reason": "code not recognized"})
+		return nil, NewUnauthenticatedError("Not recognize code")
 	}
 
 	Log.Warn("Successful 2FA for user with id: " + userId.Hex())
-	return &userService.LoginResponse{UserId: user.Id.Hex(), Email: user.Email, Role: string(user.Role), Token: jwtToken, IsPrivate: user.Private}, nil
+	service.audit.Record(ctx, model.TFA_VERIFIED, userId.Hex(), nil)
+	return user, nil
 }
 
 func (service *AuthService) Disable2fa(ctx context.Context, userId primitive.ObjectID) error {
 	Log.Info("Disabling 2FA for use with id: " + userId.Hex())
-	user, err := service.store.Get(ctx, userId)
-	if err != nil {
-		Log.Warn("Unexciting user with id: " + userId.Hex())
-		return err
-	}
-	user.TFAEnabled = false
-	user.TFASecret = ""
-	user, err = service.store.Update(ctx, userId, user)
+	_, err := updateUser(ctx, service.store, userId, func(user *model.User) error {
+		user.TFAEnabled = false
+		user.TFASecret = ""
+		return nil
+	})
 	if err != nil {
 		Log.Error("2FA was not disabled due to error, for user with id: " + userId.Hex())
 		return err
 	}
 	Log.Info("2FA disabled for use with id: " + userId.Hex())
+	service.audit.Record(ctx, model.TFA_DISABLED, userId.Hex(), nil)
+	service.completeness.Refresh(ctx, userId)
 	return nil
 }
 
 func (service *AuthService) Enable2FA(ctx context.Context, userId primitive.ObjectID, code string) error {
 	Log.Info("Enabling 2FA for use with id: " + userId.Hex())
-	_, err := service.Verify2fa(ctx, userId, code)
+	_, err := service.verifyTFACode(ctx, userId, code)
 	if err != nil {
 		Log.Warn("Unexciting user with id: " + userId.Hex())
 		return err
 	}
-	user, err := service.store.Get(ctx, userId)
-	user.TFAEnabled = true
-	user, err = service.store.Update(ctx, userId, user)
+	_, err = updateUser(ctx, service.store, userId, func(user *model.User) error {
+		user.TFAEnabled = true
+		return nil
+	})
 	if err != nil {
 		Log.Error("2FA was not enabled due to error, for user with id: " + userId.Hex())
 		return err
 	}
 	Log.Info("2FA enabled for use with id: " + userId.Hex())
+	service.audit.Record(ctx, model.TFA_ENABLED, userId.Hex(), nil)
+	service.completeness.Refresh(ctx, userId)
 	return nil
 }
 
@@ -254,31 +408,26 @@ func (service *AuthService) GetApiToken(ctx context.Context, userId primitive.Ob
 
 func (service *AuthService) CreateApiToken(ctx context.Context, userId primitive.ObjectID) (string, error) {
 	Log.Info("Creating API token for user with id: " + userId.Hex())
-	user, err := service.store.Get(ctx, userId)
-	if err != nil {
-		Log.Warn("Unexciting user with id: " + userId.Hex())
-		return "", err
-	}
-
-	user.ApiToken = uuid.New().String()
-	_, err = service.store.Update(ctx, userId, user)
+	apiToken := uuid.New().String()
+	_, err := updateUser(ctx, service.store, userId, func(user *model.User) error {
+		user.ApiToken = apiToken
+		return nil
+	})
 	if err != nil {
 		Log.Error("Can not create api token due to error, for user with id: " + userId.Hex())
 		return "", err
 	}
 	Log.Info("API token created successful for user with id: " + userId.Hex())
-	return user.ApiToken, nil
+	service.audit.Record(ctx, model.API_TOKEN_CREATED, userId.Hex(), nil)
+	return apiToken, nil
 }
 
 func (service *AuthService) RemoveApiToken(ctx context.Context, userId primitive.ObjectID) error {
 	Log.Info("Removing API token for user with id: " + userId.Hex())
-	user, err := service.store.Get(ctx, userId)
-	if err != nil {
-		Log.Warn("Unexciting user with id: " + userId.Hex())
-		return err
-	}
-	user.ApiToken = ""
-	_, err = service.store.Update(ctx, userId, user)
+	_, err := updateUser(ctx, service.store, userId, func(user *model.User) error {
+		user.ApiToken = ""
+		return nil
+	})
 	if err != nil {
 		Log.Error("Can not remove api token due to error, for user with id: " + userId.Hex())
 		return err
@@ -298,7 +447,7 @@ func (service *AuthService) IsApiTokenValid(ctx context.Context, token string) (
 			return user.Id.Hex(), nil
 		}
 	}
-	return "", errors.New("unauthorized")
+	return "", NewUnauthenticatedError("unauthorized")
 }
 
 func (service *AuthService) CreatePasswordRecoveryRequest(ctx context.Context, username string) error {
@@ -327,6 +476,7 @@ func (service *AuthService) CreatePasswordRecoveryRequest(ctx context.Context, u
 	}
 
 	Log.Info("Successful send email for password recovery for user with username: " + username)
+	service.audit.Record(ctx, model.PASSWORD_RECOVERY_REQUESTED, user.Id.Hex(), map[string]string{"recoveryId": createdRequest.Id.Hex()})
 	return nil
 }
 
@@ -354,7 +504,7 @@ func (service *AuthService) PasswordlessLoginCreate(ctx context.Context, usernam
 	return nil
 }
 
-func (service *AuthService) PasswordlessLogin(ctx context.Context, userId primitive.ObjectID, loginId primitive.ObjectID) (*userService.LoginResponse, error) {
+func (service *AuthService) PasswordlessLogin(ctx context.Context, userId primitive.ObjectID, loginId primitive.ObjectID, client *model.ClientInfo) (*userService.LoginResponse, error) {
 	Log.Info("Starting passwordless login for user with id: " + userId.Hex())
 	found, err := service.store.GetPasswordlessRequest(ctx, userId, loginId)
 	if err != nil {
@@ -363,12 +513,21 @@ func (service *AuthService) PasswordlessLogin(ctx context.Context, userId primit
 	}
 	if !found {
 		Log.Error("Cannot find passwordless login for user with id: " + userId.Hex())
-		return nil, errors.New("not found")
+		return nil, NewNotFoundError("passwordless login not found or expired")
 	}
 
 	user, err := service.store.Get(ctx, userId)
-	jwtToken, err := service.jwtManager.GenerateJWT(user.Id.Hex(), user.Email, string(user.Role))
+	if err != nil {
+		Log.Error("Cannot find user with id: " + userId.Hex())
+		return nil, err
+	}
+	jwtToken, err := service.generateToken(ctx, user)
+	if err != nil {
+		Log.Error("Cannot generate JWT for user with id: " + userId.Hex())
+		return nil, err
+	}
 
 	Log.Info("Successful passwordless login for user with id: " + userId.Hex())
+	service.recordLogin(ctx, user, client)
 	return &userService.LoginResponse{UserId: user.Id.Hex(), Email: user.Email, Role: string(user.Role), Token: jwtToken, IsPrivate: user.Private}, nil
 }
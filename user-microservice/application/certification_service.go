@@ -0,0 +1,120 @@
+package application
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"user-microservice/model"
+)
+
+// Certification fields that can be named in a partial update.
+const (
+	CertificationName           = "name"
+	CertificationIssuer         = "issuer"
+	CertificationCredentialId   = "credentialId"
+	CertificationCredentialUrl  = "credentialUrl"
+	CertificationIssueDate      = "issueDate"
+	CertificationExpirationDate = "expirationDate"
+)
+
+type CertificationService struct {
+	store model.UserStore
+}
+
+func NewCertificationService(store model.UserStore) *CertificationService {
+	return &CertificationService{
+		store: store,
+	}
+}
+
+// GetByUserId returns valid certifications first, each group ordered from the
+// most recently issued.
+func (service *CertificationService) GetByUserId(ctx context.Context, userId string) ([]*model.Certification, error) {
+	Log.Info("Getting all certifications for user with id: " + userId)
+	certifications, err := service.store.GetCertificationsByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(certifications, func(i, j int) bool {
+		a, b := certifications[i], certifications[j]
+		if a.IsExpired() != b.IsExpired() {
+			return !a.IsExpired()
+		}
+		return a.IssueDate.After(b.IssueDate)
+	})
+	return certifications, nil
+}
+
+func (service *CertificationService) Create(ctx context.Context, certification *model.Certification) (*model.Certification, error) {
+	Log.Info("Creating new certification for user with id: " + certification.UserId)
+	err := validateCertification(certification)
+	if err != nil {
+		return nil, err
+	}
+	return service.store.CreateCertification(ctx, certification)
+}
+
+func (service *CertificationService) Update(ctx context.Context, userId primitive.ObjectID, changes *model.Certification, fields []string) (*model.Certification, error) {
+	Log.Info("Updating certification with id: " + changes.Id.Hex())
+	certification, err := service.getOwned(ctx, userId, changes.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = applyFieldMask(fields, fieldSetters{
+		CertificationName:           func() { certification.Name = changes.Name },
+		CertificationIssuer:         func() { certification.Issuer = changes.Issuer },
+		CertificationCredentialId:   func() { certification.CredentialId = changes.CredentialId },
+		CertificationCredentialUrl:  func() { certification.CredentialUrl = changes.CredentialUrl },
+		CertificationIssueDate:      func() { certification.IssueDate = changes.IssueDate },
+		CertificationExpirationDate: func() { certification.ExpirationDate = changes.ExpirationDate },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateCertification(certification)
+	if err != nil {
+		return nil, err
+	}
+	return service.store.UpdateCertification(ctx, certification.Id, certification)
+}
+
+func (service *CertificationService) Delete(ctx context.Context, userId primitive.ObjectID, certificationId primitive.ObjectID) error {
+	Log.Info("Deleting certification with id: " + certificationId.Hex())
+	_, err := service.getOwned(ctx, userId, certificationId)
+	if err != nil {
+		return err
+	}
+	return service.store.DeleteCertification(ctx, certificationId)
+}
+
+func (service *CertificationService) getOwned(ctx context.Context, userId primitive.ObjectID, certificationId primitive.ObjectID) (*model.Certification, error) {
+	certification, err := service.store.GetCertification(ctx, certificationId)
+	if err != nil {
+		return nil, notFoundOr(err, "certification not found")
+	}
+	if certification.UserId != userId.Hex() {
+		Log.Warn("User with id: " + userId.Hex() + " does not own certification with id: " + certificationId.Hex())
+		return nil, NewPermissionDeniedError("certification belongs to another user")
+	}
+	return certification, nil
+}
+
+func validateCertification(certification *model.Certification) error {
+	v := NewValidator()
+	if v.Required("certification.name", certification.Name) {
+		v.Length("certification.name", certification.Name, 1, 100)
+	}
+	if v.Required("certification.issuer", certification.Issuer) {
+		v.Length("certification.issuer", certification.Issuer, 1, 100)
+	}
+	v.Length("certification.credentialId", certification.CredentialId, 0, 100)
+	if certification.CredentialUrl != "" {
+		v.Url("certification.credentialUrl", certification.CredentialUrl)
+	}
+	if certification.IssueDate.IsZero() {
+		v.AddViolation("certification.issueDate", "is required")
+	}
+	v.DateOrder("certification.expirationDate", certification.IssueDate, certification.ExpirationDate)
+	return v.Err()
+}
@@ -0,0 +1,144 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+	"sort"
+	"strings"
+	"time"
+	"user-microservice/model"
+	"user-microservice/startup/config"
+)
+
+type profileCheck struct {
+	item       model.ProfileItem
+	weight     int64
+	suggestion string
+	done       func(user *model.User, experiences []*model.Experience) bool
+}
+
+// defaultProfileChecks lists every scored item in the order used to break
+// ties between items of equal weight.
+var defaultProfileChecks = []profileCheck{
+	{model.PROFILE_EXPERIENCE, 25, "Add your current or most recent position", func(user *model.User, experiences []*model.Experience) bool {
+		return len(experiences) > 0
+	}},
+	{model.PROFILE_SKILLS, 15, "Add a few skills so others can find and endorse you", func(user *model.User, experiences []*model.Experience) bool {
+		return len(user.Skills) > 0
+	}},
+	{model.PROFILE_BIO, 15, "Write a short bio about yourself", func(user *model.User, experiences []*model.Experience) bool {
+		return strings.TrimSpace(user.Bio) != ""
+	}},
+	{model.PROFILE_TWO_FACTOR, 15, "Turn on two-factor authentication to protect your account", func(user *model.User, experiences []*model.Experience) bool {
+		return user.TFAEnabled
+	}},
+	{model.PROFILE_PHONE_NUMBER, 10, "Add a phone number", func(user *model.User, experiences []*model.Experience) bool {
+		return user.PhoneNumber != ""
+	}},
+	{model.PROFILE_BIRTH_DATE, 10, "Add your date of birth", func(user *model.User, experiences []*model.Experience) bool {
+		return !user.BirthDate.IsZero()
+	}},
+	{model.PROFILE_INTERESTS, 10, "Tell us what you are interested in", func(user *model.User, experiences []*model.Experience) bool {
+		return len(user.Interests) > 0
+	}},
+}
+
+type CompletenessService struct {
+	store  model.UserStore
+	checks []profileCheck
+	total  int64
+}
+
+// NewCompletenessService applies the weights from the configuration on top of
+// the defaults. A weight of zero leaves the item out of the score.
+func NewCompletenessService(store model.UserStore, config *config.Config) *CompletenessService {
+	service := &CompletenessService{
+		store: store,
+	}
+	for name := range config.CompletenessWeights {
+		if slices.IndexFunc(defaultProfileChecks, func(check profileCheck) bool { return string(check.item) == name }) < 0 {
+			Log.Warn("Unknown profile completeness item in PROFILE_COMPLETENESS_WEIGHTS: " + name)
+		}
+	}
+	for _, check := range defaultProfileChecks {
+		if weight, ok := config.CompletenessWeights[string(check.item)]; ok {
+			check.weight = weight
+		}
+		if check.weight > 0 {
+			service.checks = append(service.checks, check)
+			service.total += check.weight
+		}
+	}
+	sort.SliceStable(service.checks, func(i, j int) bool {
+		return service.checks[i].weight > service.checks[j].weight
+	})
+	return service
+}
+
+// Get returns the cached score and computes it when there is none yet.
+func (service *CompletenessService) Get(ctx context.Context, userId primitive.ObjectID) (*model.ProfileCompleteness, error) {
+	Log.Info("Getting profile completeness for user with id: " + userId.Hex())
+	completeness, err := service.store.GetProfileCompleteness(ctx, userId.Hex())
+	if err == nil {
+		return completeness, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	return service.compute(ctx, userId)
+}
+
+// Refresh recomputes the cached score after a profile change. Failures are
+// logged rather than returned so that they never fail the change itself; the
+// next Get recomputes a score that could not be saved.
+func (service *CompletenessService) Refresh(ctx context.Context, userId primitive.ObjectID) {
+	_, err := service.compute(ctx, userId)
+	if err != nil {
+		Log.Warn("Cannot refresh profile completeness for user with id: " + userId.Hex() + ": " + err.Error())
+		err = service.store.DeleteProfileCompleteness(ctx, userId.Hex())
+		if err != nil {
+			Log.Error("Cannot drop stale profile completeness for user with id: " + userId.Hex())
+		}
+	}
+}
+
+func (service *CompletenessService) Delete(ctx context.Context, userId primitive.ObjectID) error {
+	return service.store.DeleteProfileCompleteness(ctx, userId.Hex())
+}
+
+func (service *CompletenessService) compute(ctx context.Context, userId primitive.ObjectID) (*model.ProfileCompleteness, error) {
+	user, err := service.store.Get(ctx, userId)
+	if err != nil {
+		return nil, notFoundOr(err, "user not found")
+	}
+	experiences, err := service.store.GetExperiencesByUserId(ctx, userId.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	completeness := &model.ProfileCompleteness{
+		UserId:     userId.Hex(),
+		Percentage: 100,
+		Missing:    []*model.MissingProfileItem{},
+		ComputedAt: time.Now(),
+	}
+	var achieved int64
+	for _, check := range service.checks {
+		if check.done(user, experiences) {
+			achieved += check.weight
+			continue
+		}
+		completeness.Missing = append(completeness.Missing, &model.MissingProfileItem{
+			Item:       check.item,
+			Weight:     check.weight,
+			Suggestion: check.suggestion,
+		})
+	}
+	if service.total > 0 {
+		completeness.Percentage = achieved * 100 / service.total
+	}
+	return service.store.SaveProfileCompleteness(ctx, completeness)
+}
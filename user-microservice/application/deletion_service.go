@@ -0,0 +1,280 @@
+package application
+
+import (
+	"context"
+	"errors"
+	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+	"time"
+	"user-microservice/model"
+)
+
+const (
+	deletionLease       = "userDeletions"
+	deletionLeaseTTL    = time.Minute
+	deletionPoll        = 30 * time.Second
+	deletionBatchSize   = 20
+	deletionStepTimeout = 30 * time.Second
+	deletionFirstRetry  = 5 * time.Second
+	deletionMaxRetry    = time.Hour
+)
+
+// DeletionService deletes users with a saga. Start removes the user document
+// right away and records the remaining clean-up steps; Run works through them
+// in the background, retrying failed steps until every one is done. Each
+// step is recorded as soon as it finishes, so after a crash at most the step
+// that was running is repeated, and every step is safe to repeat.
+type DeletionService struct {
+	store            model.UserStore
+	connectionClient connectionService.ConnectionServiceClient
+	tags             *TagService
+	images           *ImageService
+	completeness     *CompletenessService
+	search           model.SearchIndex
+	events           *EventService
+	owner            string
+	wake             chan struct{}
+}
+
+func NewDeletionService(store model.UserStore, connectionClient connectionService.ConnectionServiceClient, tags *TagService, images *ImageService, completeness *CompletenessService, search model.SearchIndex, events *EventService) *DeletionService {
+	return &DeletionService{
+		store:            store,
+		connectionClient: connectionClient,
+		tags:             tags,
+		images:           images,
+		completeness:     completeness,
+		search:           search,
+		events:           events,
+		owner:            uuid.New().String(),
+		wake:             make(chan struct{}, 1),
+	}
+}
+
+// Start deletes the user and schedules the clean-up. Deleting a user whose
+// deletion already started returns that deletion.
+func (service *DeletionService) Start(ctx context.Context, userId primitive.ObjectID) (*model.UserDeletion, error) {
+	Log.Info("Deleting user with id: " + userId.Hex())
+	deletion, err := service.store.GetUserDeletion(ctx, userId.Hex())
+	if err == nil {
+		return deletion, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	user, err := service.store.Get(ctx, userId)
+	if err != nil {
+		return nil, notFoundOr(err, "user not found")
+	}
+
+	now := time.Now()
+	deletion = &model.UserDeletion{
+		UserId:        userId.Hex(),
+		State:         model.DELETION_RUNNING,
+		Skills:        user.Skills,
+		Interests:     user.Interests,
+		NextAttemptAt: now,
+		StartedAt:     now,
+	}
+	for _, image := range []*model.Image{user.ProfilePicture, user.CoverImage} {
+		if image != nil {
+			deletion.Images = append(deletion.Images, image)
+		}
+	}
+	for _, name := range model.DeletionSteps {
+		deletion.Steps = append(deletion.Steps, &model.DeletionStep{Name: name, State: model.STEP_PENDING, UpdatedAt: now})
+	}
+	err = service.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		err := service.store.Delete(ctx, userId)
+		if err != nil {
+			return err
+		}
+		_, err = service.store.CreateUserDeletion(ctx, deletion)
+		if err != nil {
+			return err
+		}
+		return service.events.Emit(ctx, &model.UserDeleted{UserId: userId.Hex()})
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request started the same deletion in the meantime.
+		return service.store.GetUserDeletion(ctx, userId.Hex())
+	}
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case service.wake <- struct{}{}:
+	default:
+	}
+	Log.Info("Started deletion of user with id: " + userId.Hex())
+	return deletion, nil
+}
+
+func (service *DeletionService) Status(ctx context.Context, userId primitive.ObjectID) (*model.UserDeletion, error) {
+	deletion, err := service.store.GetUserDeletion(ctx, userId.Hex())
+	if err != nil {
+		return nil, notFoundOr(err, "user deletion not found")
+	}
+	return deletion, nil
+}
+
+// Run advances due deletions until ctx is done. Only the replica holding the
+// deletion lease runs them.
+func (service *DeletionService) Run(ctx context.Context) {
+	for {
+		service.runDue(ctx)
+		select {
+		case <-ctx.Done():
+			err := service.store.ReleaseLease(context.Background(), deletionLease, service.owner)
+			if err != nil {
+				Log.Warn("Cannot release the user deletion lease: " + err.Error())
+			}
+			return
+		case <-service.wake:
+		case <-time.After(deletionPoll):
+		}
+	}
+}
+
+func (service *DeletionService) runDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		if !renewLease(ctx, service.store, deletionLease, service.owner, deletionLeaseTTL) {
+			return
+		}
+		deletions, err := service.store.GetDueUserDeletions(ctx, time.Now(), deletionBatchSize)
+		if err != nil {
+			Log.Error("Cannot read pending user deletions: " + err.Error())
+			return
+		}
+		for _, deletion := range deletions {
+			err = service.advance(ctx, deletion)
+			if errors.Is(err, errLeaseLost) {
+				return
+			}
+			if err != nil {
+				Log.Error("Cannot record progress of deletion of user with id: " + deletion.UserId + ": " + err.Error())
+				return
+			}
+		}
+		if len(deletions) < deletionBatchSize {
+			return
+		}
+	}
+}
+
+// advance runs every step that is not done yet. Steps do not depend on each
+// other, so one failing does not hold up the rest. The lease is renewed
+// before every step, which the step timeout keeps well within the lease.
+func (service *DeletionService) advance(ctx context.Context, deletion *model.UserDeletion) error {
+	failed := false
+	for _, step := range deletion.Steps {
+		if step.State == model.STEP_DONE {
+			continue
+		}
+		if !renewLease(ctx, service.store, deletionLease, service.owner, deletionLeaseTTL) {
+			return errLeaseLost
+		}
+		err := service.runStep(ctx, deletion, step.Name)
+		step.Attempts++
+		step.UpdatedAt = time.Now()
+		if err != nil {
+			failed = true
+			step.State = model.STEP_FAILED
+			step.LastError = err.Error()
+			Log.Warn("Step " + string(step.Name) + " of deletion of user with id: " + deletion.UserId + " failed: " + err.Error())
+		} else {
+			step.State = model.STEP_DONE
+			step.LastError = ""
+		}
+		err = service.store.UpdateUserDeletion(ctx, deletion)
+		if err != nil {
+			return err
+		}
+	}
+
+	deletion.Attempts++
+	if failed {
+		deletion.NextAttemptAt = time.Now().Add(deletionRetryDelay(deletion.Attempts))
+	} else {
+		completedAt := time.Now()
+		deletion.State = model.DELETION_COMPLETED
+		deletion.CompletedAt = &completedAt
+		// Nothing of the user is kept once the clean-up is done.
+		deletion.Skills = nil
+		deletion.Interests = nil
+		deletion.Images = nil
+		deletion.ReleasedTags = nil
+		Log.Info("Completed deletion of user with id: " + deletion.UserId)
+	}
+	return service.store.UpdateUserDeletion(ctx, deletion)
+}
+
+func (service *DeletionService) runStep(ctx context.Context, deletion *model.UserDeletion, name model.DeletionStepName) error {
+	ctx, cancel := context.WithTimeout(ctx, deletionStepTimeout)
+	defer cancel()
+	userId, err := primitive.ObjectIDFromHex(deletion.UserId)
+	if err != nil {
+		return err
+	}
+	switch name {
+	case model.DELETE_ACCESS:
+		return service.store.DeleteUserAccess(ctx, deletion.UserId)
+	case model.DELETE_PROFILE_SECTIONS:
+		return service.store.DeleteUserProfileSections(ctx, deletion.UserId)
+	case model.DELETE_ENDORSEMENTS:
+		return service.store.DeleteUserEndorsements(ctx, deletion.UserId)
+	case model.DELETE_TAG_USAGE:
+		return service.releaseTags(ctx, deletion)
+	case model.DELETE_IMAGES:
+		return service.images.DeleteImages(ctx, deletion.Images)
+	case model.DELETE_COMPLETENESS:
+		return service.completeness.Delete(ctx, userId)
+	case model.DELETE_USERNAME_HISTORY:
+		return service.store.DeleteUsernameChanges(ctx, deletion.UserId)
+	case model.DELETE_SEARCH_INDEX:
+		return service.search.Remove(ctx, userId)
+	case model.DELETE_CONNECTIONS:
+		_, err = service.connectionClient.PurgeUser(ctx, &connectionService.UserIdRequest{UserId: deletion.UserId})
+		return err
+	}
+	return errors.New("unknown deletion step " + string(name))
+}
+
+// releaseTags lowers the usage of every tag of the deleted user once. Each
+// decrement is written in one transaction with a marker on the deletion, so
+// a repeated step skips the tags it already released.
+func (service *DeletionService) releaseTags(ctx context.Context, deletion *model.UserDeletion) error {
+	for _, id := range append(append([]string{}, deletion.Skills...), deletion.Interests...) {
+		if slices.Contains(deletion.ReleasedTags, id) {
+			continue
+		}
+		err := service.store.RunInTransaction(ctx, func(ctx context.Context) error {
+			released, err := service.store.MarkDeletionTagReleased(ctx, deletion.UserId, id)
+			if err != nil || !released {
+				return err
+			}
+			return service.tags.Release(ctx, id)
+		})
+		if err != nil {
+			return err
+		}
+		// The deletion is written back whole after every step, so it has to
+		// carry the markers as well.
+		deletion.ReleasedTags = append(deletion.ReleasedTags, id)
+	}
+	return nil
+}
+
+// deletionRetryDelay doubles with every failed round up to deletionMaxRetry.
+func deletionRetryDelay(attempts int64) time.Duration {
+	delay := deletionFirstRetry
+	for i := int64(1); i < attempts && delay < deletionMaxRetry; i++ {
+		delay *= 2
+	}
+	if delay > deletionMaxRetry {
+		return deletionMaxRetry
+	}
+	return delay
+}
@@ -0,0 +1,152 @@
+package application
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/exp/slices"
+	"net"
+	"time"
+	"user-microservice/model"
+)
+
+type DeviceService struct {
+	store model.UserStore
+}
+
+func NewDeviceService(store model.UserStore) *DeviceService {
+	return &DeviceService{
+		store: store,
+	}
+}
+
+// RecordLogin stores where a successful login came from and remembers the
+// device. The first login of a user only seeds the known devices; later logins
+// from an unseen device or network send a notification email.
+func (service *DeviceService) RecordLogin(ctx context.Context, user *model.User, client *model.ClientInfo) (*model.LoginRecord, error) {
+	Log.Info("Recording login for user with id: " + user.Id.Hex())
+	devices, err := service.store.GetKnownDevices(ctx, user.Id.Hex())
+	if err != nil {
+		Log.Error("Cannot get known devices for user with id: " + user.Id.Hex())
+		return nil, err
+	}
+
+	now := time.Now()
+	fingerprint := deviceFingerprint(client)
+	network := networkOf(client.Ip)
+
+	record := &model.LoginRecord{
+		UserId:      user.Id.Hex(),
+		Ip:          client.Ip,
+		Network:     network,
+		UserAgent:   client.UserAgent,
+		Fingerprint: fingerprint,
+		Time:        now,
+		NewDevice:   true,
+		NewNetwork:  true,
+	}
+
+	var device *model.KnownDevice
+	for _, known := range devices {
+		if known.Fingerprint == fingerprint {
+			device = known
+			record.NewDevice = false
+		}
+		if slices.Contains(known.Networks, network) {
+			record.NewNetwork = false
+		}
+	}
+
+	record, err = service.store.CreateLoginRecord(ctx, record)
+	if err != nil {
+		Log.Error("Cannot create login record for user with id: " + user.Id.Hex())
+		return nil, err
+	}
+
+	if device == nil {
+		device = &model.KnownDevice{
+			UserId:      user.Id.Hex(),
+			Fingerprint: fingerprint,
+			FirstSeen:   now,
+		}
+	}
+	device.UserAgent = client.UserAgent
+	device.LastIp = client.Ip
+	device.LastSeen = now
+	if !slices.Contains(device.Networks, network) {
+		device.Networks = append(device.Networks, network)
+	}
+	if device.Id.IsZero() {
+		_, err = service.store.CreateKnownDevice(ctx, device)
+	} else {
+		_, err = service.store.UpdateKnownDevice(ctx, device.Id, device)
+	}
+	if err != nil {
+		Log.Error("Cannot save known device for user with id: " + user.Id.Hex())
+		return nil, err
+	}
+
+	if len(devices) > 0 && (record.NewDevice || record.NewNetwork) {
+		Log.Warn("Login from unrecognized device or network for user with id: " + user.Id.Hex())
+		err = SendEmailForUnrecognizedLogin(ctx, user, record)
+		if err != nil {
+			Log.Error("Cannot send unrecognized login email for user with id: " + user.Id.Hex())
+		}
+	}
+	return record, nil
+}
+
+func (service *DeviceService) GetKnownDevices(ctx context.Context, userId primitive.ObjectID) ([]*model.KnownDevice, error) {
+	Log.Info("Getting known devices for user with id: " + userId.Hex())
+	return service.store.GetKnownDevices(ctx, userId.Hex())
+}
+
+func (service *DeviceService) RemoveKnownDevice(ctx context.Context, userId primitive.ObjectID, deviceId primitive.ObjectID) error {
+	Log.Info("Removing known device with id: " + deviceId.Hex() + " for user with id: " + userId.Hex())
+	devices, err := service.store.GetKnownDevices(ctx, userId.Hex())
+	if err != nil {
+		return err
+	}
+	for _, device := range devices {
+		if device.Id == deviceId {
+			return service.store.DeleteKnownDevice(ctx, deviceId)
+		}
+	}
+	Log.Warn("Known device with id: " + deviceId.Hex() + " does not belong to user with id: " + userId.Hex())
+	return NewNotFoundError("device not found")
+}
+
+func (service *DeviceService) GetLoginRecord(ctx context.Context, userId primitive.ObjectID, loginId primitive.ObjectID) (*model.LoginRecord, error) {
+	record, err := service.store.GetLoginRecord(ctx, loginId)
+	if err != nil {
+		return nil, notFoundOr(err, "login not found")
+	}
+	if record.UserId != userId.Hex() {
+		return nil, NewNotFoundError("login not found")
+	}
+	return record, nil
+}
+
+// deviceFingerprint prefers the fingerprint sent by the client and falls back
+// to a hash of the user agent.
+func deviceFingerprint(client *model.ClientInfo) string {
+	if client.Fingerprint != "" {
+		return client.Fingerprint
+	}
+	sum := sha256.Sum256([]byte(client.UserAgent))
+	return hex.EncodeToString(sum[:])
+}
+
+// networkOf reduces an address to its /24 (IPv4) or /48 (IPv6) network so
+// that a changing address within the same provider does not count as new.
+func networkOf(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
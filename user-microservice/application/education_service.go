@@ -0,0 +1,122 @@
+package application
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"user-microservice/model"
+)
+
+// Education fields that can be named in a partial update.
+const (
+	EducationInstitution  = "institution"
+	EducationDegree       = "degree"
+	EducationFieldOfStudy = "fieldOfStudy"
+	EducationGrade        = "grade"
+	EducationDescription  = "description"
+	EducationStartDate    = "startDate"
+	EducationEndDate      = "endDate"
+)
+
+type EducationService struct {
+	store model.UserStore
+}
+
+func NewEducationService(store model.UserStore) *EducationService {
+	return &EducationService{
+		store: store,
+	}
+}
+
+// GetByUserId returns ongoing studies first, then finished ones from the most
+// recently completed.
+func (service *EducationService) GetByUserId(ctx context.Context, userId string) ([]*model.Education, error) {
+	Log.Info("Getting all education for user with id: " + userId)
+	educations, err := service.store.GetEducationsByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(educations, func(i, j int) bool {
+		a, b := educations[i], educations[j]
+		if a.IsCurrent() != b.IsCurrent() {
+			return a.IsCurrent()
+		}
+		if !a.EndDate.Equal(b.EndDate) {
+			return a.EndDate.After(b.EndDate)
+		}
+		return a.StartDate.After(b.StartDate)
+	})
+	return educations, nil
+}
+
+func (service *EducationService) Create(ctx context.Context, education *model.Education) (*model.Education, error) {
+	Log.Info("Creating new education for user with id: " + education.UserId)
+	err := validateEducation(education)
+	if err != nil {
+		return nil, err
+	}
+	return service.store.CreateEducation(ctx, education)
+}
+
+func (service *EducationService) Update(ctx context.Context, userId primitive.ObjectID, changes *model.Education, fields []string) (*model.Education, error) {
+	Log.Info("Updating education with id: " + changes.Id.Hex())
+	education, err := service.getOwned(ctx, userId, changes.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = applyFieldMask(fields, fieldSetters{
+		EducationInstitution:  func() { education.Institution = changes.Institution },
+		EducationDegree:       func() { education.Degree = changes.Degree },
+		EducationFieldOfStudy: func() { education.FieldOfStudy = changes.FieldOfStudy },
+		EducationGrade:        func() { education.Grade = changes.Grade },
+		EducationDescription:  func() { education.Description = changes.Description },
+		EducationStartDate:    func() { education.StartDate = changes.StartDate },
+		EducationEndDate:      func() { education.EndDate = changes.EndDate },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateEducation(education)
+	if err != nil {
+		return nil, err
+	}
+	return service.store.UpdateEducation(ctx, education.Id, education)
+}
+
+func (service *EducationService) Delete(ctx context.Context, userId primitive.ObjectID, educationId primitive.ObjectID) error {
+	Log.Info("Deleting education with id: " + educationId.Hex())
+	_, err := service.getOwned(ctx, userId, educationId)
+	if err != nil {
+		return err
+	}
+	return service.store.DeleteEducation(ctx, educationId)
+}
+
+func (service *EducationService) getOwned(ctx context.Context, userId primitive.ObjectID, educationId primitive.ObjectID) (*model.Education, error) {
+	education, err := service.store.GetEducation(ctx, educationId)
+	if err != nil {
+		return nil, notFoundOr(err, "education not found")
+	}
+	if education.UserId != userId.Hex() {
+		Log.Warn("User with id: " + userId.Hex() + " does not own education with id: " + educationId.Hex())
+		return nil, NewPermissionDeniedError("education belongs to another user")
+	}
+	return education, nil
+}
+
+func validateEducation(education *model.Education) error {
+	v := NewValidator()
+	if v.Required("education.institution", education.Institution) {
+		v.Length("education.institution", education.Institution, 1, 100)
+	}
+	v.Length("education.degree", education.Degree, 0, 100)
+	v.Length("education.fieldOfStudy", education.FieldOfStudy, 0, 100)
+	v.Length("education.grade", education.Grade, 0, 20)
+	v.Length("education.description", education.Description, 0, 2000)
+	if education.StartDate.IsZero() {
+		v.AddViolation("education.startDate", "is required")
+	}
+	v.DateOrder("education.endDate", education.StartDate, education.EndDate)
+	return v.Err()
+}
@@ -0,0 +1,206 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"time"
+	"user-microservice/model"
+)
+
+const (
+	emailChangeValidity = 24 * time.Hour
+	emailRevertValidity = 7 * 24 * time.Hour
+)
+
+// EmailChangeService moves an account to a new email address. The new address
+// has to be confirmed from a link sent to it, and the old address is told
+// about the change with a link that undoes it.
+type EmailChangeService struct {
+	store model.UserStore
+	audit *AuditService
+}
+
+func NewEmailChangeService(store model.UserStore, audit *AuditService) *EmailChangeService {
+	return &EmailChangeService{
+		store: store,
+		audit: audit,
+	}
+}
+
+// Request starts a change to newEmail, replacing any change of the user that
+// is still waiting for confirmation.
+func (service *EmailChangeService) Request(ctx context.Context, userId primitive.ObjectID, newEmail string, revokeSessions bool) error {
+	Log.Info("Requesting email change for user with id: " + userId.Hex())
+	newEmail = strings.TrimSpace(newEmail)
+	user, err := service.store.Get(ctx, userId)
+	if err != nil {
+		return notFoundOr(err, "user not found")
+	}
+	if strings.EqualFold(user.Email, newEmail) {
+		return NewFailedPreconditionError("this is already the email address of the account")
+	}
+	err = service.checkAvailable(ctx, newEmail)
+	if err != nil {
+		return err
+	}
+
+	err = service.store.DeletePendingEmailChanges(ctx, userId.Hex())
+	if err != nil {
+		return err
+	}
+	token, err := newEmailToken()
+	if err != nil {
+		return err
+	}
+	change, err := service.store.CreateEmailChange(ctx, &model.EmailChange{
+		UserId:           userId.Hex(),
+		OldEmail:         user.Email,
+		NewEmail:         newEmail,
+		ConfirmTokenHash: hashToken(token),
+		ValidTo:          time.Now().Add(emailChangeValidity),
+		RevokeSessions:   revokeSessions,
+	})
+	if err != nil {
+		Log.Error("Cannot create email change for user with id: " + userId.Hex())
+		return err
+	}
+	err = SendEmailForEmailChange(ctx, user, change, token)
+	if err != nil {
+		Log.Error("Cannot send email change mail for user with id: " + userId.Hex())
+		return err
+	}
+	service.audit.Record(ctx, model.EMAIL_CHANGE_REQUESTED, userId.Hex(), map[string]string{"changeId": change.Id.Hex()})
+	return nil
+}
+
+// Confirm applies a requested change once the link sent to the new address is
+// followed, then sends the revert link to the old one.
+func (service *EmailChangeService) Confirm(ctx context.Context, changeId primitive.ObjectID, token string) error {
+	Log.Info("Confirming email change with id: " + changeId.Hex())
+	change, err := service.store.GetEmailChange(ctx, changeId)
+	if err != nil {
+		return notFoundOr(err, "email change not found")
+	}
+	if change.Confirmed || !tokenMatches(token, change.ConfirmTokenHash) || time.Now().After(change.ValidTo) {
+		Log.Warn("Invalid or expired confirmation of email change with id: " + changeId.Hex())
+		return NewFailedPreconditionError("confirmation link is invalid or has expired")
+	}
+	err = service.checkAvailable(ctx, change.NewEmail)
+	if err != nil {
+		return err
+	}
+	user, err := service.switchEmail(ctx, change.UserId, change.OldEmail, change.NewEmail)
+	if err != nil {
+		return err
+	}
+
+	revertToken, err := newEmailToken()
+	if err != nil {
+		return err
+	}
+	change.Confirmed = true
+	change.RevertTokenHash = hashToken(revertToken)
+	change.RevertValidTo = time.Now().Add(emailRevertValidity)
+	err = service.store.UpdateEmailChange(ctx, change)
+	if err != nil {
+		Log.Error("Cannot save confirmed email change with id: " + changeId.Hex())
+		return err
+	}
+	if change.RevokeSessions {
+		err = service.store.RevokeUserSessions(ctx, change.UserId)
+		if err != nil {
+			Log.Error("Cannot revoke sessions for user with id: " + change.UserId)
+		}
+	}
+	err = SendEmailForEmailChanged(ctx, user, change, revertToken)
+	if err != nil {
+		Log.Error("Cannot notify old email address of user with id: " + change.UserId)
+	}
+	service.audit.Record(ctx, model.EMAIL_CHANGED, change.UserId, map[string]string{"changeId": change.Id.Hex()})
+	return nil
+}
+
+// Revert puts the old address back from the link sent to it. A revert hints
+// at a taken over account, so every session is revoked as well.
+func (service *EmailChangeService) Revert(ctx context.Context, changeId primitive.ObjectID, token string) error {
+	Log.Warn("Reverting email change with id: " + changeId.Hex())
+	change, err := service.store.GetEmailChange(ctx, changeId)
+	if err != nil {
+		return notFoundOr(err, "email change not found")
+	}
+	if !change.Confirmed || change.Reverted || !tokenMatches(token, change.RevertTokenHash) || time.Now().After(change.RevertValidTo) {
+		Log.Warn("Invalid or expired revert of email change with id: " + changeId.Hex())
+		return NewFailedPreconditionError("revert link is invalid or has expired")
+	}
+	err = service.checkAvailable(ctx, change.OldEmail)
+	if err != nil {
+		return err
+	}
+	_, err = service.switchEmail(ctx, change.UserId, change.NewEmail, change.OldEmail)
+	if err != nil {
+		return err
+	}
+
+	change.Reverted = true
+	err = service.store.UpdateEmailChange(ctx, change)
+	if err != nil {
+		Log.Error("Cannot save reverted email change with id: " + changeId.Hex())
+		return err
+	}
+	err = service.store.RevokeUserSessions(ctx, change.UserId)
+	if err != nil {
+		Log.Error("Cannot revoke sessions for user with id: " + change.UserId)
+		return err
+	}
+	service.audit.Record(ctx, model.EMAIL_CHANGE_REVERTED, change.UserId, map[string]string{"changeId": change.Id.Hex()})
+	return nil
+}
+
+// checkAvailable makes sure no account uses email, since it is also a login
+// identifier.
+func (service *EmailChangeService) checkAvailable(ctx context.Context, email string) error {
+	_, err := service.store.GetByEmail(ctx, email)
+	if err == nil {
+		Log.Warn("Email already exists")
+		return NewAlreadyExistsError("email already exists")
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	return nil
+}
+
+func (service *EmailChangeService) switchEmail(ctx context.Context, userId string, from string, to string) (*model.User, error) {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return nil, NewNotFoundError("user not found")
+	}
+	user, err := service.store.ChangeEmail(ctx, id, from, to)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		Log.Warn("Email of user with id: " + userId + " changed in the meantime")
+		return nil, NewFailedPreconditionError("email address of the account changed in the meantime")
+	}
+	if err != nil {
+		return nil, alreadyExistsOr(err, "email already exists")
+	}
+	return user, nil
+}
+
+func newEmailToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+func tokenMatches(token string, hash string) bool {
+	return hash != "" && subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(hash)) == 1
+}
@@ -2,7 +2,9 @@ package application
 
 import (
 	"context"
+	"html"
 	"net/smtp"
+	"time"
 	"user-microservice/application/smtp_login"
 	"user-microservice/model"
 	cfg "user-microservice/startup/config"
@@ -97,3 +99,91 @@ func SendEmailForPasswordlessLogin(ctx context.Context, user *model.User, passwo
 	Log.Info("Email send successfully for passwordless login for user with id: " + user.Id.Hex())
 	return nil
 }
+
+func SendEmailForUnrecognizedLogin(ctx context.Context, user *model.User, record *model.LoginRecord) error {
+	Log.Info("Starting send email for unrecognized login for user with id: " + user.Id.Hex())
+	config := cfg.NewConfig()
+	from := config.Email
+	password := config.EmailPassword
+
+	toEmailAddress := user.Email
+	to := []string{toEmailAddress}
+
+	host := "smtp-mail.outlook.com"
+	port := "587"
+	address := host + ":" + port
+	url := "https://localhost:4200/not-me/" + user.Id.Hex() + "/" + record.Id.Hex()
+
+	subject := "Subject: New sign-in to your dislinkt account\n"
+	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+	body := "\nPozdrav " + user.Name + ",<br>" + "Prijavljeni ste na svoj nalog sa novog uređaja ili mreže:<br>" + "IP adresa: " + html.EscapeString(record.Ip) + "<br>" + "Uređaj: " + html.EscapeString(record.UserAgent) + "<br>" + "Vreme: " + record.Time.Format(time.RFC1123) + "<br>" + "Ako ovo niste bili vi, posetite sledeću stranicu:<br>" + "<h1><a href=" + url + " target=\"_self\">OVO NISAM BIO/LA JA</a></h1> " + "Hvala,<br>" + "Dislinkt."
+	message := []byte(subject + mime + body)
+
+	auth := smtp_login.LoginAuth(from, password)
+
+	err := smtp.SendMail(address, auth, from, to, message)
+	if err != nil {
+		return err
+	}
+
+	Log.Info("Email send successfully for unrecognized login for user with id: " + user.Id.Hex())
+	return nil
+}
+
+func SendEmailForEmailChange(ctx context.Context, user *model.User, change *model.EmailChange, token string) error {
+	Log.Info("Starting send email for email change for user with id: " + user.Id.Hex())
+	config := cfg.NewConfig()
+	from := config.Email
+	password := config.EmailPassword
+
+	to := []string{change.NewEmail}
+
+	host := "smtp-mail.outlook.com"
+	port := "587"
+	address := host + ":" + port
+	url := "https://localhost:4200/confirm-email/" + change.Id.Hex() + "/" + token
+
+	subject := "Subject: Confirm your new email address\n"
+	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+	body := "\nPozdrav " + user.Name + ",<br>" + "Da biste potvrdili novu email adresu svog naloga, posetite sledeću stranicu:<br>" + "<h1><a href=" + url + " target=\"_self\">POTVRDI</a></h1> " + "Ako niste tražili promenu, ignorišite ovu poruku.<br>" + "Hvala,<br>" + "Dislinkt."
+	message := []byte(subject + mime + body)
+
+	auth := smtp_login.LoginAuth(from, password)
+
+	err := smtp.SendMail(address, auth, from, to, message)
+	if err != nil {
+		return err
+	}
+
+	Log.Info("Email send successfully for email change for user with id: " + user.Id.Hex())
+	return nil
+}
+
+func SendEmailForEmailChanged(ctx context.Context, user *model.User, change *model.EmailChange, token string) error {
+	Log.Info("Starting send email for changed email for user with id: " + user.Id.Hex())
+	config := cfg.NewConfig()
+	from := config.Email
+	password := config.EmailPassword
+
+	to := []string{change.OldEmail}
+
+	host := "smtp-mail.outlook.com"
+	port := "587"
+	address := host + ":" + port
+	url := "https://localhost:4200/revert-email/" + change.Id.Hex() + "/" + token
+
+	subject := "Subject: Your dislinkt email address was changed\n"
+	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+	body := "\nPozdrav " + user.Name + ",<br>" + "Email adresa vašeg naloga je promenjena u " + html.EscapeString(change.NewEmail) + ".<br>" + "Ako ovo niste bili vi, posetite sledeću stranicu da vratite staru adresu:<br>" + "<h1><a href=" + url + " target=\"_self\">VRATI STARU ADRESU</a></h1> " + "Hvala,<br>" + "Dislinkt."
+	message := []byte(subject + mime + body)
+
+	auth := smtp_login.LoginAuth(from, password)
+
+	err := smtp.SendMail(address, auth, from, to, message)
+	if err != nil {
+		return err
+	}
+
+	Log.Info("Email send successfully for changed email for user with id: " + user.Id.Hex())
+	return nil
+}
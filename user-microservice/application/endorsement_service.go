@@ -0,0 +1,157 @@
+package application
+
+import (
+	"context"
+	"errors"
+	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+	"time"
+	"user-microservice/model"
+)
+
+type EndorsementService struct {
+	store            model.UserStore
+	tags             *TagService
+	connectionClient connectionService.ConnectionServiceClient
+}
+
+func NewEndorsementService(store model.UserStore, connectionClient connectionService.ConnectionServiceClient, tags *TagService) *EndorsementService {
+	return &EndorsementService{
+		store:            store,
+		tags:             tags,
+		connectionClient: connectionClient,
+	}
+}
+
+// Endorse lets endorserId vouch for one of the skills on the profile of
+// userId. Blocked users cannot endorse each other and private profiles only
+// accept endorsements from their connections.
+func (service *EndorsementService) Endorse(ctx context.Context, userId primitive.ObjectID, skill string, endorserId primitive.ObjectID) (*model.Endorsement, error) {
+	Log.Info("User with id: " + endorserId.Hex() + " endorsing skill " + skill + " of user with id: " + userId.Hex())
+	if userId == endorserId {
+		return nil, NewFailedPreconditionError("users cannot endorse their own skills")
+	}
+	user, err := service.store.Get(ctx, userId)
+	if err != nil {
+		return nil, notFoundOr(err, "user not found")
+	}
+	skillId, err := service.userSkill(ctx, user, skill)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := service.connectionClient.IsBlockedAny(ctx, &connectionService.Block{UserId: userId.Hex(), BlockUserId: endorserId.Hex()})
+	if err != nil {
+		return nil, err
+	}
+	if blocked.Blocked {
+		Log.Warn("Blocked user with id: " + endorserId.Hex() + " tried to endorse user with id: " + userId.Hex())
+		return nil, NewPermissionDeniedError("cannot endorse this user")
+	}
+	if user.Private {
+		connected, err := service.connectionClient.IsConnected(ctx, &connectionService.Connection{UserId: userId.Hex(), ConnectedUserId: endorserId.Hex()})
+		if err != nil {
+			return nil, err
+		}
+		if !connected.Connected {
+			return nil, NewPermissionDeniedError("only connections can endorse skills on a private profile")
+		}
+	}
+
+	_, err = service.store.GetEndorsement(ctx, userId.Hex(), skillId, endorserId.Hex())
+	if err == nil {
+		return nil, NewAlreadyExistsError("skill already endorsed")
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	endorsement, err := service.store.CreateEndorsement(ctx, &model.Endorsement{
+		UserId:     userId.Hex(),
+		SkillId:    skillId,
+		EndorserId: endorserId.Hex(),
+		Timestamp:  time.Now(),
+	})
+	if err != nil {
+		return nil, alreadyExistsOr(err, "skill already endorsed")
+	}
+	return endorsement, nil
+}
+
+// Remove withdraws an endorsement. Only the endorser can remove it, which is
+// enforced by looking it up under their id.
+func (service *EndorsementService) Remove(ctx context.Context, userId primitive.ObjectID, skill string, endorserId primitive.ObjectID) error {
+	Log.Info("User with id: " + endorserId.Hex() + " removing endorsement of skill " + skill + " of user with id: " + userId.Hex())
+	skillId := skill
+	if tag, err := service.tags.Find(ctx, model.SKILL, skill); err == nil {
+		skillId = tag.Id.Hex()
+	}
+	endorsement, err := service.store.GetEndorsement(ctx, userId.Hex(), skillId, endorserId.Hex())
+	if err != nil {
+		return notFoundOr(err, "endorsement not found")
+	}
+	return service.store.DeleteEndorsement(ctx, endorsement.Id)
+}
+
+// GetByUserId returns the endorsements of a user, newest first, leaving out
+// those from endorsers the user is blocked with.
+func (service *EndorsementService) GetByUserId(ctx context.Context, userId primitive.ObjectID) ([]*model.Endorsement, error) {
+	Log.Info("Getting endorsements for user with id: " + userId.Hex())
+	endorsements, err := service.store.GetEndorsementsByUserId(ctx, userId.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return service.visible(ctx, userId.Hex(), endorsements)
+}
+
+// CountBySkill returns how many distinct visible endorsers each skill of the
+// user has, keyed by skill tag id.
+func (service *EndorsementService) CountBySkill(ctx context.Context, user *model.User) (map[string]int64, error) {
+	endorsements, err := service.GetByUserId(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	endorsers := map[string][]string{}
+	for _, endorsement := range endorsements {
+		if !slices.Contains(user.Skills, endorsement.SkillId) || slices.Contains(endorsers[endorsement.SkillId], endorsement.EndorserId) {
+			continue
+		}
+		endorsers[endorsement.SkillId] = append(endorsers[endorsement.SkillId], endorsement.EndorserId)
+		counts[endorsement.SkillId]++
+	}
+	return counts, nil
+}
+
+func (service *EndorsementService) visible(ctx context.Context, userId string, endorsements []*model.Endorsement) ([]*model.Endorsement, error) {
+	if len(endorsements) == 0 {
+		return endorsements, nil
+	}
+	response, err := service.connectionClient.GetBlockedAny(ctx, &connectionService.UserIdRequest{UserId: userId})
+	if err != nil {
+		return nil, err
+	}
+	blocked := map[string]bool{}
+	for _, id := range response.UserIds {
+		blocked[id] = true
+	}
+	var result []*model.Endorsement
+	for _, endorsement := range endorsements {
+		if !blocked[endorsement.EndorserId] {
+			result = append(result, endorsement)
+		}
+	}
+	return result, nil
+}
+
+func (service *EndorsementService) userSkill(ctx context.Context, user *model.User, skill string) (string, error) {
+	tag, err := service.tags.Find(ctx, model.SKILL, skill)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+	if err != nil || !slices.Contains(user.Skills, tag.Id.Hex()) {
+		return "", NewNotFoundError("user does not have this skill")
+	}
+	return tag.Id.Hex(), nil
+}
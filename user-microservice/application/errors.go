@@ -0,0 +1,101 @@
+package application
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+)
+
+type ErrorKind int
+
+const (
+	NotFound ErrorKind = iota + 1
+	AlreadyExists
+	InvalidArgument
+	Unauthenticated
+	PermissionDenied
+	FailedPrecondition
+	Aborted
+)
+
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// DomainError is returned by the application services for every failure the
+// caller can act on. The API layer translates the kind into a gRPC status
+// code; anything that is not a DomainError is treated as an internal error.
+type DomainError struct {
+	Kind       ErrorKind
+	Message    string
+	Violations []FieldViolation
+	Err        error
+}
+
+func (e *DomainError) Error() string {
+	if len(e.Violations) == 0 {
+		return e.Message
+	}
+	descriptions := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		descriptions = append(descriptions, violation.Field+": "+violation.Description)
+	}
+	return e.Message + " (" + strings.Join(descriptions, "; ") + ")"
+}
+
+func (e *DomainError) Unwrap() error {
+	return e.Err
+}
+
+func NewNotFoundError(message string) error {
+	return &DomainError{Kind: NotFound, Message: message}
+}
+
+func NewAlreadyExistsError(message string) error {
+	return &DomainError{Kind: AlreadyExists, Message: message}
+}
+
+func NewInvalidArgumentError(message string, violations ...FieldViolation) error {
+	return &DomainError{Kind: InvalidArgument, Message: message, Violations: violations}
+}
+
+func NewUnauthenticatedError(message string) error {
+	return &DomainError{Kind: Unauthenticated, Message: message}
+}
+
+func NewPermissionDeniedError(message string) error {
+	return &DomainError{Kind: PermissionDenied, Message: message}
+}
+
+func NewFailedPreconditionError(message string) error {
+	return &DomainError{Kind: FailedPrecondition, Message: message}
+}
+
+func NewAbortedError(message string) error {
+	return &DomainError{Kind: Aborted, Message: message}
+}
+
+// IsErrorKind reports whether err is a DomainError of the given kind.
+func IsErrorKind(err error, kind ErrorKind) bool {
+	var domainError *DomainError
+	return errors.As(err, &domainError) && domainError.Kind == kind
+}
+
+// alreadyExistsOr translates a unique index violation into an AlreadyExists
+// error carrying the given message and leaves every other error untouched.
+func alreadyExistsOr(err error, message string) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return &DomainError{Kind: AlreadyExists, Message: message, Err: err}
+	}
+	return err
+}
+
+// notFoundOr translates a missing document into a NotFound error carrying the
+// given message and leaves every other error untouched.
+func notFoundOr(err error, message string) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &DomainError{Kind: NotFound, Message: message, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+	"user-microservice/model"
+)
+
+const (
+	outboxRelayLease   = "outboxRelay"
+	outboxLeaseTTL     = time.Minute
+	outboxPublishLimit = 30 * time.Second
+	outboxPollInterval = 10 * time.Second
+	outboxBatchSize    = 100
+	outboxFirstRetry   = time.Second
+	outboxMaxRetry     = 10 * time.Minute
+)
+
+// EventService emits domain events through the outbox and relays them to the
+// publisher. Emit has to be called inside the store transaction that makes
+// the change, so that an event exists exactly when its change does.
+type EventService struct {
+	store     model.UserStore
+	publisher model.EventPublisher
+	feed      model.OutboxFeed
+	owner     string
+}
+
+func NewEventService(store model.UserStore, publisher model.EventPublisher, feed model.OutboxFeed) *EventService {
+	return &EventService{
+		store:     store,
+		publisher: publisher,
+		feed:      feed,
+		owner:     uuid.New().String(),
+	}
+}
+
+// Emit stores the event in the outbox under a new idempotency key.
+func (service *EventService) Emit(ctx context.Context, event model.DomainEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = service.store.CreateOutboxEvent(ctx, &model.OutboxEvent{
+		Key:           uuid.New().String(),
+		Type:          event.EventType(),
+		UserId:        event.EventUserId(),
+		Payload:       string(payload),
+		OccurredAt:    now,
+		NextAttemptAt: now,
+	})
+	return err
+}
+
+// Relay publishes outbox events until ctx is done. Only the replica holding
+// the relay lease publishes, so events of a user go out in order. It wakes up
+// on new events when the database reports them and polls either way.
+func (service *EventService) Relay(ctx context.Context) {
+	added := make(chan struct{}, 1)
+	go service.watch(ctx, added)
+	for {
+		service.relayPending(ctx)
+		select {
+		case <-ctx.Done():
+			err := service.store.ReleaseLease(context.Background(), outboxRelayLease, service.owner)
+			if err != nil {
+				Log.Warn("Cannot release the outbox relay lease: " + err.Error())
+			}
+			return
+		case <-added:
+		case <-time.After(outboxPollInterval):
+		}
+	}
+}
+
+func (service *EventService) watch(ctx context.Context, added chan<- struct{}) {
+	for ctx.Err() == nil {
+		err := service.feed.Follow(ctx, func() {
+			select {
+			case added <- struct{}{}:
+			default:
+			}
+		})
+		if errors.Is(err, model.ErrFeedUnsupported) {
+			Log.Info("Database has no change feed, outbox events are published on every poll")
+			return
+		}
+		if err != nil {
+			Log.Warn("Watching the outbox failed: " + err.Error())
+			select {
+			case <-ctx.Done():
+			case <-time.After(outboxPollInterval):
+			}
+		}
+	}
+}
+
+// relayPending publishes every event that is due. Once an event of a user
+// fails or waits for a retry, the later events of that user wait too.
+func (service *EventService) relayPending(ctx context.Context) {
+	after := primitive.NilObjectID
+	held := map[string]bool{}
+	for ctx.Err() == nil {
+		if !renewLease(ctx, service.store, outboxRelayLease, service.owner, outboxLeaseTTL) {
+			return
+		}
+		events, err := service.store.GetPendingOutboxEvents(ctx, after, outboxBatchSize)
+		if err != nil {
+			Log.Error("Cannot read the outbox: " + err.Error())
+			return
+		}
+		now := time.Now()
+		for _, event := range events {
+			after = event.Id
+			if held[event.UserId] || event.NextAttemptAt.After(now) {
+				held[event.UserId] = true
+				continue
+			}
+			// The lease is renewed for every event and a publish is cut off
+			// well before it expires, so no other replica relays meanwhile.
+			if !renewLease(ctx, service.store, outboxRelayLease, service.owner, outboxLeaseTTL) {
+				return
+			}
+			publishCtx, cancel := context.WithTimeout(ctx, outboxPublishLimit)
+			err = service.publisher.Publish(publishCtx, event)
+			cancel()
+			if err != nil {
+				held[event.UserId] = true
+				Log.Warn("Publishing event " + string(event.Type) + " with key " + event.Key + " failed: " + err.Error())
+				err = service.store.MarkOutboxEventFailed(ctx, event.Id, time.Now().Add(outboxRetryDelay(event.Attempts)), err.Error())
+				if err != nil {
+					Log.Error("Cannot record failed publish of event with key " + event.Key + ": " + err.Error())
+					return
+				}
+				continue
+			}
+			// Should this fail, the event is published again, which the key
+			// makes harmless.
+			err = service.store.MarkOutboxEventPublished(ctx, event.Id, time.Now())
+			if err != nil {
+				Log.Error("Cannot mark event with key " + event.Key + " published: " + err.Error())
+				return
+			}
+			Log.Info("Published event " + string(event.Type) + " of user with id: " + event.UserId)
+		}
+		if len(events) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// outboxRetryDelay doubles with every failed attempt up to outboxMaxRetry.
+func outboxRetryDelay(attempts int64) time.Duration {
+	delay := outboxFirstRetry
+	for i := int64(0); i < attempts && delay < outboxMaxRetry; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxRetry {
+		return outboxMaxRetry
+	}
+	return delay
+}
@@ -3,31 +3,142 @@ package application
 import (
 	"context"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/exp/slices"
+	"sort"
 	"user-microservice/model"
 )
 
+// Experience fields that can be named in a partial update.
+const (
+	ExperienceName           = "name"
+	ExperienceTitle          = "title"
+	ExperienceStartDate      = "startDate"
+	ExperienceEndDate        = "endDate"
+	ExperienceTypeField      = "experienceType"
+	ExperienceEmploymentType = "employmentType"
+	ExperienceLocation       = "location"
+	ExperienceDescription    = "description"
+)
+
 type ExperienceService struct {
-	store model.UserStore
+	store        model.UserStore
+	completeness *CompletenessService
 }
 
-func NewExperienceService(store model.UserStore) *ExperienceService {
+func NewExperienceService(store model.UserStore, completeness *CompletenessService) *ExperienceService {
 	return &ExperienceService{
-		store: store,
+		store:        store,
+		completeness: completeness,
 	}
 }
 
+// GetByUserId returns current positions first, then past ones from the most
+// recently finished.
 func (service *ExperienceService) GetByUserId(ctx context.Context, id string) ([]*model.Experience, error) {
 	Log.Info("Getting all experience for user with id: " + id)
-	return service.store.GetExperiencesByUserId(ctx, id)
+	experiences, err := service.store.GetExperiencesByUserId(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(experiences, func(i, j int) bool {
+		a, b := experiences[i], experiences[j]
+		if a.IsCurrent() != b.IsCurrent() {
+			return a.IsCurrent()
+		}
+		if !a.EndDate.Equal(b.EndDate) {
+			return a.EndDate.After(b.EndDate)
+		}
+		return a.StartDate.After(b.StartDate)
+	})
+	return experiences, nil
 }
 
 func (service *ExperienceService) Create(ctx context.Context, experience *model.Experience) (*model.Experience, error) {
 	Log.Info("Creating new experience for user with id: " + experience.UserId)
-	return service.store.CreateExperience(ctx, experience)
+	err := validateExperience(experience)
+	if err != nil {
+		return nil, err
+	}
+	experience, err = service.store.CreateExperience(ctx, experience)
+	if err != nil {
+		return nil, err
+	}
+	if userId, err := primitive.ObjectIDFromHex(experience.UserId); err == nil {
+		service.completeness.Refresh(ctx, userId)
+	}
+	return experience, nil
 }
 
-func (service *ExperienceService) Delete(ctx context.Context, expId primitive.ObjectID) error {
+// Update copies the named fields from changes onto the stored experience. An
+// empty field list replaces every editable field.
+func (service *ExperienceService) Update(ctx context.Context, userId primitive.ObjectID, changes *model.Experience, fields []string) (*model.Experience, error) {
+	Log.Info("Updating experience with id: " + changes.Id.Hex())
+	experience, err := service.getOwned(ctx, userId, changes.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = applyFieldMask(fields, fieldSetters{
+		ExperienceName:           func() { experience.Name = changes.Name },
+		ExperienceTitle:          func() { experience.Title = changes.Title },
+		ExperienceStartDate:      func() { experience.StartDate = changes.StartDate },
+		ExperienceEndDate:        func() { experience.EndDate = changes.EndDate },
+		ExperienceTypeField:      func() { experience.ExperienceType = changes.ExperienceType },
+		ExperienceEmploymentType: func() { experience.EmploymentType = changes.EmploymentType },
+		ExperienceLocation:       func() { experience.Location = changes.Location },
+		ExperienceDescription:    func() { experience.Description = changes.Description },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateExperience(experience)
+	if err != nil {
+		return nil, err
+	}
+	Log.Info("Experience with id: " + experience.Id.Hex() + " updated")
+	return service.store.UpdateExperience(ctx, experience.Id, experience)
+}
+
+func (service *ExperienceService) Delete(ctx context.Context, userId primitive.ObjectID, expId primitive.ObjectID) error {
 	Log.Info("Deleting experience with id: " + expId.Hex())
-	return service.store.DeleteExperience(ctx, expId)
+	_, err := service.getOwned(ctx, userId, expId)
+	if err != nil {
+		return err
+	}
+	err = service.store.DeleteExperience(ctx, expId)
+	if err != nil {
+		return err
+	}
+	service.completeness.Refresh(ctx, userId)
+	return nil
+}
 
+func (service *ExperienceService) getOwned(ctx context.Context, userId primitive.ObjectID, expId primitive.ObjectID) (*model.Experience, error) {
+	experience, err := service.store.GetExperience(ctx, expId)
+	if err != nil {
+		return nil, notFoundOr(err, "experience not found")
+	}
+	if experience.UserId != userId.Hex() {
+		Log.Warn("User with id: " + userId.Hex() + " does not own experience with id: " + expId.Hex())
+		return nil, NewPermissionDeniedError("experience belongs to another user")
+	}
+	return experience, nil
+}
+
+func validateExperience(experience *model.Experience) error {
+	v := NewValidator()
+	v.Required("experience.name", experience.Name)
+	v.Required("experience.title", experience.Title)
+	if experience.StartDate.IsZero() {
+		v.AddViolation("experience.startDate", "is required")
+	}
+	v.DateOrder("experience.endDate", experience.StartDate, experience.EndDate)
+	if !slices.Contains(model.ExperienceTypes, experience.ExperienceType) {
+		v.AddViolation("experience.experienceType", "must be one of WORK, EDUCATION, VOLUNTEERING, CERTIFICATION")
+	}
+	if experience.EmploymentType != "" && !slices.Contains(model.EmploymentTypes, experience.EmploymentType) {
+		v.AddViolation("experience.employmentType", "must be one of FULL_TIME, PART_TIME, SELF_EMPLOYED, FREELANCE, CONTRACT, INTERNSHIP")
+	}
+	return v.Err()
 }
@@ -0,0 +1,26 @@
+package application
+
+// fieldSetters copies each named field from the changes onto the stored
+// entity.
+type fieldSetters map[string]func()
+
+// applyFieldMask runs the setters of the fields named in a partial update.
+// An empty mask replaces every field. Fields without a setter are rejected
+// before anything is copied.
+func applyFieldMask(fields []string, setters fieldSetters) error {
+	if len(fields) == 0 {
+		for _, set := range setters {
+			set()
+		}
+		return nil
+	}
+	for _, field := range fields {
+		if _, ok := setters[field]; !ok {
+			return NewInvalidArgumentError("unknown field in update mask", FieldViolation{Field: "updateMask", Description: field + " cannot be updated"})
+		}
+	}
+	for _, field := range fields {
+		setters[field]()
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package application
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"user-microservice/model"
+)
+
+const (
+	ImageOriginal = "original"
+	ImageLarge    = "large"
+	ImageMedium   = "medium"
+	ImageSmall    = "small"
+
+	MinImageSide = 64
+	jpegQuality  = 90
+)
+
+var errInvalidWebp = errors.New("invalid webp file")
+
+type thumbnailSize struct {
+	name   string
+	width  int
+	height int
+}
+
+// thumbnailSizes lists the sizes generated for every kind, largest first.
+// Profile pictures are cropped to a square and cover images to 3:1.
+var thumbnailSizes = map[model.ImageKind][]thumbnailSize{
+	model.PROFILE_PICTURE: {{ImageLarge, 400, 400}, {ImageMedium, 200, 200}, {ImageSmall, 64, 64}},
+	model.COVER_IMAGE:     {{ImageLarge, 1500, 500}, {ImageMedium, 750, 250}},
+}
+
+// ImageSizes returns the names of the sizes served for an image kind.
+func ImageSizes(kind model.ImageKind) []string {
+	sizes := []string{ImageOriginal}
+	for _, size := range thumbnailSizes[kind] {
+		sizes = append(sizes, size.name)
+	}
+	return sizes
+}
+
+type processedImage struct {
+	contentType string
+	width       int
+	height      int
+	sizes       []string
+	blobs       map[string][]byte
+}
+
+func (processed *processedImage) add(size string, data []byte) {
+	processed.sizes = append(processed.sizes, size)
+	processed.blobs[size] = data
+}
+
+// processImage checks an upload by its content rather than by what the client
+// claims it is, then stores a cleaned original and the thumbnails. JPEG and
+// PNG are decoded and re-encoded, which drops EXIF and any other metadata.
+// WebP cannot be decoded with the standard library, so its metadata chunks are
+// removed and the cleaned original is served for every size.
+func processImage(kind model.ImageKind, data []byte, maxPixels int64) (*processedImage, error) {
+	contentType := http.DetectContentType(data)
+	var config image.Config
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		config, err = jpeg.DecodeConfig(bytes.NewReader(data))
+	case "image/png":
+		config, err = png.DecodeConfig(bytes.NewReader(data))
+	case "image/webp":
+		config, err = webpConfig(data)
+	default:
+		return nil, NewInvalidArgumentError("image must be a JPEG, PNG or WebP file")
+	}
+	if err != nil {
+		return nil, NewInvalidArgumentError("image could not be read")
+	}
+	if err := checkImageSize(config.Width, config.Height, maxPixels); err != nil {
+		return nil, err
+	}
+
+	processed := &processedImage{contentType: contentType, blobs: map[string][]byte{}}
+	if contentType == "image/webp" {
+		cleaned, err := stripWebpMetadata(data)
+		if err != nil {
+			return nil, NewInvalidArgumentError("image could not be read")
+		}
+		processed.width, processed.height = config.Width, config.Height
+		processed.add(ImageOriginal, cleaned)
+		return processed, nil
+	}
+
+	var decoded image.Image
+	orientation := 1
+	if contentType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+		decoded, err = jpeg.Decode(bytes.NewReader(data))
+	} else {
+		decoded, err = png.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, NewInvalidArgumentError("image could not be decoded")
+	}
+	original := orient(toRGBA(decoded), orientation)
+	processed.width, processed.height = original.Bounds().Dx(), original.Bounds().Dy()
+
+	encoded, err := encodeImage(contentType, original)
+	if err != nil {
+		return nil, err
+	}
+	processed.add(ImageOriginal, encoded)
+	for _, size := range thumbnailSizes[kind] {
+		encoded, err = encodeImage(contentType, thumbnail(original, size.width, size.height))
+		if err != nil {
+			return nil, err
+		}
+		processed.add(size.name, encoded)
+	}
+	return processed, nil
+}
+
+// checkImageSize runs on the header alone so that an image is never decoded
+// into more memory than the pixel limit allows.
+func checkImageSize(width int, height int, maxPixels int64) error {
+	if width < MinImageSide || height < MinImageSide {
+		return NewInvalidArgumentError("image must be at least 64x64 pixels")
+	}
+	if int64(width)*int64(height) > maxPixels {
+		return NewInvalidArgumentError("image has too many pixels")
+	}
+	return nil
+}
+
+func encodeImage(contentType string, img image.Image) ([]byte, error) {
+	var buffer bytes.Buffer
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buffer, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// orient turns a JPEG upright according to its EXIF orientation, since the
+// tag itself is lost when the image is re-encoded.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	result := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(result.Pix[result.PixOffset(x, y):result.PixOffset(x, y)+4], img.Pix[img.PixOffset(sx, sy):img.PixOffset(sx, sy)+4])
+		}
+	}
+	return result
+}
+
+// thumbnail crops the centre of img to the aspect ratio of width x height and
+// scales it down with a box filter. Images smaller than the target are not
+// scaled up.
+func thumbnail(img *image.RGBA, width int, height int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	cropW, cropH := w, w*height/width
+	if cropH > h {
+		cropW, cropH = h*width/height, h
+	}
+	crop := image.Rect((w-cropW)/2, (h-cropH)/2, (w-cropW)/2+cropW, (h-cropH)/2+cropH)
+	if cropW < width {
+		width, height = cropW, cropH
+	}
+
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := crop.Min.Y + y*cropH/height
+		y1 := crop.Min.Y + (y+1)*cropH/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := crop.Min.X + x*cropW/width
+			x1 := crop.Min.X + (x+1)*cropW/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					offset := img.PixOffset(sx, sy)
+					for c := 0; c < 4; c++ {
+						sum[c] += int(img.Pix[offset+c])
+					}
+				}
+			}
+			count := (y1 - y0) * (x1 - x0)
+			offset := result.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				result.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return result
+}
+
+// jpegOrientation reads the orientation tag from the EXIF segment of a JPEG
+// and returns 1 when there is none.
+func jpegOrientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && len(segment) > 14 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
+
+// webpConfig reads the canvas size from the first chunk of a WebP file.
+func webpConfig(data []byte) (image.Config, error) {
+	config := image.Config{}
+	if len(data) < 30 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return config, errInvalidWebp
+	}
+	chunk := data[20:]
+	switch string(data[12:16]) {
+	case "VP8 ":
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return config, errInvalidWebp
+		}
+		config.Width = int(binary.LittleEndian.Uint16(chunk[6:]) & 0x3fff)
+		config.Height = int(binary.LittleEndian.Uint16(chunk[8:]) & 0x3fff)
+	case "VP8L":
+		if chunk[0] != 0x2f {
+			return config, errInvalidWebp
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:])
+		config.Width = int(bits&0x3fff) + 1
+		config.Height = int(bits>>14&0x3fff) + 1
+	case "VP8X":
+		config.Width = int(uint32(chunk[4])|uint32(chunk[5])<<8|uint32(chunk[6])<<16) + 1
+		config.Height = int(uint32(chunk[7])|uint32(chunk[8])<<8|uint32(chunk[9])<<16) + 1
+	default:
+		return config, errInvalidWebp
+	}
+	return config, nil
+}
+
+// stripWebpMetadata rewrites a WebP file without its EXIF and XMP chunks and
+// clears the matching flags of the extended header.
+func stripWebpMetadata(data []byte) ([]byte, error) {
+	result := append([]byte{}, data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errInvalidWebp
+		}
+		id := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			return nil, errInvalidWebp
+		}
+		if id != "EXIF" && id != "XMP " {
+			start := len(result)
+			result = append(result, data[i:end]...)
+			if id == "VP8X" && size > 0 {
+				result[start+8] &^= 0x08 | 0x04
+			}
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+	return result, nil
+}
@@ -0,0 +1,165 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/exp/slices"
+	"strings"
+	"time"
+	"user-microservice/model"
+	"user-microservice/startup/config"
+)
+
+type ImageService struct {
+	store     model.UserStore
+	blobs     model.BlobStore
+	maxBytes  int64
+	maxPixels int64
+}
+
+func NewImageService(store model.UserStore, blobs model.BlobStore, config *config.Config) *ImageService {
+	return &ImageService{
+		store:     store,
+		blobs:     blobs,
+		maxBytes:  config.ImageMaxBytes,
+		maxPixels: config.ImageMaxPixels,
+	}
+}
+
+// MaxBytes is the largest upload accepted, so that streaming handlers can stop
+// reading early.
+func (service *ImageService) MaxBytes() int64 {
+	return service.maxBytes
+}
+
+// Upload validates and stores a new image of the given kind and replaces the
+// previous one, whose blobs are removed only after the user points to the new
+// image.
+func (service *ImageService) Upload(ctx context.Context, userId primitive.ObjectID, kind model.ImageKind, data []byte) (*model.User, error) {
+	Log.Info("Uploading " + string(kind) + " for user with id: " + userId.Hex())
+	if !slices.Contains(model.ImageKinds, kind) {
+		return nil, NewInvalidArgumentError("unknown image kind")
+	}
+	if len(data) == 0 {
+		return nil, NewInvalidArgumentError("image is empty")
+	}
+	if int64(len(data)) > service.maxBytes {
+		return nil, NewInvalidArgumentError("image is too large")
+	}
+	_, err := service.store.Get(ctx, userId)
+	if err != nil {
+		return nil, notFoundOr(err, "user not found")
+	}
+
+	processed, err := processImage(kind, data, service.maxPixels)
+	if err != nil {
+		Log.Warn("Rejected " + string(kind) + " upload for user with id: " + userId.Hex() + ": " + err.Error())
+		return nil, err
+	}
+	image := &model.Image{
+		Key:         imageKey(userId, kind, primitive.NewObjectID().Hex()),
+		ContentType: processed.contentType,
+		Width:       int64(processed.width),
+		Height:      int64(processed.height),
+		Sizes:       processed.sizes,
+		UploadedAt:  time.Now(),
+	}
+	for _, size := range processed.sizes {
+		err = service.blobs.Put(ctx, image.Key+"/"+size, image.ContentType, processed.blobs[size])
+		if err != nil {
+			Log.Error("Cannot store " + string(kind) + " for user with id: " + userId.Hex())
+			service.deleteBlobs(ctx, image)
+			return nil, err
+		}
+	}
+
+	previous, err := service.store.SetUserImage(ctx, userId, kind, image)
+	if err != nil {
+		service.deleteBlobs(ctx, image)
+		return nil, notFoundOr(err, "user not found")
+	}
+	service.deleteBlobs(ctx, previous)
+	return service.store.Get(ctx, userId)
+}
+
+func (service *ImageService) Delete(ctx context.Context, userId primitive.ObjectID, kind model.ImageKind) (*model.User, error) {
+	Log.Info("Deleting " + string(kind) + " of user with id: " + userId.Hex())
+	if !slices.Contains(model.ImageKinds, kind) {
+		return nil, NewInvalidArgumentError("unknown image kind")
+	}
+	previous, err := service.store.SetUserImage(ctx, userId, kind, nil)
+	if err != nil {
+		return nil, notFoundOr(err, "user not found")
+	}
+	service.deleteBlobs(ctx, previous)
+	return service.store.Get(ctx, userId)
+}
+
+// DeleteImages removes the stored images of a user whose account is deleted
+// and returns the last failure. Blobs that are already gone do not count as
+// failures, so it can be retried.
+func (service *ImageService) DeleteImages(ctx context.Context, images []*model.Image) error {
+	var failed error
+	for _, image := range images {
+		for _, size := range image.Sizes {
+			if err := service.blobs.Delete(ctx, image.Key+"/"+size); err != nil {
+				failed = err
+			}
+		}
+	}
+	return failed
+}
+
+// Open returns the content of one size of an image. Sizes that were not
+// generated for the image, such as thumbnails of a WebP upload, fall back to
+// the original.
+func (service *ImageService) Open(ctx context.Context, userId primitive.ObjectID, kind model.ImageKind, size string) ([]byte, string, error) {
+	user, err := service.store.Get(ctx, userId)
+	if err != nil {
+		return nil, "", notFoundOr(err, "user not found")
+	}
+	image := userImage(user, kind)
+	if image == nil {
+		return nil, "", NewNotFoundError("image not found")
+	}
+	if size == "" {
+		size = ImageOriginal
+	}
+	if !slices.Contains(ImageSizes(kind), size) {
+		return nil, "", NewInvalidArgumentError("unknown image size")
+	}
+	if !slices.Contains(image.Sizes, size) {
+		size = ImageOriginal
+	}
+	data, err := service.blobs.Get(ctx, image.Key+"/"+size)
+	if errors.Is(err, model.ErrBlobNotFound) {
+		return nil, "", NewNotFoundError("image not found")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, image.ContentType, nil
+}
+
+func (service *ImageService) deleteBlobs(ctx context.Context, image *model.Image) {
+	if image == nil {
+		return
+	}
+	for _, size := range image.Sizes {
+		if err := service.blobs.Delete(ctx, image.Key+"/"+size); err != nil {
+			Log.Warn("Cannot delete image blob " + image.Key + "/" + size + ": " + err.Error())
+		}
+	}
+}
+
+func userImage(user *model.User, kind model.ImageKind) *model.Image {
+	if kind == model.COVER_IMAGE {
+		return user.CoverImage
+	}
+	return user.ProfilePicture
+}
+
+func imageKey(userId primitive.ObjectID, kind model.ImageKind, uploadId string) string {
+	return userId.Hex() + "/" + strings.ToLower(string(kind)) + "/" + uploadId
+}
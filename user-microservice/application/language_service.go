@@ -0,0 +1,126 @@
+package application
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strings"
+	"user-microservice/model"
+)
+
+// Language fields that can be named in a partial update.
+const (
+	LanguageName        = "name"
+	LanguageProficiency = "proficiency"
+)
+
+type LanguageService struct {
+	store model.UserStore
+}
+
+func NewLanguageService(store model.UserStore) *LanguageService {
+	return &LanguageService{
+		store: store,
+	}
+}
+
+// GetByUserId returns languages from the strongest proficiency down.
+func (service *LanguageService) GetByUserId(ctx context.Context, userId string) ([]*model.Language, error) {
+	Log.Info("Getting all languages for user with id: " + userId)
+	languages, err := service.store.GetLanguagesByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		a, b := languages[i], languages[j]
+		if a.Proficiency != b.Proficiency {
+			return a.Proficiency > b.Proficiency
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+	return languages, nil
+}
+
+func (service *LanguageService) Create(ctx context.Context, language *model.Language) (*model.Language, error) {
+	Log.Info("Creating new language for user with id: " + language.UserId)
+	err := validateLanguage(language)
+	if err != nil {
+		return nil, err
+	}
+	err = service.checkDuplicate(ctx, language)
+	if err != nil {
+		return nil, err
+	}
+	return service.store.CreateLanguage(ctx, language)
+}
+
+func (service *LanguageService) Update(ctx context.Context, userId primitive.ObjectID, changes *model.Language, fields []string) (*model.Language, error) {
+	Log.Info("Updating language with id: " + changes.Id.Hex())
+	language, err := service.getOwned(ctx, userId, changes.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = applyFieldMask(fields, fieldSetters{
+		LanguageName:        func() { language.Name = changes.Name },
+		LanguageProficiency: func() { language.Proficiency = changes.Proficiency },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateLanguage(language)
+	if err != nil {
+		return nil, err
+	}
+	err = service.checkDuplicate(ctx, language)
+	if err != nil {
+		return nil, err
+	}
+	return service.store.UpdateLanguage(ctx, language.Id, language)
+}
+
+func (service *LanguageService) Delete(ctx context.Context, userId primitive.ObjectID, languageId primitive.ObjectID) error {
+	Log.Info("Deleting language with id: " + languageId.Hex())
+	_, err := service.getOwned(ctx, userId, languageId)
+	if err != nil {
+		return err
+	}
+	return service.store.DeleteLanguage(ctx, languageId)
+}
+
+func (service *LanguageService) getOwned(ctx context.Context, userId primitive.ObjectID, languageId primitive.ObjectID) (*model.Language, error) {
+	language, err := service.store.GetLanguage(ctx, languageId)
+	if err != nil {
+		return nil, notFoundOr(err, "language not found")
+	}
+	if language.UserId != userId.Hex() {
+		Log.Warn("User with id: " + userId.Hex() + " does not own language with id: " + languageId.Hex())
+		return nil, NewPermissionDeniedError("language belongs to another user")
+	}
+	return language, nil
+}
+
+// checkDuplicate rejects a second entry for the same language, ignoring case.
+func (service *LanguageService) checkDuplicate(ctx context.Context, language *model.Language) error {
+	languages, err := service.store.GetLanguagesByUserId(ctx, language.UserId)
+	if err != nil {
+		return err
+	}
+	for _, existing := range languages {
+		if existing.Id != language.Id && strings.EqualFold(strings.TrimSpace(existing.Name), strings.TrimSpace(language.Name)) {
+			return NewAlreadyExistsError("language already exists")
+		}
+	}
+	return nil
+}
+
+func validateLanguage(language *model.Language) error {
+	v := NewValidator()
+	if v.Required("language.name", language.Name) {
+		v.Length("language.name", language.Name, 1, MaxTagLength)
+	}
+	if language.Proficiency < model.ELEMENTARY || language.Proficiency > model.NATIVE {
+		v.AddViolation("language.proficiency", "is not a known proficiency level")
+	}
+	return v.Err()
+}
@@ -0,0 +1,25 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-microservice/model"
+)
+
+// errLeaseLost stops a worker that no longer holds its lease.
+var errLeaseLost = errors.New("lease lost")
+
+// renewLease takes or extends a lease before the next unit of work. Workers
+// call it before every event, step or sync rather than once per batch, so
+// that a batch outlasting the lease cannot let another replica in. A unit
+// must therefore finish well within ttl. It reports false once the lease is
+// held by someone else or cannot be checked.
+func renewLease(ctx context.Context, store model.UserStore, name string, owner string, ttl time.Duration) bool {
+	acquired, err := store.AcquireLease(ctx, name, owner, ttl)
+	if err != nil {
+		Log.Error("Cannot acquire the " + name + " lease: " + err.Error())
+		return false
+	}
+	return acquired
+}
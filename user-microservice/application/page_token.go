@@ -0,0 +1,82 @@
+package application
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"user-microservice/model"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// pageToken is what a page token carries. It is opaque to clients; the sort
+// order is part of it so that a token cannot continue a differently sorted
+// listing.
+type pageToken struct {
+	Sort       model.UserSort   `json:"s"`
+	Descending bool             `json:"d,omitempty"`
+	After      model.UserCursor `json:"a"`
+	Score      int64            `json:"sc,omitempty"`
+}
+
+// searchSort marks tokens of search results, which are ordered by relevance.
+const searchSort model.UserSort = "RELEVANCE"
+
+func encodePageToken(last *model.User, sort model.UserSort, descending bool) string {
+	token := pageToken{Sort: sort, Descending: descending, After: model.UserCursor{Id: last.Id}}
+	switch sort {
+	case model.SORT_USERNAME:
+		token.After.Value = last.Username
+	case model.SORT_NAME:
+		token.After.Value = last.Name
+	}
+	return token.encode()
+}
+
+func decodePageToken(value string, sort model.UserSort, descending bool) (*model.UserCursor, error) {
+	token, err := parsePageToken(value)
+	if err != nil || token.Sort != sort || token.Descending != descending {
+		return nil, invalidPageToken()
+	}
+	return &token.After, nil
+}
+
+func encodeSearchPageToken(last *model.UserSearchHit) string {
+	token := pageToken{Sort: searchSort, After: model.UserCursor{Id: last.UserId}, Score: last.Score}
+	return token.encode()
+}
+
+func decodeSearchPageToken(value string) (*model.UserSearchCursor, error) {
+	token, err := parsePageToken(value)
+	if err != nil || token.Sort != searchSort {
+		return nil, invalidPageToken()
+	}
+	return &model.UserSearchCursor{Score: token.Score, Id: token.After.Id}, nil
+}
+
+func (token *pageToken) encode() string {
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parsePageToken(value string) (*pageToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var token pageToken
+	err = json.Unmarshal(data, &token)
+	if err != nil {
+		return nil, err
+	}
+	if token.After.Id.IsZero() {
+		return nil, invalidPageToken()
+	}
+	return &token, nil
+}
+
+func invalidPageToken() error {
+	return NewInvalidArgumentError("invalid page token", FieldViolation{Field: "pageToken", Description: "is not a token of this listing"})
+}
@@ -0,0 +1,132 @@
+package application
+
+import (
+	"context"
+	"errors"
+	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"user-microservice/model"
+	"user-microservice/startup/config"
+)
+
+const facetLimit = 20
+
+// PeopleSearch is a structured search as the client sends it. Skills and
+// interests are tag names, aliases or ids.
+type PeopleSearch struct {
+	Skills       []string
+	AllSkills    bool
+	Interests    []string
+	AllInterests bool
+	Company      string
+	Title        string
+	Location     string
+	Position     model.PositionFilter
+}
+
+// PeopleSearchService finds people by what is on their profile rather than by
+// name. Private profiles keep their details to their connections, so they are
+// never part of the results.
+type PeopleSearchService struct {
+	store            model.UserStore
+	tags             *TagService
+	connectionClient connectionService.ConnectionServiceClient
+	degradedMode     string
+}
+
+func NewPeopleSearchService(store model.UserStore, config *config.Config, connectionClient connectionService.ConnectionServiceClient, tags *TagService) *PeopleSearchService {
+	return &PeopleSearchService{
+		store:            store,
+		tags:             tags,
+		connectionClient: connectionClient,
+		degradedMode:     config.SearchDegradedMode,
+	}
+}
+
+// Search returns one page of matches in a stable order, the facets of all
+// matches and the token of the next page. The total and the facets are only
+// returned with the first page. Skill facets hold tag ids.
+func (service *PeopleSearchService) Search(ctx context.Context, userId string, search *PeopleSearch, pageToken string, pageSize int64) (*model.PeopleSearchResult, string, error) {
+	Log.Info("People search by user with id: " + userId)
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	query := &model.PeopleSearchQuery{
+		AllSkills:    search.AllSkills,
+		AllInterests: search.AllInterests,
+		Company:      strings.TrimSpace(search.Company),
+		Title:        strings.TrimSpace(search.Title),
+		Location:     strings.TrimSpace(search.Location),
+		Position:     search.Position,
+		Limit:        pageSize + 1,
+		FacetLimit:   facetLimit,
+	}
+	if pageToken != "" {
+		after, err := decodePageToken(pageToken, model.SORT_CREATED, false)
+		if err != nil {
+			return nil, "", err
+		}
+		query.After = &after.Id
+	}
+
+	var skillsMatchable, interestsMatchable bool
+	var err error
+	query.Skills, skillsMatchable, err = service.tagIds(ctx, model.SKILL, search.Skills, search.AllSkills)
+	if err != nil {
+		return nil, "", err
+	}
+	query.Interests, interestsMatchable, err = service.tagIds(ctx, model.INTEREST, search.Interests, search.AllInterests)
+	if err != nil {
+		return nil, "", err
+	}
+	if !skillsMatchable || !interestsMatchable {
+		return &model.PeopleSearchResult{Users: []*model.User{}}, "", nil
+	}
+
+	excluded, hide, err := searchExclusions(ctx, service.connectionClient, service.degradedMode, userId)
+	if err != nil {
+		Log.Error("Cannot get blocked users of user with id: " + userId)
+		return nil, "", err
+	}
+	if hide {
+		return &model.PeopleSearchResult{Users: []*model.User{}}, "", nil
+	}
+	query.ExcludeIds = excluded
+
+	result, err := service.store.SearchPeople(ctx, query)
+	if err != nil {
+		Log.Error("People search failed for user with id: " + userId)
+		return nil, "", err
+	}
+	nextPageToken := ""
+	if int64(len(result.Users)) > pageSize {
+		result.Users = result.Users[:pageSize]
+		nextPageToken = encodePageToken(result.Users[len(result.Users)-1], model.SORT_CREATED, false)
+	}
+	return result, nextPageToken, nil
+}
+
+// tagIds resolves names to tag ids. The second result is false when no user
+// can match: a tag that does not exist makes an all-of filter unsatisfiable,
+// while an any-of filter just drops it.
+func (service *PeopleSearchService) tagIds(ctx context.Context, kind model.TagKind, values []string, all bool) ([]string, bool, error) {
+	var ids []string
+	for _, value := range values {
+		tag, err := service.tags.Find(ctx, kind, value)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if all {
+				return nil, false, nil
+			}
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		ids = append(ids, tag.Id.Hex())
+	}
+	return ids, len(values) == 0 || len(ids) > 0, nil
+}
@@ -0,0 +1,230 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+	"user-microservice/model"
+)
+
+const (
+	privacySyncLease      = "privacySyncs"
+	privacySyncLeaseTTL   = time.Minute
+	privacySyncPoll       = 10 * time.Second
+	privacySyncBatchSize  = 50
+	privacySyncFirstRetry = 5 * time.Second
+	privacySyncMaxRetry   = 30 * time.Minute
+	privacyReconcileEvery = 10 * time.Minute
+)
+
+// PrivacyService sets the privacy of profiles and brings the connection
+// service in line with it. The user document, a PrivacySync and a
+// PrivacyChanged event are written in one transaction; the sync is then
+// applied in the background and retried until the connection service
+// accepts it. Making a profile public approves its pending connection
+// requests; making it private needs nothing from the connection service.
+type PrivacyService struct {
+	store            model.UserStore
+	connectionClient connectionService.ConnectionServiceClient
+	events           *EventService
+	owner            string
+	wake             chan struct{}
+}
+
+func NewPrivacyService(store model.UserStore, connectionClient connectionService.ConnectionServiceClient, events *EventService) *PrivacyService {
+	return &PrivacyService{
+		store:            store,
+		connectionClient: connectionClient,
+		events:           events,
+		owner:            uuid.New().String(),
+		wake:             make(chan struct{}, 1),
+	}
+}
+
+// Set makes the profile private or public. Setting the privacy a profile
+// already has changes nothing, so retried and repeated calls are safe.
+func (service *PrivacyService) Set(ctx context.Context, userId primitive.ObjectID, private bool) (*model.User, error) {
+	Log.Info("Setting profile privacy for user with id: " + userId.Hex() + " to " + fmt.Sprint(private))
+	var user *model.User
+	err := service.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var changed bool
+		var err error
+		user, changed, err = service.store.SetUserPrivacy(ctx, userId, private)
+		if err != nil || !changed {
+			return err
+		}
+		now := time.Now()
+		err = service.store.SavePrivacySync(ctx, &model.PrivacySync{
+			UserId:        userId.Hex(),
+			Private:       private,
+			Revision:      user.Version,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+		return service.events.Emit(ctx, &model.PrivacyChanged{UserId: userId.Hex(), Private: private})
+	})
+	if err != nil {
+		return nil, notFoundOr(err, "user not found")
+	}
+	if user.PrivacyPending {
+		service.wakeUp()
+	}
+	Log.Info("Profile with id:" + userId.Hex() + " is private: " + fmt.Sprint(user.Private))
+	return user, nil
+}
+
+// Run applies pending syncs until ctx is done and reconciles them with the
+// user documents every few minutes. Only the replica holding the lease does
+// either.
+func (service *PrivacyService) Run(ctx context.Context) {
+	lastReconcile := time.Time{}
+	for {
+		if time.Since(lastReconcile) >= privacyReconcileEvery {
+			err := service.Reconcile(ctx)
+			if err != nil {
+				Log.Error("Reconciling profile privacy failed: " + err.Error())
+			}
+			lastReconcile = time.Now()
+		}
+		service.applyDue(ctx)
+		select {
+		case <-ctx.Done():
+			err := service.store.ReleaseLease(context.Background(), privacySyncLease, service.owner)
+			if err != nil {
+				Log.Warn("Cannot release the privacy sync lease: " + err.Error())
+			}
+			return
+		case <-service.wake:
+		case <-time.After(privacySyncPoll):
+		}
+	}
+}
+
+// Reconcile makes sure every user whose privacy the connection service has
+// not applied yet has a matching sync. They only drift apart when the
+// database has no transactions and a write was lost between the two.
+func (service *PrivacyService) Reconcile(ctx context.Context) error {
+	acquired, err := service.store.AcquireLease(ctx, privacySyncLease, service.owner, privacySyncLeaseTTL)
+	if err != nil || !acquired {
+		return err
+	}
+	after := primitive.NilObjectID
+	for {
+		users, err := service.store.GetPrivacyPendingUsers(ctx, after, privacySyncBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			after = user.Id
+			sync, err := service.store.GetPrivacySync(ctx, user.Id.Hex())
+			// A sync newer than the user read here belongs to a change
+			// made in the meantime.
+			if err == nil && (sync.Private == user.Private || sync.Revision > user.Version) {
+				continue
+			}
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return err
+			}
+			Log.Warn("Privacy of user with id: " + user.Id.Hex() + " was not queued for the connection service, queueing it")
+			now := time.Now()
+			err = service.store.SavePrivacySync(ctx, &model.PrivacySync{
+				UserId:        user.Id.Hex(),
+				Private:       user.Private,
+				Revision:      user.Version,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(users) < privacySyncBatchSize {
+			return nil
+		}
+	}
+}
+
+func (service *PrivacyService) applyDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		acquired, err := service.store.AcquireLease(ctx, privacySyncLease, service.owner, privacySyncLeaseTTL)
+		if err != nil {
+			Log.Error("Cannot acquire the privacy sync lease: " + err.Error())
+			return
+		}
+		if !acquired {
+			return
+		}
+		syncs, err := service.store.GetDuePrivacySyncs(ctx, time.Now(), privacySyncBatchSize)
+		if err != nil {
+			Log.Error("Cannot read pending privacy syncs: " + err.Error())
+			return
+		}
+		for _, sync := range syncs {
+			err = service.apply(ctx, sync)
+			if err != nil {
+				Log.Error("Cannot record privacy sync of user with id: " + sync.UserId + ": " + err.Error())
+				return
+			}
+		}
+		if len(syncs) < privacySyncBatchSize {
+			return
+		}
+	}
+}
+
+// apply hands one sync to the connection service. Approving connection
+// requests is idempotent, so a sync that is applied twice does no harm.
+func (service *PrivacyService) apply(ctx context.Context, sync *model.PrivacySync) error {
+	userId, err := primitive.ObjectIDFromHex(sync.UserId)
+	if err != nil {
+		return service.store.DeletePrivacySync(ctx, sync.UserId, sync.Revision)
+	}
+	_, err = service.store.Get(ctx, userId)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return service.store.DeletePrivacySync(ctx, sync.UserId, sync.Revision)
+	}
+	if err != nil {
+		return err
+	}
+	if !sync.Private {
+		_, err = service.connectionClient.ApproveAllConnection(ctx, &connectionService.UserIdRequest{UserId: sync.UserId})
+		if err != nil {
+			Log.Warn("Approving connections of user with id: " + sync.UserId + " failed: " + err.Error())
+			return service.store.MarkPrivacySyncFailed(ctx, sync.UserId, sync.Revision, time.Now().Add(privacySyncRetryDelay(sync.Attempts)), err.Error())
+		}
+	}
+	err = service.store.ClearPrivacyPending(ctx, userId, sync.Private)
+	if err != nil {
+		return err
+	}
+	Log.Info("Connection service applied privacy of user with id: " + sync.UserId)
+	return service.store.DeletePrivacySync(ctx, sync.UserId, sync.Revision)
+}
+
+func (service *PrivacyService) wakeUp() {
+	select {
+	case service.wake <- struct{}{}:
+	default:
+	}
+}
+
+// privacySyncRetryDelay doubles with every failed attempt up to
+// privacySyncMaxRetry.
+func privacySyncRetryDelay(attempts int64) time.Duration {
+	delay := privacySyncFirstRetry
+	for i := int64(0); i < attempts && delay < privacySyncMaxRetry; i++ {
+		delay *= 2
+	}
+	if delay > privacySyncMaxRetry {
+		return privacySyncMaxRetry
+	}
+	return delay
+}
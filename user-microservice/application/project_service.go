@@ -0,0 +1,123 @@
+package application
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"user-microservice/model"
+)
+
+const maxProjectLinks = 5
+
+// Project fields that can be named in a partial update.
+const (
+	ProjectName        = "name"
+	ProjectDescription = "description"
+	ProjectLinks       = "links"
+	ProjectStartDate   = "startDate"
+	ProjectEndDate     = "endDate"
+)
+
+type ProjectService struct {
+	store model.UserStore
+}
+
+func NewProjectService(store model.UserStore) *ProjectService {
+	return &ProjectService{
+		store: store,
+	}
+}
+
+// GetByUserId returns ongoing projects first, then finished ones from the most
+// recently completed.
+func (service *ProjectService) GetByUserId(ctx context.Context, userId string) ([]*model.Project, error) {
+	Log.Info("Getting all projects for user with id: " + userId)
+	projects, err := service.store.GetProjectsByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(projects, func(i, j int) bool {
+		a, b := projects[i], projects[j]
+		if a.IsCurrent() != b.IsCurrent() {
+			return a.IsCurrent()
+		}
+		if !a.EndDate.Equal(b.EndDate) {
+			return a.EndDate.After(b.EndDate)
+		}
+		return a.StartDate.After(b.StartDate)
+	})
+	return projects, nil
+}
+
+func (service *ProjectService) Create(ctx context.Context, project *model.Project) (*model.Project, error) {
+	Log.Info("Creating new project for user with id: " + project.UserId)
+	err := validateProject(project)
+	if err != nil {
+		return nil, err
+	}
+	return service.store.CreateProject(ctx, project)
+}
+
+func (service *ProjectService) Update(ctx context.Context, userId primitive.ObjectID, changes *model.Project, fields []string) (*model.Project, error) {
+	Log.Info("Updating project with id: " + changes.Id.Hex())
+	project, err := service.getOwned(ctx, userId, changes.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = applyFieldMask(fields, fieldSetters{
+		ProjectName:        func() { project.Name = changes.Name },
+		ProjectDescription: func() { project.Description = changes.Description },
+		ProjectLinks:       func() { project.Links = changes.Links },
+		ProjectStartDate:   func() { project.StartDate = changes.StartDate },
+		ProjectEndDate:     func() { project.EndDate = changes.EndDate },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateProject(project)
+	if err != nil {
+		return nil, err
+	}
+	return service.store.UpdateProject(ctx, project.Id, project)
+}
+
+func (service *ProjectService) Delete(ctx context.Context, userId primitive.ObjectID, projectId primitive.ObjectID) error {
+	Log.Info("Deleting project with id: " + projectId.Hex())
+	_, err := service.getOwned(ctx, userId, projectId)
+	if err != nil {
+		return err
+	}
+	return service.store.DeleteProject(ctx, projectId)
+}
+
+func (service *ProjectService) getOwned(ctx context.Context, userId primitive.ObjectID, projectId primitive.ObjectID) (*model.Project, error) {
+	project, err := service.store.GetProject(ctx, projectId)
+	if err != nil {
+		return nil, notFoundOr(err, "project not found")
+	}
+	if project.UserId != userId.Hex() {
+		Log.Warn("User with id: " + userId.Hex() + " does not own project with id: " + projectId.Hex())
+		return nil, NewPermissionDeniedError("project belongs to another user")
+	}
+	return project, nil
+}
+
+func validateProject(project *model.Project) error {
+	v := NewValidator()
+	if v.Required("project.name", project.Name) {
+		v.Length("project.name", project.Name, 1, 100)
+	}
+	v.Length("project.description", project.Description, 0, 2000)
+	if len(project.Links) > maxProjectLinks {
+		v.AddViolation("project.links", "must not contain more than 5 links")
+	}
+	for _, link := range project.Links {
+		v.Url("project.links", link)
+	}
+	if project.StartDate.IsZero() {
+		v.AddViolation("project.startDate", "is required")
+	}
+	v.DateOrder("project.endDate", project.StartDate, project.EndDate)
+	return v.Err()
+}
@@ -0,0 +1,59 @@
+package application
+
+import (
+	"context"
+	"expvar"
+	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// What a search returns while the connection service cannot say who is
+// blocked, as set by SEARCH_DEGRADED_MODE.
+const (
+	// SearchDegradedHide returns no results, so that nobody sees a user who
+	// blocked them.
+	SearchDegradedHide = "hide"
+	// SearchDegradedUnfiltered returns results without leaving out blocked
+	// users.
+	SearchDegradedUnfiltered = "unfiltered"
+)
+
+var degradedSearches = expvar.NewInt("degradedSearches")
+
+// searchExclusions returns the ids a search by userId leaves out: the user
+// and everyone blocked by or blocking them. When the connection service is
+// unavailable it falls back to mode; hide is true when the search should
+// return nothing.
+func searchExclusions(ctx context.Context, client connectionService.ConnectionServiceClient, mode string, userId string) (ids []primitive.ObjectID, hide bool, err error) {
+	blocked := []string{}
+	response, err := client.GetBlockedAny(ctx, &connectionService.UserIdRequest{UserId: userId})
+	switch {
+	case err == nil:
+		blocked = response.UserIds
+	case !connectionServiceUnavailable(err):
+		return nil, false, err
+	case mode == SearchDegradedUnfiltered:
+		degradedSearches.Add(1)
+		Log.Warn("Connection service is unavailable, searching without block filtering for user with id: " + userId)
+	default:
+		degradedSearches.Add(1)
+		Log.Warn("Connection service is unavailable, hiding search results for user with id: " + userId)
+		return nil, true, nil
+	}
+	for _, id := range append(blocked, userId) {
+		if objectId, err := primitive.ObjectIDFromHex(id); err == nil {
+			ids = append(ids, objectId)
+		}
+	}
+	return ids, false, nil
+}
+
+func connectionServiceUnavailable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
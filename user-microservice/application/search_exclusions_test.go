@@ -0,0 +1,72 @@
+package application
+
+import (
+	"context"
+	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+// blockedConnectionClient answers GetBlockedAny with blocked or err.
+type blockedConnectionClient struct {
+	connectionService.ConnectionServiceClient
+	blocked []string
+	err     error
+}
+
+func (client *blockedConnectionClient) GetBlockedAny(ctx context.Context, in *connectionService.UserIdRequest, opts ...grpc.CallOption) (*connectionService.UserIdsResponse, error) {
+	if client.err != nil {
+		return nil, client.err
+	}
+	return &connectionService.UserIdsResponse{UserIds: client.blocked}, nil
+}
+
+func TestSearchExclusions(t *testing.T) {
+	userId := primitive.NewObjectID()
+	blockedId := primitive.NewObjectID()
+	unavailable := status.Error(codes.Unavailable, "connection service is down")
+	denied := status.Error(codes.PermissionDenied, "not allowed")
+
+	tests := []struct {
+		name     string
+		mode     string
+		client   *blockedConnectionClient
+		ids      []primitive.ObjectID
+		hide     bool
+		fails    bool
+		degraded int64
+	}{
+		{name: "available", mode: SearchDegradedHide, client: &blockedConnectionClient{blocked: []string{blockedId.Hex(), "not an id"}}, ids: []primitive.ObjectID{blockedId, userId}},
+		{name: "unavailable hides", mode: SearchDegradedHide, client: &blockedConnectionClient{err: unavailable}, hide: true, degraded: 1},
+		{name: "unavailable unfiltered", mode: SearchDegradedUnfiltered, client: &blockedConnectionClient{err: unavailable}, ids: []primitive.ObjectID{userId}, degraded: 1},
+		{name: "timeout unfiltered", mode: SearchDegradedUnfiltered, client: &blockedConnectionClient{err: status.Error(codes.DeadlineExceeded, "slow")}, ids: []primitive.ObjectID{userId}, degraded: 1},
+		{name: "other errors hide", mode: SearchDegradedHide, client: &blockedConnectionClient{err: denied}, fails: true},
+		{name: "other errors unfiltered", mode: SearchDegradedUnfiltered, client: &blockedConnectionClient{err: denied}, fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := degradedSearches.Value()
+			ids, hide, err := searchExclusions(context.Background(), test.client, test.mode, userId.Hex())
+			if test.fails != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if hide != test.hide {
+				t.Fatalf("expected hide to be %v", test.hide)
+			}
+			if len(ids) != len(test.ids) {
+				t.Fatalf("expected ids %v, got %v", test.ids, ids)
+			}
+			for i := range ids {
+				if ids[i] != test.ids[i] {
+					t.Fatalf("expected ids %v, got %v", test.ids, ids)
+				}
+			}
+			if degraded := degradedSearches.Value() - before; degraded != test.degraded {
+				t.Fatalf("expected %d degraded searches, got %d", test.degraded, degraded)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+	"user-microservice/model"
+)
+
+const searchFeedRetry = 5 * time.Second
+
+// SearchIndexService keeps a search index that holds its own copy of the
+// users in step with the database by following the user change feed.
+type SearchIndexService struct {
+	store model.UserStore
+	index model.SearchIndex
+	feed  model.UserChangeFeed
+}
+
+func NewSearchIndexService(store model.UserStore, index model.SearchIndex, feed model.UserChangeFeed) *SearchIndexService {
+	return &SearchIndexService{
+		store: store,
+		index: index,
+		feed:  feed,
+	}
+}
+
+// Reindex rebuilds the index from every user in the database. The feed
+// position is taken before the users are read, so that following the feed
+// afterwards replays anything written during the rebuild.
+func (service *SearchIndexService) Reindex(ctx context.Context) (int64, error) {
+	Log.Info("Reindexing users for search")
+	position, err := service.feed.Position(ctx)
+	if err != nil && !errors.Is(err, model.ErrFeedUnsupported) {
+		return 0, err
+	}
+	users, err := service.store.GetAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+	err = service.index.Reset(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, user := range users {
+		err = service.index.Index(ctx, user)
+		if err != nil {
+			return 0, err
+		}
+	}
+	service.index.SetCheckpoint(position)
+	Log.Info("Reindexed " + strconv.Itoa(len(users)) + " users for search")
+	return int64(len(users)), nil
+}
+
+// Follow applies the change feed to the index until ctx is done. An index
+// without a checkpoint, or one that fell too far behind the feed, is rebuilt
+// first. When the database has no change feed the index only sees the writes
+// made through UserService.
+func (service *SearchIndexService) Follow(ctx context.Context) {
+	for ctx.Err() == nil {
+		if service.index.Checkpoint() == nil {
+			_, err := service.Reindex(ctx)
+			if err != nil {
+				Log.Error("Reindexing users for search failed: " + err.Error())
+				service.wait(ctx)
+				continue
+			}
+		}
+		err := service.feed.Follow(ctx, service.index.Checkpoint(), service.apply)
+		switch {
+		case err == nil:
+		case errors.Is(err, model.ErrFeedUnsupported):
+			Log.Warn("Database has no change feed, search index is only updated by this replica")
+			return
+		case errors.Is(err, model.ErrFeedPositionLost):
+			Log.Warn("Search index fell behind the change feed and will be rebuilt")
+			service.index.SetCheckpoint(nil)
+		default:
+			Log.Error("Following user changes for search failed: " + err.Error())
+			service.wait(ctx)
+		}
+	}
+}
+
+func (service *SearchIndexService) apply(change *model.UserChange) error {
+	ctx := context.Background()
+	var err error
+	if change.User == nil {
+		err = service.index.Remove(ctx, change.UserId)
+	} else {
+		err = service.index.Index(ctx, change.User)
+	}
+	if err != nil {
+		return err
+	}
+	service.index.SetCheckpoint(change.Position)
+	return nil
+}
+
+func (service *SearchIndexService) wait(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(searchFeedRetry):
+	}
+}
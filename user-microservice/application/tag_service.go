@@ -0,0 +1,557 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+	"strconv"
+	"strings"
+	"user-microservice/model"
+)
+
+const (
+	defaultAutocompleteLimit = 10
+	maxAutocompleteLimit     = 50
+	maxTagAliases            = 20
+	maxTagBatch              = 50
+)
+
+type TagService struct {
+	store        model.UserStore
+	completeness *CompletenessService
+}
+
+// TagBackfill summarizes a run of Backfill.
+type TagBackfill struct {
+	Users     int64
+	Rewritten int64
+	Tags      int64
+}
+
+func NewTagService(store model.UserStore, completeness *CompletenessService) *TagService {
+	return &TagService{
+		store:        store,
+		completeness: completeness,
+	}
+}
+
+// NormalizeTag reduces a skill or interest to the form used for lookups, so
+// that "GoLang", " golang" and "Golang " all match the same key.
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// Resolve returns the tag a name or alias belongs to and creates a new
+// uncategorized tag when the name is not known yet.
+func (service *TagService) Resolve(ctx context.Context, kind model.TagKind, name string) (*model.Tag, error) {
+	key := NormalizeTag(name)
+	tag, err := service.store.GetTagByKey(ctx, kind, key)
+	if err == nil {
+		return tag, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	Log.Info("Creating new " + strings.ToLower(string(kind)) + " tag: " + key)
+	tag, err = service.store.CreateTag(ctx, &model.Tag{
+		Kind: kind,
+		Name: strings.Join(strings.Fields(name), " "),
+		Keys: []string{key},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request created the same tag in the meantime.
+		return service.store.GetTagByKey(ctx, kind, key)
+	}
+	return tag, err
+}
+
+// Canonicalize turns a list of names, aliases or tag ids into a list of
+// canonical tag ids without duplicates, keeping the original order.
+func (service *TagService) Canonicalize(ctx context.Context, kind model.TagKind, values []string) ([]string, error) {
+	ids := []string{}
+	for _, value := range values {
+		tag, err := service.Find(ctx, kind, value)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			tag, err = service.Resolve(ctx, kind, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(ids, tag.Id.Hex()) {
+			ids = append(ids, tag.Id.Hex())
+		}
+	}
+	return ids, nil
+}
+
+func (service *TagService) AddUserTag(ctx context.Context, userId primitive.ObjectID, kind model.TagKind, name string) (*model.Tag, error) {
+	tags, added, err := service.AddUserTags(ctx, userId, kind, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	if len(added) == 0 {
+		return nil, NewAlreadyExistsError(strings.ToLower(string(kind)) + " already exists")
+	}
+	return tags[0], nil
+}
+
+// AddUserTags resolves every name to its tag and adds the tags the user does
+// not have yet in a single atomic update. It returns the resolved tags and
+// the ids that were actually added.
+func (service *TagService) AddUserTags(ctx context.Context, userId primitive.ObjectID, kind model.TagKind, names []string) ([]*model.Tag, []string, error) {
+	Log.Info("Adding " + strconv.Itoa(len(names)) + " " + strings.ToLower(string(kind)) + " tags to user with id: " + userId.Hex())
+	if len(names) > maxTagBatch {
+		return nil, nil, NewInvalidArgumentError("too many tags", FieldViolation{Field: "values", Description: "must not have more than " + strconv.Itoa(maxTagBatch) + " entries"})
+	}
+	var tags []*model.Tag
+	var ids []string
+	for _, name := range names {
+		tag, err := service.Resolve(ctx, kind, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		tags = append(tags, tag)
+		ids = append(ids, tag.Id.Hex())
+	}
+	added, err := service.store.AddUserTags(ctx, userId, kind, ids)
+	if err != nil {
+		return nil, nil, notFoundOr(err, "user not found")
+	}
+	for _, id := range added {
+		if tagId, err := primitive.ObjectIDFromHex(id); err == nil {
+			service.changeUsage(ctx, tagId, 1)
+		}
+	}
+	if len(added) > 0 {
+		service.completeness.Refresh(ctx, userId)
+	}
+	return tags, added, nil
+}
+
+// RemoveUserTag accepts the tag id or any of its names. Removing a tag the
+// user does not have is not an error.
+func (service *TagService) RemoveUserTag(ctx context.Context, userId primitive.ObjectID, kind model.TagKind, value string) error {
+	_, err := service.RemoveUserTags(ctx, userId, kind, []string{value})
+	return err
+}
+
+// RemoveUserTags removes several tags, given by id or by any of their names,
+// in a single atomic update and returns the ids that were removed.
+func (service *TagService) RemoveUserTags(ctx context.Context, userId primitive.ObjectID, kind model.TagKind, values []string) ([]string, error) {
+	Log.Info("Removing " + strconv.Itoa(len(values)) + " " + strings.ToLower(string(kind)) + " tags from user with id: " + userId.Hex())
+	if len(values) > maxTagBatch {
+		return nil, NewInvalidArgumentError("too many tags", FieldViolation{Field: "values", Description: "must not have more than " + strconv.Itoa(maxTagBatch) + " entries"})
+	}
+	ids := service.ids(ctx, kind, values)
+	removed, err := service.store.RemoveUserTags(ctx, userId, kind, ids)
+	if err != nil {
+		return nil, notFoundOr(err, "user not found")
+	}
+	for _, id := range removed {
+		if tagId, err := primitive.ObjectIDFromHex(id); err == nil {
+			service.changeUsage(ctx, tagId, -1)
+		}
+		if kind == model.SKILL {
+			err = service.store.DeleteSkillEndorsements(ctx, userId.Hex(), id)
+			if err != nil {
+				Log.Warn("Cannot delete endorsements of removed skill for user with id: " + userId.Hex())
+			}
+		}
+	}
+	if len(removed) > 0 {
+		service.completeness.Refresh(ctx, userId)
+	}
+	return removed, nil
+}
+
+// ReorderUserTags sets the display order of a user's tags. The values must
+// name exactly the tags the user has, each once.
+func (service *TagService) ReorderUserTags(ctx context.Context, userId primitive.ObjectID, kind model.TagKind, values []string) error {
+	Log.Info("Reordering " + strings.ToLower(string(kind)) + " tags of user with id: " + userId.Hex())
+	user, err := service.store.Get(ctx, userId)
+	if err != nil {
+		return notFoundOr(err, "user not found")
+	}
+	ids := service.ids(ctx, kind, values)
+	current := *userTags(user, kind)
+	for i, id := range ids {
+		if !slices.Contains(current, id) || slices.Index(ids, id) != i {
+			return NewInvalidArgumentError("order does not match the user's tags", FieldViolation{Field: "values", Description: values[i] + " is unknown or listed twice"})
+		}
+	}
+	if len(ids) != len(current) {
+		return NewInvalidArgumentError("order does not match the user's tags", FieldViolation{Field: "values", Description: "must list every " + strings.ToLower(string(kind)) + " of the user"})
+	}
+	err = service.store.ReorderUserTags(ctx, userId, kind, ids)
+	if errors.Is(err, model.ErrVersionConflict) {
+		return NewAbortedError(strings.ToLower(string(kind)) + "s were changed in the meantime, reload them and try again")
+	}
+	return err
+}
+
+// Claim raises the usage of every tag of a newly created user.
+func (service *TagService) Claim(ctx context.Context, user *model.User) {
+	service.adjustUsage(ctx, user, 1)
+}
+
+// Release lowers the usage of one tag of a user that is being deleted. Ids
+// that are not tag ids are ignored.
+func (service *TagService) Release(ctx context.Context, id string) error {
+	tagId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil
+	}
+	return service.store.IncrementTagUsage(ctx, tagId, -1)
+}
+
+// Autocomplete returns the tags whose name or alias starts with the prefix,
+// most popular first.
+func (service *TagService) Autocomplete(ctx context.Context, kind model.TagKind, prefix string, limit int64) ([]*model.Tag, error) {
+	if limit <= 0 {
+		limit = defaultAutocompleteLimit
+	}
+	if limit > maxAutocompleteLimit {
+		limit = maxAutocompleteLimit
+	}
+	return service.store.SearchTags(ctx, kind, NormalizeTag(prefix), limit)
+}
+
+func (service *TagService) Create(ctx context.Context, tag *model.Tag) (*model.Tag, error) {
+	Log.Info("Creating " + strings.ToLower(string(tag.Kind)) + " tag: " + tag.Name)
+	tag.Name = strings.Join(strings.Fields(tag.Name), " ")
+	tag.Usage = 0
+	err := service.prepare(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	tag, err = service.store.CreateTag(ctx, tag)
+	if err != nil {
+		return nil, alreadyExistsOr(err, "tag name or alias is already in use")
+	}
+	return tag, nil
+}
+
+// Update renames a tag or changes its aliases and category. The kind and the
+// usage count cannot be changed.
+func (service *TagService) Update(ctx context.Context, changes *model.Tag) (*model.Tag, error) {
+	Log.Info("Updating tag with id: " + changes.Id.Hex())
+	tag, err := service.store.GetTag(ctx, changes.Id)
+	if err != nil {
+		return nil, notFoundOr(err, "tag not found")
+	}
+	tag.Name = strings.Join(strings.Fields(changes.Name), " ")
+	tag.Aliases = changes.Aliases
+	tag.Category = changes.Category
+	err = service.prepare(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	return service.store.UpdateTag(ctx, tag.Id, tag)
+}
+
+// Merge folds the source tag into the target: its name and aliases become
+// aliases of the target, every user is moved over and the source is deleted.
+func (service *TagService) Merge(ctx context.Context, sourceId primitive.ObjectID, targetId primitive.ObjectID) (*model.Tag, error) {
+	Log.Info("Merging tag with id: " + sourceId.Hex() + " into tag with id: " + targetId.Hex())
+	if sourceId == targetId {
+		return nil, NewInvalidArgumentError("cannot merge a tag into itself", FieldViolation{Field: "targetId", Description: "must differ from sourceId"})
+	}
+	var target *model.Tag
+	var moved int64
+	// The users, endorsements and both tags change together, so that a
+	// failure halfway leaves the taxonomy as it was.
+	err := service.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		source, err := service.store.GetTag(ctx, sourceId)
+		if err != nil {
+			return notFoundOr(err, "source tag not found")
+		}
+		target, err = service.store.GetTag(ctx, targetId)
+		if err != nil {
+			return notFoundOr(err, "target tag not found")
+		}
+		if source.Kind != target.Kind {
+			return NewFailedPreconditionError("cannot merge a " + strings.ToLower(string(source.Kind)) + " into a " + strings.ToLower(string(target.Kind)))
+		}
+
+		moved, err = service.moveUsers(ctx, source, target)
+		if err != nil {
+			Log.Error("Cannot move users from tag with id: " + sourceId.Hex())
+			return err
+		}
+		if source.Kind == model.SKILL {
+			err = service.store.ReplaceEndorsementSkill(ctx, source.Id.Hex(), target.Id.Hex())
+			if err != nil {
+				Log.Error("Cannot move endorsements from tag with id: " + sourceId.Hex())
+				return err
+			}
+		}
+
+		for _, alias := range append([]string{source.Name}, source.Aliases...) {
+			if !containsFold(target.Aliases, alias) && NormalizeTag(alias) != NormalizeTag(target.Name) {
+				target.Aliases = append(target.Aliases, alias)
+			}
+		}
+		for _, key := range source.Keys {
+			if !slices.Contains(target.Keys, key) {
+				target.Keys = append(target.Keys, key)
+			}
+		}
+		if target.Category == "" {
+			target.Category = source.Category
+		}
+		// Users who had both tags now have the target once, so the usage is
+		// counted again rather than added up.
+		target.Usage, err = service.store.CountUserTag(ctx, target.Kind, target.Id.Hex())
+		if err != nil {
+			return err
+		}
+
+		err = service.store.DeleteTag(ctx, source.Id)
+		if err != nil {
+			return err
+		}
+		target, err = service.store.UpdateTag(ctx, target.Id, target)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	Log.Info("Merged tag with id: " + sourceId.Hex() + ", " + strconv.FormatInt(moved, 10) + " users updated")
+	return target, nil
+}
+
+// moveUsers points every user that has source at target instead; users that
+// already have both simply lose source. Each user is rewritten whole, which
+// also rebuilds their search fields. It returns how many users changed.
+func (service *TagService) moveUsers(ctx context.Context, source *model.Tag, target *model.Tag) (int64, error) {
+	userIds, err := service.store.GetUserIdsWithTag(ctx, source.Kind, source.Id.Hex())
+	if err != nil {
+		return 0, err
+	}
+	moved := int64(0)
+	for _, userId := range userIds {
+		changed := false
+		_, err = updateUser(ctx, service.store, userId, func(user *model.User) error {
+			tags := userTags(user, source.Kind)
+			index := slices.Index(*tags, source.Id.Hex())
+			changed = index >= 0
+			if !changed {
+				return errUserUnchanged
+			}
+			if slices.Contains(*tags, target.Id.Hex()) {
+				*tags = slices.Delete(*tags, index, index+1)
+			} else {
+				(*tags)[index] = target.Id.Hex()
+			}
+			return nil
+		})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+		if changed {
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// Backfill rewrites the free-text skills and interests stored before the
+// taxonomy existed to canonical tag ids and recomputes the usage counts. It
+// can be run again at any time to repair drifted counts.
+func (service *TagService) Backfill(ctx context.Context) (*TagBackfill, error) {
+	users, err := service.store.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &TagBackfill{}
+	usage := map[string]int64{}
+	for _, user := range users {
+		changed := false
+		user, err = updateUser(ctx, service.store, user.Id, func(user *model.User) error {
+			changed = false
+			for _, kind := range model.TagKinds {
+				tags := userTags(user, kind)
+				ids, err := service.Canonicalize(ctx, kind, *tags)
+				if err != nil {
+					Log.Error("Cannot canonicalize tags of user with id: " + user.Id.Hex())
+					return err
+				}
+				if !slices.Equal(ids, *tags) {
+					*tags = ids
+					changed = true
+				}
+			}
+			if !changed {
+				return errUserUnchanged
+			}
+			return nil
+		})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Users++
+		for _, kind := range model.TagKinds {
+			for _, id := range *userTags(user, kind) {
+				usage[id]++
+			}
+		}
+		if changed {
+			result.Rewritten++
+		}
+	}
+
+	for _, kind := range model.TagKinds {
+		tags, err := service.store.GetTags(ctx, kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			result.Tags++
+			if tag.Usage != usage[tag.Id.Hex()] {
+				tag.Usage = usage[tag.Id.Hex()]
+				_, err = service.store.UpdateTag(ctx, tag.Id, tag)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// TagNames maps the tag ids referenced by the given users to display names.
+// Values that are not tag ids, such as entries that were not backfilled yet,
+// are left out and shown as they are.
+func (service *TagService) TagNames(ctx context.Context, users ...*model.User) (map[string]string, error) {
+	var values []string
+	for _, user := range users {
+		values = append(append(values, user.Skills...), user.Interests...)
+	}
+	return service.Names(ctx, values)
+}
+
+// Names maps the tag ids among values to display names.
+func (service *TagService) Names(ctx context.Context, values []string) (map[string]string, error) {
+	var ids []primitive.ObjectID
+	for _, value := range values {
+		if id, err := primitive.ObjectIDFromHex(value); err == nil && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	names := map[string]string{}
+	if len(ids) == 0 {
+		return names, nil
+	}
+	tags, err := service.store.GetTagsByIds(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		names[tag.Id.Hex()] = tag.Name
+	}
+	return names, nil
+}
+
+// Find looks a value up as a tag id first and as a name or alias second,
+// without creating anything.
+func (service *TagService) Find(ctx context.Context, kind model.TagKind, value string) (*model.Tag, error) {
+	if id, err := primitive.ObjectIDFromHex(value); err == nil {
+		tag, err := service.store.GetTag(ctx, id)
+		if err == nil && tag.Kind == kind {
+			return tag, nil
+		}
+	}
+	return service.store.GetTagByKey(ctx, kind, NormalizeTag(value))
+}
+
+// prepare validates a tag and rebuilds its lookup keys, rejecting any key that
+// already belongs to another tag of the same kind.
+func (service *TagService) prepare(ctx context.Context, tag *model.Tag) error {
+	v := NewValidator()
+	v.Tag("tag.name", tag.Name)
+	if !slices.Contains(model.TagKinds, tag.Kind) {
+		v.AddViolation("tag.kind", "must be one of SKILL, INTEREST")
+	}
+	if len(tag.Aliases) > maxTagAliases {
+		v.AddViolation("tag.aliases", "must not have more than "+strconv.Itoa(maxTagAliases)+" entries")
+	}
+	for _, alias := range tag.Aliases {
+		v.Tag("tag.aliases", alias)
+	}
+	v.Length("tag.category", tag.Category, 0, MaxTagLength)
+	if err := v.Err(); err != nil {
+		return err
+	}
+
+	tag.Keys = []string{NormalizeTag(tag.Name)}
+	for _, alias := range tag.Aliases {
+		if key := NormalizeTag(alias); !slices.Contains(tag.Keys, key) {
+			tag.Keys = append(tag.Keys, key)
+		}
+	}
+	for _, key := range tag.Keys {
+		existing, err := service.store.GetTagByKey(ctx, tag.Kind, key)
+		if err == nil && existing.Id != tag.Id {
+			return NewAlreadyExistsError("\"" + key + "\" already belongs to tag " + existing.Name)
+		}
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ids maps tag names to ids. Values that match no tag are kept as they are,
+// so that leftovers that were never backfilled can still be removed.
+func (service *TagService) ids(ctx context.Context, kind model.TagKind, values []string) []string {
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		if tag, err := service.Find(ctx, kind, value); err == nil {
+			ids = append(ids, tag.Id.Hex())
+		} else {
+			ids = append(ids, value)
+		}
+	}
+	return ids
+}
+
+func (service *TagService) adjustUsage(ctx context.Context, user *model.User, delta int64) {
+	for _, kind := range model.TagKinds {
+		for _, id := range *userTags(user, kind) {
+			if tagId, err := primitive.ObjectIDFromHex(id); err == nil {
+				service.changeUsage(ctx, tagId, delta)
+			}
+		}
+	}
+}
+
+func (service *TagService) changeUsage(ctx context.Context, id primitive.ObjectID, delta int64) {
+	err := service.store.IncrementTagUsage(ctx, id, delta)
+	if err != nil {
+		Log.Warn("Cannot update usage of tag with id: " + id.Hex() + ": " + err.Error())
+	}
+}
+
+func userTags(user *model.User, kind model.TagKind) *[]string {
+	if kind == model.INTEREST {
+		return &user.Interests
+	}
+	return &user.Skills
+}
+
+func containsFold(values []string, value string) bool {
+	for _, current := range values {
+		if NormalizeTag(current) == NormalizeTag(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,13 +3,12 @@ package application
 import (
 	"context"
 	"errors"
-	"fmt"
 	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
 	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
 	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/security"
-	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/services"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"regexp"
 	"strings"
 	"time"
@@ -17,43 +16,155 @@ import (
 	"user-microservice/startup/config"
 )
 
+// User fields that users may change through a profile update. Everything else
+// has a dedicated flow.
+const (
+	UserName        = "name"
+	UserSurname     = "surname"
+	UserPhoneNumber = "phoneNumber"
+	UserGender      = "gender"
+	UserBirthDate   = "birthDate"
+	UserBio         = "bio"
+	UserEmail       = "email"
+)
+
+var userProfileFields = []string{UserName, UserSurname, UserPhoneNumber, UserGender, UserBirthDate, UserBio}
+
 type UserService struct {
 	store            model.UserStore
 	config           *config.Config
 	connectionClient connectionService.ConnectionServiceClient
+	audit            *AuditService
+	tags             *TagService
+	completeness     *CompletenessService
+	usernames        *UsernameService
+	search           model.SearchIndex
+	events           *EventService
 }
 
-func NewUserService(store model.UserStore, config *config.Config) *UserService {
+func NewUserService(store model.UserStore, config *config.Config, connectionClient connectionService.ConnectionServiceClient, audit *AuditService, tags *TagService, completeness *CompletenessService, usernames *UsernameService, search model.SearchIndex, events *EventService) *UserService {
 	return &UserService{
 		store:            store,
 		config:           config,
-		connectionClient: services.NewConnectionClient(fmt.Sprintf("%s:%s", config.ConnectionServiceHost, config.ConnectionServicePort)),
+		connectionClient: connectionClient,
+		audit:            audit,
+		tags:             tags,
+		completeness:     completeness,
+		usernames:        usernames,
+		search:           search,
+		events:           events,
 	}
 }
 
 func (service *UserService) Get(ctx context.Context, id primitive.ObjectID) (*model.User, error) {
 	Log.Info("Getting user with id: " + id.Hex())
-	return service.store.Get(ctx, id)
+	user, err := service.store.Get(ctx, id)
+	if err != nil {
+		return nil, notFoundOr(err, "user not found")
+	}
+	return user, nil
 }
 
-func (service *UserService) GetAll(ctx context.Context) ([]*model.User, error) {
-	Log.Info("Getting all users")
+// GetAll returns every user, private profiles and contact details included,
+// so only admins may call it.
+func (service *UserService) GetAll(ctx context.Context, requesterId primitive.ObjectID) ([]*model.User, error) {
+	Log.Info("Getting all users for user with id: " + requesterId.Hex())
+	requester, err := service.store.Get(ctx, requesterId)
+	if err != nil {
+		return nil, notFoundOr(err, "user not found")
+	}
+	if requester.Role != model.ADMIN {
+		Log.Warn("User with id: " + requesterId.Hex() + " tried to get all users")
+		return nil, NewPermissionDeniedError("only admins can get all users, use ListUsersRequest")
+	}
 	return service.store.GetAll(ctx)
 }
 
+// List returns one page of users and the token of the next page, which is
+// empty on the last page. pageToken is empty for the first page and must come
+// from a listing with the same sort order. Only admins see private profiles
+// and contact details or can filter by account state; everyone else gets
+// public profiles without email, phone number and birth date.
+func (service *UserService) List(ctx context.Context, requesterId primitive.ObjectID, filter model.UserFilter, sort model.UserSort, descending bool, pageToken string, pageSize int64) ([]*model.User, string, error) {
+	Log.Info("Listing users for user with id: " + requesterId.Hex() + " sorted by: " + string(sort))
+	requester, err := service.store.Get(ctx, requesterId)
+	if err != nil {
+		return nil, "", notFoundOr(err, "user not found")
+	}
+	admin := requester.Role == model.ADMIN
+	if !admin {
+		if filter.Confirmed != nil || filter.TFAEnabled != nil || (filter.Private != nil && *filter.Private) {
+			return nil, "", NewPermissionDeniedError("only admins can filter by account state or list private profiles")
+		}
+		public := false
+		filter.Private = &public
+	}
+	if sort == "" {
+		sort = model.SORT_CREATED
+	}
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	query := &model.UserListQuery{
+		Filter:     filter,
+		Sort:       sort,
+		Descending: descending,
+		Limit:      pageSize + 1,
+	}
+	if pageToken != "" {
+		after, err := decodePageToken(pageToken, sort, descending)
+		if err != nil {
+			return nil, "", err
+		}
+		query.After = after
+	}
+	if filter.SkillId != "" {
+		tag, err := service.tags.Find(ctx, model.SKILL, filter.SkillId)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return []*model.User{}, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		query.Filter.SkillId = tag.Id.Hex()
+	}
+
+	users, err := service.store.ListUsers(ctx, query)
+	if err != nil {
+		Log.Error("Cannot list users")
+		return nil, "", err
+	}
+	nextPageToken := ""
+	if int64(len(users)) > pageSize {
+		users = users[:pageSize]
+		nextPageToken = encodePageToken(users[len(users)-1], sort, descending)
+	}
+	if !admin {
+		for _, user := range users {
+			user.Email = ""
+			user.PhoneNumber = ""
+			user.BirthDate = time.Time{}
+		}
+	}
+	return users, nextPageToken, nil
+}
+
 func (service *UserService) Create(ctx context.Context, user *model.User) (*model.User, error) {
 	Log.Info("Create new user with username: " + user.Username)
-	_, err := service.store.GetByUsername(ctx, user.Username)
-	if err == nil {
-		Log.Warn("Username already exists")
-		return nil, errors.New("username already exists")
+	err := service.usernames.Check(ctx, user.Username, "")
+	if err != nil {
+		Log.Warn("Username is not available")
+		return nil, err
 	}
 
 	if user.Email != "" {
 		_, err = service.store.GetByEmail(ctx, user.Email)
 		if err == nil {
 			Log.Warn("Email already exists")
-			return nil, errors.New("email already exists")
+			return nil, NewAlreadyExistsError("email already exists")
 		}
 	}
 
@@ -71,6 +182,15 @@ func (service *UserService) Create(ctx context.Context, user *model.User) (*mode
 	}
 	user.Password = hashedPassword
 
+	user.Skills, err = service.tags.Canonicalize(ctx, model.SKILL, user.Skills)
+	if err != nil {
+		return nil, err
+	}
+	user.Interests, err = service.tags.Canonicalize(ctx, model.INTEREST, user.Interests)
+	if err != nil {
+		return nil, err
+	}
+
 	user.Confirmed = false
 	user.ConfirmationId = uuid.New().String()
 	err = SendConfirmationMail(ctx, user)
@@ -79,43 +199,56 @@ func (service *UserService) Create(ctx context.Context, user *model.User) (*mode
 		return nil, err
 	}
 
+	err = service.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		_, err := service.store.Create(ctx, user)
+		if err != nil {
+			return err
+		}
+		return service.events.Emit(ctx, &model.UserRegistered{UserId: user.Id.Hex(), Username: user.Username, Private: user.Private})
+	})
+	if err != nil {
+		return nil, alreadyExistsOr(err, "username or email already exists")
+	}
+	service.tags.Claim(ctx, user)
+	service.completeness.Refresh(ctx, user.Id)
+	service.indexForSearch(ctx, user)
 	Log.Info("Created new user with username: " + user.Username)
-	return service.store.Create(ctx, user)
+	return user, nil
 }
 
 func (service *UserService) IsPasswordOk(password string) error {
 	if len(password) < 8 {
 		Log.Warn("Password is too week")
-		return errors.New("Password must be atleast 8 characters")
+		return passwordViolation("Password must be atleast 8 characters")
 	}
 
 	match, _ := regexp.MatchString("[0-9]", password)
 	if !match {
 		Log.Warn("Password is too week")
-		return errors.New("Password must contain atleast 1 number")
+		return passwordViolation("Password must contain atleast 1 number")
 	}
 
 	match, _ = regexp.MatchString("[A-Z]", password)
 	if !match {
 		Log.Warn("Password is too week")
-		return errors.New("Password must contain atleast 1 upper case")
+		return passwordViolation("Password must contain atleast 1 upper case")
 	}
 
 	match, _ = regexp.MatchString("[a-z]", password)
 	if !match {
 		Log.Warn("Password is too week")
-		return errors.New("Password must contain atleast 1 lower case")
+		return passwordViolation("Password must contain atleast 1 lower case")
 	}
 
 	match, _ = regexp.MatchString("[.,<>/?|';:!@#$%^&*()_+=-]", password)
 	if !match {
 		Log.Warn("Password is too week")
-		return errors.New("Password must contain atleast 1 special characher")
+		return passwordViolation("Password must contain atleast 1 special characher")
 	}
 
 	/*for _, commonPassword := range service.config.CommonPasswords {
 		if strings.Contains(commonPassword, password) || strings.Contains(password, commonPassword) {
-			return errors.New("Password must not be a common password or containts common. (" + commonPassword + ")")
+			return passwordViolation("Password must not be a common password or containts common. (" + commonPassword + ")")
 		}
 	}*/
 	err := service.CheckIsPasswordInCommonPasswords(password)
@@ -141,12 +274,16 @@ func (service *UserService) CheckIsPasswordInCommonPasswords(password string) er
 	for i := 0; i < numRoutines; i++ {
 		common := <-c
 		if common != "" {
-			return errors.New("Password must not be a common password or containts common. (" + common + ")")
+			return passwordViolation("Password must not be a common password or containts common. (" + common + ")")
 		}
 	}
 	return nil
 }
 
+func passwordViolation(description string) error {
+	return NewInvalidArgumentError(description, FieldViolation{Field: "password", Description: description})
+}
+
 func contain(password string, subarray []string, c chan string) {
 	for _, commonPassword := range subarray {
 		if strings.Contains(commonPassword, password) || strings.Contains(password, commonPassword) {
@@ -157,81 +294,211 @@ func contain(password string, subarray []string, c chan string) {
 	c <- ""
 }
 
-func (service *UserService) Update(ctx context.Context, userId primitive.ObjectID, user *model.User) (*model.User, error) {
+// Update copies the named profile fields from changes onto the user, provided
+// the user is still at the given version. An empty field list updates every
+// field in the allow-list; any other field is rejected.
+func (service *UserService) Update(ctx context.Context, userId primitive.ObjectID, changes *model.User, fields []string, version int64) (*model.User, error) {
 	Log.Info("Updating user with id:" + userId.Hex())
 	existUser, err := service.store.Get(ctx, userId)
 	if err != nil {
 		Log.Warn("Unexciting user with id: " + userId.Hex())
+		return nil, notFoundOr(err, "user not found")
+	}
+	if existUser.Version != version {
+		Log.Warn("Stale update of user with id: " + userId.Hex())
+		return nil, staleUserError()
+	}
+
+	profile := &model.UserProfile{
+		Name:        existUser.Name,
+		Surname:     existUser.Surname,
+		PhoneNumber: existUser.PhoneNumber,
+		Gender:      existUser.Gender,
+		BirthDate:   existUser.BirthDate,
+		Bio:         existUser.Bio,
+	}
+	if len(fields) == 0 {
+		fields = userProfileFields
+	}
+	for _, field := range fields {
+		switch field {
+		case UserName:
+			profile.Name = changes.Name
+		case UserSurname:
+			profile.Surname = changes.Surname
+		case UserPhoneNumber:
+			profile.PhoneNumber = changes.PhoneNumber
+		case UserGender:
+			profile.Gender = changes.Gender
+		case UserBirthDate:
+			profile.BirthDate = changes.BirthDate
+		case UserBio:
+			profile.Bio = changes.Bio
+		case UserEmail:
+			return nil, NewFailedPreconditionError("email has to be changed with RequestEmailChange so that the new address is verified")
+		default:
+			return nil, NewInvalidArgumentError("field cannot be updated", FieldViolation{Field: "updateMask", Description: field + " cannot be updated"})
+		}
+	}
+	err = validateProfile(profile)
+	if err != nil {
 		return nil, err
 	}
-	user.Role = existUser.Role
-	user.Username = existUser.Username
-	user.Password = existUser.Password
-	user.Confirmed = existUser.Confirmed
-	user.ConfirmationId = existUser.ConfirmationId
-	Log.Info("user with id: " + userId.Hex() + " updated")
-	return service.store.Update(ctx, userId, user)
-}
 
-func (service *UserService) UpdatePassword(ctx context.Context, userId primitive.ObjectID, user *model.User) (*model.User, error) {
-	Log.Warn("Updating password for user with id: " + userId.Hex())
-	existUser, err := service.store.Get(ctx, userId)
+	user, err := service.store.UpdateProfile(ctx, userId, version, profile)
+	if errors.Is(err, model.ErrVersionConflict) {
+		Log.Warn("Concurrent update of user with id: " + userId.Hex())
+		return nil, staleUserError()
+	}
 	if err != nil {
-		Log.Warn("Unexciting user with id: " + userId.Hex())
 		return nil, err
 	}
-	user.Role = existUser.Role
-	Log.Info("Updated user with id: " + userId.Hex())
-	return service.store.Update(ctx, userId, user)
+	service.completeness.Refresh(ctx, userId)
+	service.indexForSearch(ctx, user)
+	Log.Info("user with id: " + userId.Hex() + " updated")
+	return user, nil
 }
 
-func (service *UserService) UpdateUsername(ctx context.Context, userId primitive.ObjectID, user *model.User) (*model.User, error) {
-	Log.Info("Updating username for user with id: " + userId.Hex())
-	existUser, err := service.store.Get(ctx, userId)
-	if err != nil {
-		Log.Warn("Unexciting user with id: " + userId.Hex())
-		return nil, err
+func staleUserError() error {
+	return NewAbortedError("profile was changed in the meantime, reload it and try again")
+}
+
+func validateProfile(profile *model.UserProfile) error {
+	v := NewValidator()
+	if v.Required("user.name", profile.Name) {
+		v.Length("user.name", profile.Name, 1, MaxNameLength)
+	}
+	if v.Required("user.surname", profile.Surname) {
+		v.Length("user.surname", profile.Surname, 1, MaxNameLength)
+	}
+	v.PhoneNumber("user.phoneNumber", profile.PhoneNumber)
+	if profile.Gender != model.MALE && profile.Gender != model.FEMALE {
+		v.AddViolation("user.gender", "is not a known gender")
 	}
-	user.Role = existUser.Role
-	return service.store.Update(ctx, userId, user)
+	if profile.BirthDate.IsZero() {
+		v.AddViolation("user.birthDate", "is required")
+	} else {
+		v.BirthDate("user.birthDate", profile.BirthDate)
+	}
+	v.Length("user.bio", profile.Bio, 0, MaxBioLength)
+	return v.Err()
 }
 
-func (service *UserService) Delete(ctx context.Context, id primitive.ObjectID) error {
-	Log.Info("Deleting user with id: " + id.Hex())
-	return service.store.Delete(ctx, id)
+// UpdatePassword stores an already hashed password.
+func (service *UserService) UpdatePassword(ctx context.Context, userId primitive.ObjectID, hashedPassword string) (*model.User, error) {
+	Log.Warn("Updating password for user with id: " + userId.Hex())
+	user, err := updateUser(ctx, service.store, userId, func(user *model.User) error {
+		user.Password = hashedPassword
+		return nil
+	})
+	if err != nil {
+		Log.Warn("Password of user with id: " + userId.Hex() + " was not updated")
+		return nil, err
+	}
+	Log.Info("Updated user with id: " + userId.Hex())
+	return user, nil
 }
 
 func (service *UserService) DeleteAll(ctx context.Context) {
 	Log.Info("Deleting all users")
 	service.store.DeleteAll(ctx)
+	err := service.search.Reset(ctx)
+	if err != nil {
+		Log.Warn("Cannot reset search index")
+	}
 }
 
-func (service *UserService) Search(ctx context.Context, searchParam string, userId string) ([]*model.User, error) {
+// indexForSearch updates the search index right away so that a user finds
+// their own change; the change feed would get there a moment later anyway.
+func (service *UserService) indexForSearch(ctx context.Context, user *model.User) {
+	err := service.search.Index(ctx, user)
+	if err != nil {
+		Log.Warn("Cannot index user with id: " + user.Id.Hex() + " for search")
+	}
+}
+
+// Search returns one page of users matching searchParam, best matches first,
+// and the token of the next page. The searching user and everyone they
+// blocked or were blocked by are left out.
+func (service *UserService) Search(ctx context.Context, searchParam string, userId string, pageToken string, pageSize int64) ([]*model.User, string, error) {
 	Log.Info("Searching users by user with id:" + userId)
-	users, err := service.store.GetAllWithoutAdmins(ctx)
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	query := &model.UserSearchQuery{
+		Tokens: model.SearchQueryTokens(model.FoldSearchText(searchParam)),
+		Limit:  pageSize + 1,
+	}
+	if pageToken != "" {
+		after, err := decodeSearchPageToken(pageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		query.After = after
+	}
 
+	excluded, hide, err := searchExclusions(ctx, service.connectionClient, service.config.SearchDegradedMode, userId)
 	if err != nil {
 		Log.Info("Error occuered in search of user with id: " + userId)
-		return nil, err
+		return nil, "", err
 	}
-	searchParam = strings.ToLower(searchParam)
-	var retVal []*model.User
-	for _, user := range users {
-		if strings.Contains(strings.ToLower(user.Username), searchParam) || strings.Contains(strings.ToLower(user.Name), searchParam) || strings.Contains(strings.ToLower(user.Surname), searchParam) || strings.Contains(strings.ToLower(user.Email), searchParam) {
-			isBlocked, err := service.connectionClient.IsBlockedAny(ctx, &connectionService.Block{UserId: userId, BlockUserId: user.Id.Hex()})
-
-			if err != nil {
-				Log.Info("Error occuered in search of user with id: " + userId)
-				return nil, err
-			}
-			if !isBlocked.Blocked {
-				retVal = append(retVal, user)
-			}
-		}
+	if hide {
+		return []*model.User{}, "", nil
+	}
+	query.ExcludeIds = excluded
 
+	hits, err := service.search.Search(ctx, query)
+	if err != nil {
+		Log.Info("Error occuered in search of user with id: " + userId)
+		return nil, "", err
+	}
+	nextPageToken := ""
+	if int64(len(hits)) > pageSize {
+		hits = hits[:pageSize]
+		nextPageToken = encodeSearchPageToken(hits[len(hits)-1])
+	}
+	users, err := service.hitUsers(ctx, hits)
+	if err != nil {
+		Log.Info("Error occuered in search of user with id: " + userId)
+		return nil, "", err
 	}
 	Log.Info("Search succeed of user with id: " + userId)
-	return retVal, nil
+	return users, nextPageToken, nil
+}
+
+// hitUsers returns the users of the hits in hit order, loading those the
+// index did not return. Users deleted since they were indexed are left out.
+func (service *UserService) hitUsers(ctx context.Context, hits []*model.UserSearchHit) ([]*model.User, error) {
+	var missing []primitive.ObjectID
+	for _, hit := range hits {
+		if hit.User == nil {
+			missing = append(missing, hit.UserId)
+		}
+	}
+	loaded := map[primitive.ObjectID]*model.User{}
+	if len(missing) > 0 {
+		found, err := service.store.GetByIds(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range found {
+			loaded[user.Id] = user
+		}
+	}
+	users := make([]*model.User, 0, len(hits))
+	for _, hit := range hits {
+		user := hit.User
+		if user == nil {
+			user = loaded[hit.UserId]
+		}
+		if user != nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
 }
 
 func (service *UserService) IsUserPrivate(ctx context.Context, id primitive.ObjectID) (bool, error) {
@@ -253,18 +520,18 @@ func (service *UserService) RecoverPassword(ctx context.Context, in *userService
 	}
 
 	if in.PasswordRecovery.NewPassword != in.PasswordRecovery.ConfirmPassword {
-		return errors.New("Passwords are not the same")
+		return NewInvalidArgumentError("Passwords are not the same", FieldViolation{Field: "confirmPassword", Description: "must match the new password"})
 	}
 
 	passwordRecoveryRequest, err := service.store.GetPasswordRecoveryRequest(ctx, recoveryId)
 	if err != nil {
 		Log.Error("Unexpected error with database occurred")
-		return err
+		return notFoundOr(err, "Recovery link has expired or used already")
 	}
 	now := time.Now()
 	if passwordRecoveryRequest.ValidTo.Before(now) {
 		Log.Error("Recovery link has expired or used already with id:" + recoveryId.Hex())
-		return errors.New("Recovery link has expired or used already")
+		return NewFailedPreconditionError("Recovery link has expired or used already")
 	}
 
 	userId, err := primitive.ObjectIDFromHex(passwordRecoveryRequest.UserId)
@@ -284,17 +551,13 @@ func (service *UserService) RecoverPassword(ctx context.Context, in *userService
 		return err
 	}
 
+	service.audit.Record(ctx, model.PASSWORD_RECOVERED, userId.Hex(), map[string]string{"recoveryId": recoveryId.Hex()})
 	return nil
 }
 
 func (service *UserService) recoverUserPassword(ctx context.Context, userId primitive.ObjectID, newPassword string) error {
 	Log.Info("Start password recovering for user with id:" + userId.Hex())
-	user, err := service.store.Get(ctx, userId)
-	if err != nil {
-		return err
-	}
-
-	err = service.IsPasswordOk(newPassword)
+	err := service.IsPasswordOk(newPassword)
 	if err != nil {
 		return err
 	}
@@ -304,9 +567,10 @@ func (service *UserService) recoverUserPassword(ctx context.Context, userId prim
 		Log.Error("Unexpected error with bcrypt library")
 		return err
 	}
-	user.Password = hashedPassword
-
-	_, err = service.store.Update(ctx, userId, user)
+	_, err = updateUser(ctx, service.store, userId, func(user *model.User) error {
+		user.Password = hashedPassword
+		return nil
+	})
 	if err != nil {
 		Log.Error("Unexpected error with database occurred")
 		return err
@@ -314,30 +578,3 @@ func (service *UserService) recoverUserPassword(ctx context.Context, userId prim
 
 	return nil
 }
-
-func (service *UserService) ChangeProfilePrivacy(ctx context.Context, userId primitive.ObjectID) error {
-	Log.Info("Change profile privacy for user with id:" + userId.Hex())
-	user, err := service.store.Get(ctx, userId)
-	if err != nil {
-		Log.Error("Unexpected error with database occurred")
-		return err
-	}
-
-	user.Private = !user.Private
-
-	if !user.Private {
-		_, err = service.connectionClient.ApproveAllConnection(ctx, &connectionService.UserIdRequest{UserId: userId.Hex()})
-		if err != nil {
-			Log.Error("Unexpected error with database occurred")
-			return err
-		}
-	}
-
-	_, err = service.Update(ctx, user.Id, user)
-	if err != nil {
-		Log.Error("Unexpected error with database occurred")
-		return err
-	}
-	Log.Info("Profile with id:" + userId.Hex() + " changed privacy")
-	return nil
-}
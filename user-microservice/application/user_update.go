@@ -0,0 +1,43 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"user-microservice/model"
+)
+
+const userUpdateAttempts = 5
+
+// errUserUnchanged is returned by a change that leaves the user as it is, so
+// that nothing is written.
+var errUserUnchanged = errors.New("user unchanged")
+
+// updateUser applies change to a fresh copy of the user and writes it back
+// only if nobody changed the user in the meantime, starting over with a new
+// copy when somebody did. Writes that set single fields, such as the privacy,
+// images or tags, bump the version as well, so a whole-document write never
+// undoes them. change may run more than once.
+func updateUser(ctx context.Context, store model.UserStore, userId primitive.ObjectID, change func(user *model.User) error) (*model.User, error) {
+	for attempt := 1; ; attempt++ {
+		user, err := store.Get(ctx, userId)
+		if err != nil {
+			return nil, notFoundOr(err, "user not found")
+		}
+		err = change(user)
+		if errors.Is(err, errUserUnchanged) {
+			return user, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		updated, err := store.Update(ctx, userId, user)
+		if !errors.Is(err, model.ErrVersionConflict) {
+			return updated, err
+		}
+		if attempt == userUpdateAttempts {
+			return nil, &DomainError{Kind: Aborted, Message: "user is being changed concurrently, try again", Err: err}
+		}
+		Log.Warn("User with id: " + userId.Hex() + " changed while updating it, retrying")
+	}
+}
@@ -0,0 +1,169 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strconv"
+	"strings"
+	"time"
+	"user-microservice/model"
+	"user-microservice/startup/config"
+)
+
+// defaultReservedUsernames cannot be claimed by anyone because they would look
+// like the service itself or clash with routes of the web client.
+var defaultReservedUsernames = []string{
+	"admin", "administrator", "root", "system", "support", "help", "security",
+	"moderator", "staff", "official", "dislinkt", "api", "www", "mail",
+	"settings", "login", "logout", "register", "signup", "about", "privacy",
+	"terms", "jobs", "search", "me", "null", "undefined",
+}
+
+type UsernameService struct {
+	store       model.UserStore
+	audit       *AuditService
+	events      *EventService
+	cooldown    time.Duration
+	changeLimit int64
+	window      time.Duration
+	reserved    map[string]bool
+}
+
+func NewUsernameService(store model.UserStore, config *config.Config, audit *AuditService, events *EventService) *UsernameService {
+	service := &UsernameService{
+		store:       store,
+		audit:       audit,
+		events:      events,
+		cooldown:    config.UsernameCooldown,
+		changeLimit: config.UsernameChangeLimit,
+		window:      config.UsernameChangeWindow,
+		reserved:    map[string]bool{},
+	}
+	for _, username := range append(defaultReservedUsernames, config.ReservedUsernames...) {
+		service.reserved[strings.ToLower(username)] = true
+	}
+	return service
+}
+
+// Check returns nil when userId may take username. Pass an empty userId for a
+// new account. A username given up by somebody else stays unavailable until
+// its cooldown ends, while its previous owner may take it back at any time.
+func (service *UsernameService) Check(ctx context.Context, username string, userId string) error {
+	Log.Info("Checking username: " + username)
+	if service.reserved[strings.ToLower(username)] {
+		Log.Info("Reserved username: " + username)
+		return NewInvalidArgumentError("username is reserved", FieldViolation{Field: "username", Description: "is reserved"})
+	}
+	user, err := service.store.GetByUsername(ctx, username)
+	if err == nil {
+		if user.Id.Hex() == userId {
+			// Usernames are unique regardless of case, so users may change
+			// the case of their own.
+			return nil
+		}
+		Log.Info("Taken username: " + username)
+		return NewAlreadyExistsError("username already exists")
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	change, err := service.store.GetLatestUsernameChange(ctx, username)
+	if err == nil {
+		if change.UserId != userId && change.ReservedUntil.After(time.Now()) {
+			Log.Info("Username in cooldown: " + username)
+			return NewAlreadyExistsError("username was recently in use and is not available yet")
+		}
+		return nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	return nil
+}
+
+// Change renames a user and keeps the old username reserved for them so that
+// existing links keep working and nobody can pose as them right away.
+func (service *UsernameService) Change(ctx context.Context, userId primitive.ObjectID, username string) (*model.User, error) {
+	Log.Info("Changing username for user with id: " + userId.Hex())
+	user, err := service.store.Get(ctx, userId)
+	if err != nil {
+		return nil, notFoundOr(err, "user not found")
+	}
+	if user.Username == username {
+		return user, nil
+	}
+
+	now := time.Now()
+	changes, err := service.store.CountUsernameChanges(ctx, userId.Hex(), now.Add(-service.window))
+	if err != nil {
+		return nil, err
+	}
+	if changes >= service.changeLimit {
+		Log.Warn("User with id: " + userId.Hex() + " reached the username change limit")
+		return nil, NewFailedPreconditionError("username can be changed at most " + strconv.FormatInt(service.changeLimit, 10) +
+			" times every " + strconv.Itoa(int(service.window.Hours()/24)) + " days")
+	}
+	err = service.Check(ctx, username, userId.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	oldUsername := user.Username
+	err = service.store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = updateUser(ctx, service.store, userId, func(user *model.User) error {
+			oldUsername = user.Username
+			user.Username = username
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		_, err = service.store.CreateUsernameChange(ctx, &model.UsernameChange{
+			UserId:        userId.Hex(),
+			OldUsername:   oldUsername,
+			NewUsername:   username,
+			Timestamp:     now,
+			ReservedUntil: now.Add(service.cooldown),
+		})
+		if err != nil {
+			return err
+		}
+		return service.events.Emit(ctx, &model.UsernameChanged{UserId: userId.Hex(), OldUsername: oldUsername, NewUsername: username})
+	})
+	if err != nil {
+		return nil, alreadyExistsOr(err, "username already exists")
+	}
+	service.audit.Record(ctx, model.USERNAME_CHANGED, userId.Hex(), map[string]string{"from": oldUsername, "to": username})
+	Log.Info("Changed username for user with id: " + userId.Hex())
+	return user, nil
+}
+
+// Resolve finds the user behind a current or former username. The second
+// result is true when username is a former one and the caller should redirect
+// to the current username.
+func (service *UsernameService) Resolve(ctx context.Context, username string) (*model.User, bool, error) {
+	Log.Info("Resolving username: " + username)
+	user, err := service.store.GetByUsername(ctx, username)
+	if err == nil {
+		return user, false, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, err
+	}
+	change, err := service.store.GetLatestUsernameChange(ctx, username)
+	if err != nil {
+		return nil, false, notFoundOr(err, "user not found")
+	}
+	userId, err := primitive.ObjectIDFromHex(change.UserId)
+	if err != nil {
+		return nil, false, NewNotFoundError("user not found")
+	}
+	user, err = service.store.Get(ctx, userId)
+	if err != nil {
+		return nil, false, notFoundOr(err, "user not found")
+	}
+	return user, true, nil
+}
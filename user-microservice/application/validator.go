@@ -0,0 +1,152 @@
+package application
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 30
+	MaxNameLength     = 50
+	MaxTagLength      = 50
+	MaxBioLength      = 1000
+	MinimumAge        = 16
+)
+
+var (
+	usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._]+$`)
+	phonePattern    = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+	earliestBirth   = time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Validator collects every field violation of a request so that the caller
+// gets all of them in a single InvalidArgument error.
+type Validator struct {
+	violations []FieldViolation
+}
+
+func NewValidator() *Validator {
+	return &Validator{}
+}
+
+func (v *Validator) AddViolation(field string, description string) {
+	v.violations = append(v.violations, FieldViolation{Field: field, Description: description})
+}
+
+func (v *Validator) Valid() bool {
+	return len(v.violations) == 0
+}
+
+// Err returns nil when no violation was recorded.
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+	return NewInvalidArgumentError("request is not valid", v.violations...)
+}
+
+func (v *Validator) Required(field string, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.AddViolation(field, "is required")
+		return false
+	}
+	return true
+}
+
+func (v *Validator) ObjectId(field string, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+	if !primitive.IsValidObjectID(value) {
+		v.AddViolation(field, "must be a 24 character hex object id")
+	}
+}
+
+func (v *Validator) Length(field string, value string, min int, max int) {
+	length := utf8.RuneCountInString(strings.TrimSpace(value))
+	if length < min || length > max {
+		v.AddViolation(field, "must be between "+strconv.Itoa(min)+" and "+strconv.Itoa(max)+" characters long")
+	}
+}
+
+// Email accepts a bare RFC 5322 address without a display name.
+func (v *Validator) Email(field string, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != value {
+		v.AddViolation(field, "must be a valid email address")
+	}
+}
+
+// PhoneNumber accepts numbers in E.164 format. An empty value is allowed.
+func (v *Validator) PhoneNumber(field string, value string) {
+	if value == "" {
+		return
+	}
+	if !phonePattern.MatchString(value) {
+		v.AddViolation(field, "must be in E.164 format, e.g. +381641234567")
+	}
+}
+
+func (v *Validator) Username(field string, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+	if len(value) < MinUsernameLength || len(value) > MaxUsernameLength {
+		v.AddViolation(field, "must be between "+strconv.Itoa(MinUsernameLength)+" and "+strconv.Itoa(MaxUsernameLength)+" characters long")
+	}
+	if !usernamePattern.MatchString(value) {
+		v.AddViolation(field, "may contain only letters, digits, dots and underscores")
+	}
+}
+
+func (v *Validator) BirthDate(field string, value time.Time) {
+	if value.Before(earliestBirth) {
+		v.AddViolation(field, "must be after "+earliestBirth.Format("2006-01-02"))
+		return
+	}
+	if value.After(time.Now().AddDate(-MinimumAge, 0, 0)) {
+		v.AddViolation(field, "user must be at least "+strconv.Itoa(MinimumAge)+" years old")
+	}
+}
+
+func (v *Validator) Tag(field string, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+	v.Length(field, value, 1, MaxTagLength)
+}
+
+// DateOrder checks that an interval does not end before it starts. A zero end
+// means the interval is still ongoing.
+func (v *Validator) DateOrder(endField string, start time.Time, end time.Time) {
+	if !end.IsZero() && end.Before(start) {
+		v.AddViolation(endField, "must not be before the start date")
+	}
+}
+
+func (v *Validator) Matches(field string, value string, other string) {
+	if value != other {
+		v.AddViolation(field, "does not match")
+	}
+}
+
+// Url accepts an absolute http or https link.
+func (v *Validator) Url(field string, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+	parsed, err := url.ParseRequestURI(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		v.AddViolation(field, "must be an absolute http or https URL")
+	}
+}
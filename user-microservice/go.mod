@@ -13,6 +13,8 @@ require (
 	github.com/sirupsen/logrus v1.4.2
 	go.mongodb.org/mongo-driver v1.9.0
 	golang.org/x/exp v0.0.0-20220428152302-39d4317da171
+	golang.org/x/text v0.3.7
+	google.golang.org/genproto v0.0.0-20220422154200-b37d22cd5731
 	google.golang.org/grpc v1.46.0
 	rsc.io/qr v0.2.0
 )
@@ -42,7 +44,5 @@ require (
 	golang.org/x/net v0.0.0-20220421235706-1d1ef9303861 // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	golang.org/x/sys v0.0.0-20220422013727-9388b58f7150 // indirect
-	golang.org/x/text v0.3.7 // indirect
-	google.golang.org/genproto v0.0.0-20220422154200-b37d22cd5731 // indirect
 	google.golang.org/protobuf v1.28.0 // indirect
 )
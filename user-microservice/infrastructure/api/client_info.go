@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"strings"
+	"user-microservice/model"
+)
+
+// ParseTrustedProxies turns the addresses and CIDR ranges of TRUSTED_PROXIES
+// into networks. A plain address stands for that single host.
+func ParseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, value := range values {
+		if !strings.Contains(value, "/") && strings.Contains(value, ":") {
+			value += "/128"
+		} else if !strings.Contains(value, "/") {
+			value += "/32"
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// clientInfoFromContext reads the caller's address, user agent and device
+// fingerprint from the incoming metadata. Forwarded headers are only believed
+// when the request comes from one of the trusted proxies, such as the API
+// gateway; anyone else could set them to any address.
+func clientInfoFromContext(ctx context.Context, trustedProxies []*net.IPNet) *model.ClientInfo {
+	client := &model.ClientInfo{}
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if p, ok := peer.FromContext(ctx); ok {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err == nil {
+			client.Ip = host
+		}
+	}
+	if trustedProxy(client.Ip, trustedProxies) {
+		if forwarded := firstMetadataValue(md, "x-forwarded-for"); forwarded != "" {
+			client.Ip = forwardedClient(strings.Split(forwarded, ","), trustedProxies)
+		} else if realIp := firstMetadataValue(md, "x-real-ip"); realIp != "" {
+			client.Ip = realIp
+		}
+	}
+
+	client.UserAgent = firstMetadataValue(md, "grpcgateway-user-agent")
+	if client.UserAgent == "" {
+		client.UserAgent = firstMetadataValue(md, "user-agent")
+	}
+	client.Fingerprint = firstMetadataValue(md, "x-device-fingerprint")
+	return client
+}
+
+// forwardedClient returns the last address of a forwarded chain that was not
+// added by a trusted proxy. Addresses before it were sent by the client and
+// cannot be believed.
+func forwardedClient(chain []string, trustedProxies []*net.IPNet) string {
+	for i := len(chain) - 1; i > 0; i-- {
+		if ip := strings.TrimSpace(chain[i]); !trustedProxy(ip, trustedProxies) {
+			return ip
+		}
+	}
+	return strings.TrimSpace(chain[0])
+}
+
+func trustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns the token of the authorization header the gateway
+// passes on, or an empty string when there is none.
+func bearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	authorization := firstMetadataValue(md, "authorization")
+	if len(authorization) > len("bearer ") && strings.EqualFold(authorization[:len("bearer ")], "bearer ") {
+		return authorization[len("bearer "):]
+	}
+	return ""
+}
+
+func firstMetadataValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
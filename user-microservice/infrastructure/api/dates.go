@@ -0,0 +1,73 @@
+package api
+
+import (
+	"strings"
+	"time"
+	"user-microservice/application"
+)
+
+const (
+	dateLayout  = "2006-01-02"
+	presentDate = "present"
+)
+
+// parseDate accepts an ISO-8601 calendar date or an RFC 3339 timestamp and
+// returns the calendar date at midnight UTC. A timestamp keeps the day it has
+// in its own offset, so "2000-05-12T00:30:00+02:00" is still the 12th.
+func parseDate(value string) (time.Time, error) {
+	date, err := time.Parse(dateLayout, value)
+	if err != nil {
+		date, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	year, month, day := date.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC), nil
+}
+
+// parseOptionalDate returns the zero time for an empty value so that the
+// services can report a missing date together with other violations.
+func parseOptionalDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return parseDate(value)
+}
+
+// parseEndDate treats an empty value or "present" as an ongoing interval,
+// represented by the zero time.
+func parseEndDate(value string) (time.Time, error) {
+	if value == "" || strings.EqualFold(value, presentDate) {
+		return time.Time{}, nil
+	}
+	return parseDate(value)
+}
+
+func formatDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.UTC().Format(dateLayout)
+}
+
+func formatEndDate(date time.Time) string {
+	if date.IsZero() {
+		return presentDate
+	}
+	return formatDate(date)
+}
+
+func formatTimestamp(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return ""
+	}
+	return timestamp.UTC().Format(time.RFC3339)
+}
+
+func invalidDate(field string) error {
+	return application.NewInvalidArgumentError("invalid "+field, application.FieldViolation{
+		Field:       field,
+		Description: "must be an ISO-8601 date (YYYY-MM-DD) or an RFC 3339 timestamp",
+	})
+}
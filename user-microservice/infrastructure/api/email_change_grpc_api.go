@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+)
+
+func (handler *UserHandler) RequestEmailChange(ctx context.Context, in *userService.EmailChangeRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "RequestEmailChange")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.emailChangeService.Request(ctx, userId, in.NewEmail, in.RevokeSessions)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
+
+func (handler *UserHandler) ConfirmEmailChange(ctx context.Context, in *userService.EmailChangeTokenRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "ConfirmEmailChange")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	changeId, err := parseObjectId("changeId", in.ChangeId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.emailChangeService.Confirm(ctx, changeId, in.Token)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
+
+func (handler *UserHandler) RevertEmailChange(ctx context.Context, in *userService.EmailChangeTokenRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "RevertEmailChange")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	changeId, err := parseObjectId("changeId", in.ChangeId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.emailChangeService.Revert(ctx, changeId, in.Token)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+)
+
+func (handler *UserHandler) EndorseSkillRequest(ctx context.Context, in *userService.EndorsementRequest) (*userService.EndorsementResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "EndorseSkillRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	endorserId, err := parseObjectId("endorserId", in.EndorserId)
+	if err != nil {
+		return nil, err
+	}
+	endorsement, err := handler.endorsementService.Endorse(ctx, userId, in.Skill, endorserId)
+	if err != nil {
+		return nil, err
+	}
+	user, err := handler.service.Get(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	tagNames, err := handler.tagService.TagNames(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EndorsementResponse{Endorsement: mapEndorsement(endorsement, tagNames)}, nil
+}
+
+func (handler *UserHandler) RemoveEndorsementRequest(ctx context.Context, in *userService.EndorsementRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "RemoveEndorsementRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	endorserId, err := parseObjectId("endorserId", in.EndorserId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.endorsementService.Remove(ctx, userId, in.Skill, endorserId)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
+
+func (handler *UserHandler) GetEndorsementsRequest(ctx context.Context, in *userService.UserIdRequest) (*userService.EndorsementsResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "GetEndorsementsRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	user, err := handler.service.Get(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	endorsements, err := handler.endorsementService.GetByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	tagNames, err := handler.tagService.TagNames(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.EndorsementsResponse{
+		Endorsements: []*userService.Endorsement{},
+	}
+	for _, endorsement := range endorsements {
+		response.Endorsements = append(response.Endorsements, mapEndorsement(endorsement, tagNames))
+	}
+	return response, nil
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"user-microservice/application"
+)
+
+var errorCodes = map[application.ErrorKind]codes.Code{
+	application.NotFound:           codes.NotFound,
+	application.AlreadyExists:      codes.AlreadyExists,
+	application.InvalidArgument:    codes.InvalidArgument,
+	application.Unauthenticated:    codes.Unauthenticated,
+	application.PermissionDenied:   codes.PermissionDenied,
+	application.FailedPrecondition: codes.FailedPrecondition,
+	application.Aborted:            codes.Aborted,
+}
+
+// UnaryErrorInterceptor converts every error returned by a UserHandler method
+// into a gRPC status, so that clients never receive codes.Unknown.
+func UnaryErrorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	response, err := handler(ctx, req)
+	if err != nil {
+		return nil, toStatusError(info.FullMethod, err)
+	}
+	return response, nil
+}
+
+func StreamErrorInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, stream)
+	if err != nil {
+		return toStatusError(info.FullMethod, err)
+	}
+	return nil
+}
+
+func toStatusError(method string, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var domainError *application.DomainError
+	if errors.As(err, &domainError) {
+		st := status.New(errorCodes[domainError.Kind], domainError.Message)
+		if len(domainError.Violations) > 0 {
+			badRequest := &errdetails.BadRequest{}
+			for _, violation := range domainError.Violations {
+				badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+					Field:       violation.Field,
+					Description: violation.Description,
+				})
+			}
+			if detailed, detailsErr := st.WithDetails(badRequest); detailsErr == nil {
+				st = detailed
+			}
+		}
+		return st.Err()
+	}
+
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return status.Error(codes.NotFound, "not found")
+	case mongo.IsDuplicateKeyError(err):
+		return status.Error(codes.AlreadyExists, "already exists")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+
+	application.Log.Error("Unexpected error in " + method + ": " + err.Error())
+	return status.Error(codes.Internal, "internal server error")
+}
+
+// parseObjectId parses a hex id coming from a request and reports a malformed
+// value as a violation of the named field.
+func parseObjectId(field string, value string) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return primitive.NilObjectID, application.NewInvalidArgumentError("invalid "+field, application.FieldViolation{
+			Field:       field,
+			Description: "must be a 24 character hex object id",
+		})
+	}
+	return id, nil
+}
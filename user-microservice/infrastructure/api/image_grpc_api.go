@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"errors"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+	"io"
+	"user-microservice/application"
+	"user-microservice/model"
+)
+
+const imageChunkSize = 64 * 1024
+
+// UploadProfileImage receives an image as a stream of chunks. The first
+// message names the user and the kind of image; the stream is cut off as soon
+// as it grows past the upload limit.
+func (handler *UserHandler) UploadProfileImage(stream userService.UserService_UploadProfileImageServer) error {
+	span := tracer.StartSpanFromContextMetadata(stream.Context(), "UploadProfileImage")
+	defer span.Finish()
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	first, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return application.NewInvalidArgumentError("image is empty")
+	}
+	if err != nil {
+		return err
+	}
+	v := application.NewValidator()
+	validateImageRequest(v, first.UserId, first.Kind)
+	if err := v.Err(); err != nil {
+		return err
+	}
+	userId, err := parseObjectId("userId", first.UserId)
+	if err != nil {
+		return err
+	}
+
+	data := first.Chunk
+	for {
+		if int64(len(data)) > handler.imageService.MaxBytes() {
+			return application.NewInvalidArgumentError("image is too large")
+		}
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data = append(data, in.Chunk...)
+	}
+
+	user, err := handler.imageService.Upload(ctx, userId, model.ImageKind(first.Kind), data)
+	if err != nil {
+		return err
+	}
+	tagNames, err := handler.tagService.TagNames(ctx, user)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&userService.GetResponse{User: mapUser(user, tagNames)})
+}
+
+func (handler *UserHandler) DeleteProfileImageRequest(ctx context.Context, in *userService.ImageRequest) (*userService.GetResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "DeleteProfileImageRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	user, err := handler.imageService.Delete(ctx, userId, model.ImageKind(in.Kind))
+	if err != nil {
+		return nil, err
+	}
+	tagNames, err := handler.tagService.TagNames(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.GetResponse{User: mapUser(user, tagNames)}, nil
+}
+
+// GetProfileImage streams one size of an image back in chunks, the first of
+// which carries the content type.
+func (handler *UserHandler) GetProfileImage(in *userService.ImageRequest, stream userService.UserService_GetProfileImageServer) error {
+	span := tracer.StartSpanFromContextMetadata(stream.Context(), "GetProfileImage")
+	defer span.Finish()
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+
+	v := application.NewValidator()
+	validateImageRequest(v, in.UserId, in.Kind)
+	if err := v.Err(); err != nil {
+		return err
+	}
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return err
+	}
+	data, contentType, err := handler.imageService.Open(ctx, userId, model.ImageKind(in.Kind), in.Size)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(data); start += imageChunkSize {
+		end := start + imageChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := &userService.ImageChunk{Data: data[start:end]}
+		if start == 0 {
+			chunk.ContentType = contentType
+		}
+		if err := stream.Send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,13 +3,16 @@ package api
 import (
 	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"strconv"
+	"path"
 	"strings"
 	"time"
+	"user-microservice/application"
 	"user-microservice/model"
 )
 
-func mapUser(user *model.User) *userService.User {
+// mapUser shows skills and interests by the names found in tagNames, which is
+// keyed by tag id.
+func mapUser(user *model.User, tagNames map[string]string) *userService.User {
 	userPb := &userService.User{
 		Id:          user.Id.Hex(),
 		Name:        user.Name,
@@ -17,28 +20,43 @@ func mapUser(user *model.User) *userService.User {
 		Email:       user.Email,
 		PhoneNumber: user.PhoneNumber,
 		Gender:      int64(user.Gender),
-		BirthDate:   user.BirthDate.String(),
+		BirthDate:   formatDate(user.BirthDate),
 		Username:    user.Username,
 		Password:    "",
 		Bio:         user.Bio,
-		Skills:      user.Skills,
-		Interests:   user.Interests,
+		Skills:      mapTagNames(user.Skills, tagNames),
+		Interests:   mapTagNames(user.Interests, tagNames),
 		Private:     user.Private,
 		Role:        string(user.Role),
 		TFAEnabled:  user.TFAEnabled,
+		Version:     user.Version,
 	}
+	userPb.ProfilePicture = mapImage(user, model.PROFILE_PICTURE, user.ProfilePicture)
+	userPb.CoverImage = mapImage(user, model.COVER_IMAGE, user.CoverImage)
 	return userPb
 }
-func mapUserPb(userPb *userService.User) *model.User {
+
+func mapTagNames(ids []string, tagNames map[string]string) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if name, ok := tagNames[id]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, id)
+		}
+	}
+	return names
+}
+
+func mapUserPb(userPb *userService.User) (*model.User, error) {
 	id, _ := primitive.ObjectIDFromHex(userPb.Id)
-	t := time.Now()
+	var t time.Time
 	if userPb.BirthDate != "" {
-		dateString := strings.Split(userPb.BirthDate, "T")
-		date := strings.Split(dateString[0], "-")
-		year, _ := strconv.Atoi(date[0])
-		month, _ := strconv.Atoi(date[1])
-		day, _ := strconv.Atoi(date[2])
-		t = time.Date(year, time.Month(month), day, 12, 0, 0, 0, time.UTC)
+		birthDate, err := parseDate(userPb.BirthDate)
+		if err != nil {
+			return nil, invalidDate("user.birthDate")
+		}
+		t = birthDate
 	}
 	user := &model.User{
 		Id:          id,
@@ -56,7 +74,7 @@ func mapUserPb(userPb *userService.User) *model.User {
 		Private:     userPb.Private,
 		Role:        model.UserRole(userPb.Role),
 	}
-	return user
+	return user, nil
 }
 
 func mapExperience(experience *model.Experience) *userService.Experience {
@@ -65,42 +83,383 @@ func mapExperience(experience *model.Experience) *userService.Experience {
 		UserId:         experience.UserId,
 		Name:           experience.Name,
 		Title:          experience.Title,
-		ExperienceType: experience.ExperienceType,
-		StartDate:      experience.StartDate.String(),
-		EndDate:        experience.EndDate.String(),
+		ExperienceType: experience.ExperienceType == model.WORK,
+		Type:           string(experience.ExperienceType),
+		EmploymentType: string(experience.EmploymentType),
+		Location:       experience.Location,
+		Description:    experience.Description,
+		StartDate:      formatDate(experience.StartDate),
+		EndDate:        formatEndDate(experience.EndDate),
 	}
 	return experiencePb
 }
 
-func mapExperiencePb(experiencePb *userService.Experience) *model.Experience {
+func mapExperiencePb(experiencePb *userService.Experience) (*model.Experience, error) {
 	id, _ := primitive.ObjectIDFromHex(experiencePb.Id)
-	start := time.Now()
-	if experiencePb.StartDate != "" {
-		dateString := strings.Split(experiencePb.StartDate, "T")
-		date := strings.Split(dateString[0], "-")
-		year, _ := strconv.Atoi(date[0])
-		month, _ := strconv.Atoi(date[1])
-		day, _ := strconv.Atoi(date[2])
-		start = time.Date(year, time.Month(month), day, 12, 0, 0, 0, time.UTC)
-	}
-
-	end := time.Now()
-	if experiencePb.StartDate != "" {
-		dateString := strings.Split(experiencePb.StartDate, "T")
-		date := strings.Split(dateString[0], "-")
-		year, _ := strconv.Atoi(date[0])
-		month, _ := strconv.Atoi(date[1])
-		day, _ := strconv.Atoi(date[2])
-		end = time.Date(year, time.Month(month), day, 12, 0, 0, 0, time.UTC)
+	start, err := parseOptionalDate(experiencePb.StartDate)
+	if err != nil {
+		return nil, invalidDate("experience.startDate")
+	}
+	end, err := parseEndDate(experiencePb.EndDate)
+	if err != nil {
+		return nil, invalidDate("experience.endDate")
 	}
 	experience := &model.Experience{
 		Id:             id,
 		Name:           experiencePb.Name,
 		Title:          experiencePb.Title,
 		UserId:         experiencePb.UserId,
-		ExperienceType: experiencePb.ExperienceType,
+		ExperienceType: mapExperienceType(experiencePb),
+		EmploymentType: model.EmploymentType(experiencePb.EmploymentType),
+		Location:       experiencePb.Location,
+		Description:    experiencePb.Description,
 		StartDate:      start,
 		EndDate:        end,
 	}
-	return experience
+	return experience, nil
+}
+
+// mapExperienceType falls back to the legacy boolean for clients that do not
+// send the type name yet. It is the only place that defaults the type, the
+// same way stored documents with the boolean decode.
+func mapExperienceType(experiencePb *userService.Experience) model.ExperienceType {
+	if experiencePb.Type != "" {
+		return model.ExperienceType(experiencePb.Type)
+	}
+	if experiencePb.ExperienceType {
+		return model.WORK
+	}
+	return model.EDUCATION
+}
+
+var experienceMaskPaths = map[string]string{
+	"name":            application.ExperienceName,
+	"title":           application.ExperienceTitle,
+	"start_date":      application.ExperienceStartDate,
+	"end_date":        application.ExperienceEndDate,
+	"type":            application.ExperienceTypeField,
+	"experience_type": application.ExperienceTypeField,
+	"employment_type": application.ExperienceEmploymentType,
+	"location":        application.ExperienceLocation,
+	"description":     application.ExperienceDescription,
+}
+
+var userMaskPaths = map[string]string{
+	"name":         application.UserName,
+	"surname":      application.UserSurname,
+	"phone_number": application.UserPhoneNumber,
+	"gender":       application.UserGender,
+	"birth_date":   application.UserBirthDate,
+	"bio":          application.UserBio,
+	"email":        application.UserEmail,
+}
+
+var educationMaskPaths = map[string]string{
+	"institution":    application.EducationInstitution,
+	"degree":         application.EducationDegree,
+	"field_of_study": application.EducationFieldOfStudy,
+	"grade":          application.EducationGrade,
+	"description":    application.EducationDescription,
+	"start_date":     application.EducationStartDate,
+	"end_date":       application.EducationEndDate,
+}
+
+var certificationMaskPaths = map[string]string{
+	"name":            application.CertificationName,
+	"issuer":          application.CertificationIssuer,
+	"credential_id":   application.CertificationCredentialId,
+	"credential_url":  application.CertificationCredentialUrl,
+	"issue_date":      application.CertificationIssueDate,
+	"expiration_date": application.CertificationExpirationDate,
+}
+
+var languageMaskPaths = map[string]string{
+	"name":        application.LanguageName,
+	"proficiency": application.LanguageProficiency,
+}
+
+var projectMaskPaths = map[string]string{
+	"name":        application.ProjectName,
+	"description": application.ProjectDescription,
+	"links":       application.ProjectLinks,
+	"start_date":  application.ProjectStartDate,
+	"end_date":    application.ProjectEndDate,
+}
+
+// mapMask turns protobuf field mask paths into the field names used by the
+// application services. Paths outside the allow-list are passed through and
+// rejected there.
+func mapMask(paths []string, maskPaths map[string]string) []string {
+	fields := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if field, ok := maskPaths[path]; ok {
+			fields = append(fields, field)
+		} else {
+			fields = append(fields, path)
+		}
+	}
+	return fields
+}
+
+func mapKnownDevice(device *model.KnownDevice) *userService.KnownDevice {
+	devicePb := &userService.KnownDevice{
+		Id:          device.Id.Hex(),
+		UserId:      device.UserId,
+		Fingerprint: device.Fingerprint,
+		UserAgent:   device.UserAgent,
+		LastIp:      device.LastIp,
+		Networks:    device.Networks,
+		FirstSeen:   formatTimestamp(device.FirstSeen),
+		LastSeen:    formatTimestamp(device.LastSeen),
+	}
+	return devicePb
+}
+
+func mapEducation(education *model.Education) *userService.Education {
+	educationPb := &userService.Education{
+		Id:           education.Id.Hex(),
+		UserId:       education.UserId,
+		Institution:  education.Institution,
+		Degree:       education.Degree,
+		FieldOfStudy: education.FieldOfStudy,
+		Grade:        education.Grade,
+		Description:  education.Description,
+		StartDate:    formatDate(education.StartDate),
+		EndDate:      formatEndDate(education.EndDate),
+	}
+	return educationPb
+}
+
+func mapEducationPb(educationPb *userService.Education) (*model.Education, error) {
+	id, _ := primitive.ObjectIDFromHex(educationPb.Id)
+	start, err := parseOptionalDate(educationPb.StartDate)
+	if err != nil {
+		return nil, invalidDate("education.startDate")
+	}
+	end, err := parseEndDate(educationPb.EndDate)
+	if err != nil {
+		return nil, invalidDate("education.endDate")
+	}
+	education := &model.Education{
+		Id:           id,
+		UserId:       educationPb.UserId,
+		Institution:  educationPb.Institution,
+		Degree:       educationPb.Degree,
+		FieldOfStudy: educationPb.FieldOfStudy,
+		Grade:        educationPb.Grade,
+		Description:  educationPb.Description,
+		StartDate:    start,
+		EndDate:      end,
+	}
+	return education, nil
+}
+
+func mapCertification(certification *model.Certification) *userService.Certification {
+	certificationPb := &userService.Certification{
+		Id:             certification.Id.Hex(),
+		UserId:         certification.UserId,
+		Name:           certification.Name,
+		Issuer:         certification.Issuer,
+		CredentialId:   certification.CredentialId,
+		CredentialUrl:  certification.CredentialUrl,
+		IssueDate:      formatDate(certification.IssueDate),
+		ExpirationDate: formatDate(certification.ExpirationDate),
+	}
+	return certificationPb
+}
+
+func mapCertificationPb(certificationPb *userService.Certification) (*model.Certification, error) {
+	id, _ := primitive.ObjectIDFromHex(certificationPb.Id)
+	issued, err := parseOptionalDate(certificationPb.IssueDate)
+	if err != nil {
+		return nil, invalidDate("certification.issueDate")
+	}
+	expires, err := parseOptionalDate(certificationPb.ExpirationDate)
+	if err != nil {
+		return nil, invalidDate("certification.expirationDate")
+	}
+	certification := &model.Certification{
+		Id:             id,
+		UserId:         certificationPb.UserId,
+		Name:           certificationPb.Name,
+		Issuer:         certificationPb.Issuer,
+		CredentialId:   certificationPb.CredentialId,
+		CredentialUrl:  certificationPb.CredentialUrl,
+		IssueDate:      issued,
+		ExpirationDate: expires,
+	}
+	return certification, nil
+}
+
+func mapLanguage(language *model.Language) *userService.Language {
+	languagePb := &userService.Language{
+		Id:          language.Id.Hex(),
+		UserId:      language.UserId,
+		Name:        language.Name,
+		Proficiency: int64(language.Proficiency),
+	}
+	return languagePb
+}
+
+func mapLanguagePb(languagePb *userService.Language) *model.Language {
+	id, _ := primitive.ObjectIDFromHex(languagePb.Id)
+	language := &model.Language{
+		Id:          id,
+		UserId:      languagePb.UserId,
+		Name:        languagePb.Name,
+		Proficiency: model.LanguageProficiency(languagePb.Proficiency),
+	}
+	return language
+}
+
+func mapProject(project *model.Project) *userService.Project {
+	projectPb := &userService.Project{
+		Id:          project.Id.Hex(),
+		UserId:      project.UserId,
+		Name:        project.Name,
+		Description: project.Description,
+		Links:       project.Links,
+		StartDate:   formatDate(project.StartDate),
+		EndDate:     formatEndDate(project.EndDate),
+	}
+	return projectPb
+}
+
+func mapProjectPb(projectPb *userService.Project) (*model.Project, error) {
+	id, _ := primitive.ObjectIDFromHex(projectPb.Id)
+	start, err := parseOptionalDate(projectPb.StartDate)
+	if err != nil {
+		return nil, invalidDate("project.startDate")
+	}
+	end, err := parseEndDate(projectPb.EndDate)
+	if err != nil {
+		return nil, invalidDate("project.endDate")
+	}
+	project := &model.Project{
+		Id:          id,
+		UserId:      projectPb.UserId,
+		Name:        projectPb.Name,
+		Description: projectPb.Description,
+		Links:       projectPb.Links,
+		StartDate:   start,
+		EndDate:     end,
+	}
+	return project, nil
+}
+
+func mapTag(tag *model.Tag) *userService.Tag {
+	return &userService.Tag{
+		Id:       tag.Id.Hex(),
+		Kind:     string(tag.Kind),
+		Name:     tag.Name,
+		Aliases:  tag.Aliases,
+		Category: tag.Category,
+		Usage:    tag.Usage,
+	}
+}
+
+func mapTagPb(tagPb *userService.Tag) *model.Tag {
+	id, _ := primitive.ObjectIDFromHex(tagPb.Id)
+	return &model.Tag{
+		Id:       id,
+		Kind:     model.TagKind(tagPb.Kind),
+		Name:     tagPb.Name,
+		Aliases:  tagPb.Aliases,
+		Category: tagPb.Category,
+	}
+}
+
+func mapEndorsement(endorsement *model.Endorsement, tagNames map[string]string) *userService.Endorsement {
+	return &userService.Endorsement{
+		Id:         endorsement.Id.Hex(),
+		UserId:     endorsement.UserId,
+		SkillId:    endorsement.SkillId,
+		Skill:      mapTagNames([]string{endorsement.SkillId}, tagNames)[0],
+		EndorserId: endorsement.EndorserId,
+		Timestamp:  formatTimestamp(endorsement.Timestamp),
+	}
+}
+
+// mapEndorsementCounts lists every skill of the user in profile order, with
+// zero for skills nobody endorsed yet.
+func mapEndorsementCounts(user *model.User, counts map[string]int64, tagNames map[string]string) []*userService.SkillEndorsementCount {
+	endorsementCounts := []*userService.SkillEndorsementCount{}
+	for _, skillId := range user.Skills {
+		endorsementCounts = append(endorsementCounts, &userService.SkillEndorsementCount{
+			SkillId: skillId,
+			Skill:   mapTagNames([]string{skillId}, tagNames)[0],
+			Count:   counts[skillId],
+		})
+	}
+	return endorsementCounts
+}
+
+func mapProfileCompleteness(completeness *model.ProfileCompleteness) *userService.ProfileCompletenessResponse {
+	response := &userService.ProfileCompletenessResponse{
+		Percentage: completeness.Percentage,
+		Missing:    []*userService.MissingProfileItem{},
+	}
+	for _, item := range completeness.Missing {
+		response.Missing = append(response.Missing, &userService.MissingProfileItem{
+			Item:       string(item.Item),
+			Weight:     item.Weight,
+			Suggestion: item.Suggestion,
+		})
+	}
+	return response
+}
+
+// mapImage links every size served for the kind. The upload id in the query
+// changes with each upload so that cached copies of a replaced image are not
+// reused.
+func mapImage(user *model.User, kind model.ImageKind, image *model.Image) *userService.Image {
+	if image == nil {
+		return nil
+	}
+	base := "/users/" + user.Id.Hex() + "/images/" + strings.ToLower(string(kind)) + "/"
+	version := "?v=" + path.Base(image.Key)
+	urls := map[string]string{}
+	for _, size := range application.ImageSizes(kind) {
+		urls[size] = base + size + version
+	}
+	return &userService.Image{
+		Width:  image.Width,
+		Height: image.Height,
+		Urls:   urls,
+	}
+}
+
+// mapFacetCounts shows each value under its name in names, if it has one.
+func mapFacetCounts(counts []*model.FacetCount, names map[string]string) []*userService.FacetCount {
+	facets := make([]*userService.FacetCount, 0, len(counts))
+	for _, count := range counts {
+		value := count.Value
+		if name, ok := names[value]; ok {
+			value = name
+		}
+		facets = append(facets, &userService.FacetCount{Value: value, Count: count.Count})
+	}
+	return facets
+}
+
+func mapUserDeletion(deletion *model.UserDeletion) *userService.DeletionStatusResponse {
+	response := &userService.DeletionStatusResponse{
+		UserId:    deletion.UserId,
+		State:     string(deletion.State),
+		StartedAt: formatTimestamp(deletion.StartedAt),
+		Steps:     make([]*userService.DeletionStep, 0, len(deletion.Steps)),
+	}
+	if deletion.CompletedAt != nil {
+		response.CompletedAt = formatTimestamp(*deletion.CompletedAt)
+	}
+	for _, step := range deletion.Steps {
+		response.Steps = append(response.Steps, &userService.DeletionStep{
+			Name:      string(step.Name),
+			State:     string(step.State),
+			Attempts:  step.Attempts,
+			LastError: step.LastError,
+			UpdatedAt: formatTimestamp(step.UpdatedAt),
+		})
+	}
+	return response
 }
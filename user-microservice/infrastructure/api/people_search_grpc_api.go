@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+	"user-microservice/application"
+	"user-microservice/model"
+)
+
+func (handler *UserHandler) SearchPeopleRequest(ctx context.Context, in *userService.PeopleSearchRequest) (*userService.PeopleSearchResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "SearchPeopleRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	search := &application.PeopleSearch{
+		Skills:       in.Skills,
+		AllSkills:    in.MatchAllSkills,
+		Interests:    in.Interests,
+		AllInterests: in.MatchAllInterests,
+		Company:      in.Company,
+		Title:        in.Title,
+		Location:     in.Location,
+		Position:     model.PositionFilter(in.Position),
+	}
+	result, nextPageToken, err := handler.peopleSearchService.Search(ctx, in.UserId, search, in.PageToken, in.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	tagNames, err := handler.tagService.TagNames(ctx, result.Users...)
+	if err != nil {
+		return nil, err
+	}
+	skillNames, err := handler.tagService.Names(ctx, facetValues(result.Skills))
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.PeopleSearchResponse{
+		Users:         []*userService.User{},
+		NextPageToken: nextPageToken,
+		Total:         result.Total,
+		SkillFacets:   mapFacetCounts(result.Skills, skillNames),
+		CompanyFacets: mapFacetCounts(result.Companies, nil),
+	}
+	for _, user := range result.Users {
+		response.Users = append(response.Users, mapUser(user, tagNames))
+	}
+	return response, nil
+}
+
+func facetValues(counts []*model.FacetCount) []string {
+	values := make([]string, 0, len(counts))
+	for _, count := range counts {
+		values = append(values, count.Value)
+	}
+	return values
+}
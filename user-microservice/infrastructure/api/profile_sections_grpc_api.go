@@ -0,0 +1,345 @@
+package api
+
+import (
+	"context"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+)
+
+func (handler *UserHandler) GetUserEducationsRequest(ctx context.Context, in *userService.UserIdRequest) (*userService.EducationsResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "GetUserEducationsRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	educations, err := handler.educationService.GetByUserId(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.EducationsResponse{
+		Educations: []*userService.Education{},
+	}
+	for _, education := range educations {
+		response.Educations = append(response.Educations, mapEducation(education))
+	}
+	return response, nil
+}
+
+func (handler *UserHandler) PostEducationRequest(ctx context.Context, in *userService.EducationRequest) (*userService.EducationResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "PostEducationRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	educationFromRequest, err := mapEducationPb(in.Education)
+	if err != nil {
+		return nil, err
+	}
+	educationFromRequest.UserId = userId.Hex()
+
+	education, err := handler.educationService.Create(ctx, educationFromRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EducationResponse{Education: mapEducation(education)}, nil
+}
+
+func (handler *UserHandler) UpdateEducationRequest(ctx context.Context, in *userService.EducationRequest) (*userService.EducationResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "UpdateEducationRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	educationId, err := parseObjectId("education.id", in.Education.Id)
+	if err != nil {
+		return nil, err
+	}
+	educationFromRequest, err := mapEducationPb(in.Education)
+	if err != nil {
+		return nil, err
+	}
+	educationFromRequest.Id = educationId
+
+	education, err := handler.educationService.Update(ctx, userId, educationFromRequest, mapMask(in.UpdateMask.GetPaths(), educationMaskPaths))
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EducationResponse{Education: mapEducation(education)}, nil
+}
+
+func (handler *UserHandler) DeleteEducationRequest(ctx context.Context, in *userService.DeleteUsersEducationRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "DeleteEducationRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	educationId, err := parseObjectId("educationId", in.EducationId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.educationService.Delete(ctx, userId, educationId)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
+
+func (handler *UserHandler) GetUserCertificationsRequest(ctx context.Context, in *userService.UserIdRequest) (*userService.CertificationsResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "GetUserCertificationsRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	certifications, err := handler.certificationService.GetByUserId(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.CertificationsResponse{
+		Certifications: []*userService.Certification{},
+	}
+	for _, certification := range certifications {
+		response.Certifications = append(response.Certifications, mapCertification(certification))
+	}
+	return response, nil
+}
+
+func (handler *UserHandler) PostCertificationRequest(ctx context.Context, in *userService.CertificationRequest) (*userService.CertificationResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "PostCertificationRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	certificationFromRequest, err := mapCertificationPb(in.Certification)
+	if err != nil {
+		return nil, err
+	}
+	certificationFromRequest.UserId = userId.Hex()
+
+	certification, err := handler.certificationService.Create(ctx, certificationFromRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.CertificationResponse{Certification: mapCertification(certification)}, nil
+}
+
+func (handler *UserHandler) UpdateCertificationRequest(ctx context.Context, in *userService.CertificationRequest) (*userService.CertificationResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "UpdateCertificationRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	certificationId, err := parseObjectId("certification.id", in.Certification.Id)
+	if err != nil {
+		return nil, err
+	}
+	certificationFromRequest, err := mapCertificationPb(in.Certification)
+	if err != nil {
+		return nil, err
+	}
+	certificationFromRequest.Id = certificationId
+
+	certification, err := handler.certificationService.Update(ctx, userId, certificationFromRequest, mapMask(in.UpdateMask.GetPaths(), certificationMaskPaths))
+	if err != nil {
+		return nil, err
+	}
+	return &userService.CertificationResponse{Certification: mapCertification(certification)}, nil
+}
+
+func (handler *UserHandler) DeleteCertificationRequest(ctx context.Context, in *userService.DeleteUsersCertificationRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "DeleteCertificationRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	certificationId, err := parseObjectId("certificationId", in.CertificationId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.certificationService.Delete(ctx, userId, certificationId)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
+
+func (handler *UserHandler) GetUserLanguagesRequest(ctx context.Context, in *userService.UserIdRequest) (*userService.LanguagesResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "GetUserLanguagesRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	languages, err := handler.languageService.GetByUserId(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.LanguagesResponse{
+		Languages: []*userService.Language{},
+	}
+	for _, language := range languages {
+		response.Languages = append(response.Languages, mapLanguage(language))
+	}
+	return response, nil
+}
+
+func (handler *UserHandler) PostLanguageRequest(ctx context.Context, in *userService.LanguageRequest) (*userService.LanguageResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "PostLanguageRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	languageFromRequest := mapLanguagePb(in.Language)
+	languageFromRequest.UserId = userId.Hex()
+
+	language, err := handler.languageService.Create(ctx, languageFromRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.LanguageResponse{Language: mapLanguage(language)}, nil
+}
+
+func (handler *UserHandler) UpdateLanguageRequest(ctx context.Context, in *userService.LanguageRequest) (*userService.LanguageResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "UpdateLanguageRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	languageId, err := parseObjectId("language.id", in.Language.Id)
+	if err != nil {
+		return nil, err
+	}
+	languageFromRequest := mapLanguagePb(in.Language)
+	languageFromRequest.Id = languageId
+
+	language, err := handler.languageService.Update(ctx, userId, languageFromRequest, mapMask(in.UpdateMask.GetPaths(), languageMaskPaths))
+	if err != nil {
+		return nil, err
+	}
+	return &userService.LanguageResponse{Language: mapLanguage(language)}, nil
+}
+
+func (handler *UserHandler) DeleteLanguageRequest(ctx context.Context, in *userService.DeleteUsersLanguageRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "DeleteLanguageRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	languageId, err := parseObjectId("languageId", in.LanguageId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.languageService.Delete(ctx, userId, languageId)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
+
+func (handler *UserHandler) GetUserProjectsRequest(ctx context.Context, in *userService.UserIdRequest) (*userService.ProjectsResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "GetUserProjectsRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	projects, err := handler.projectService.GetByUserId(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.ProjectsResponse{
+		Projects: []*userService.Project{},
+	}
+	for _, project := range projects {
+		response.Projects = append(response.Projects, mapProject(project))
+	}
+	return response, nil
+}
+
+func (handler *UserHandler) PostProjectRequest(ctx context.Context, in *userService.ProjectRequest) (*userService.ProjectResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "PostProjectRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	projectFromRequest, err := mapProjectPb(in.Project)
+	if err != nil {
+		return nil, err
+	}
+	projectFromRequest.UserId = userId.Hex()
+
+	project, err := handler.projectService.Create(ctx, projectFromRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.ProjectResponse{Project: mapProject(project)}, nil
+}
+
+func (handler *UserHandler) UpdateProjectRequest(ctx context.Context, in *userService.ProjectRequest) (*userService.ProjectResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "UpdateProjectRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	projectId, err := parseObjectId("project.id", in.Project.Id)
+	if err != nil {
+		return nil, err
+	}
+	projectFromRequest, err := mapProjectPb(in.Project)
+	if err != nil {
+		return nil, err
+	}
+	projectFromRequest.Id = projectId
+
+	project, err := handler.projectService.Update(ctx, userId, projectFromRequest, mapMask(in.UpdateMask.GetPaths(), projectMaskPaths))
+	if err != nil {
+		return nil, err
+	}
+	return &userService.ProjectResponse{Project: mapProject(project)}, nil
+}
+
+func (handler *UserHandler) DeleteProjectRequest(ctx context.Context, in *userService.DeleteUsersProjectRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "DeleteProjectRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	projectId, err := parseObjectId("projectId", in.ProjectId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.projectService.Delete(ctx, userId, projectId)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
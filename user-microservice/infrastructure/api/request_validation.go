@@ -0,0 +1,477 @@
+package api
+
+import (
+	"context"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"golang.org/x/exp/slices"
+	"google.golang.org/grpc"
+	"path"
+	"strconv"
+	"time"
+	"user-microservice/application"
+	"user-microservice/model"
+)
+
+type requestValidator func(v *application.Validator, req interface{})
+
+// requestValidators holds the input checks for every UserHandler method,
+// keyed by the gRPC method name.
+var requestValidators = map[string]requestValidator{
+	"GetRequest":               validateUserIdRequest,
+	"DeleteRequest":            validateUserIdRequest,
+	"GetDeletionStatusRequest": validateUserIdRequest,
+	"GetQR2FA":                 validateUserIdRequest,
+	"Disable2FA":               validateUserIdRequest,
+	"IsUserPrivateRequest":     validateUserIdRequest,
+	"ApiTokenRequest":          validateUserIdRequest,
+	"ApiTokenCreateRequest":    validateUserIdRequest,
+	"ApiTokenRemoveRequest":    validateUserIdRequest,
+	"ChangeProfilePrivacy":     validateUserIdRequest,
+	"SetProfilePrivacyRequest": validateProfilePrivacyRequest,
+	"GetKnownDevicesRequest":   validateUserIdRequest,
+	"GetEndorsementsRequest":   validateUserIdRequest,
+	"GetProfileCompleteness":   validateUserIdRequest,
+	"EndorseSkillRequest":      validateEndorsementRequest,
+	"RemoveEndorsementRequest": validateEndorsementRequest,
+	"PostRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.UserRequest)
+		validateNewUser(v, in.User)
+	},
+	"PostAdminRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.UserRequest)
+		validateNewUser(v, in.User)
+	},
+	"UpdateRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.UserRequest)
+		v.ObjectId("userId", in.UserId)
+		if in.User == nil {
+			v.AddViolation("user", "is required")
+		}
+	},
+	"LoginRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.CredentialsRequest)
+		if in.Credentials == nil {
+			v.AddViolation("credentials", "is required")
+			return
+		}
+		v.Required("credentials.username", in.Credentials.Username)
+		v.Required("credentials.password", in.Credentials.Password)
+	},
+	"ConfirmRegistration": func(v *application.Validator, req interface{}) {
+		v.Required("confirmationId", req.(*userService.ConfirmationRequest).ConfirmationId)
+	},
+	"Enable2FA": validateTFARequest,
+	"Verify2FA": validateTFARequest,
+	"SearchUsersRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.SearchRequest)
+		v.ObjectId("userId", in.UserId)
+		v.Length("searchParam", in.SearchParam, 0, 100)
+		if in.PageSize < 0 || in.PageSize > application.MaxPageSize {
+			v.AddViolation("pageSize", "must be between 0 and "+strconv.Itoa(application.MaxPageSize))
+		}
+	},
+	"ListUsersRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.ListUsersRequest)
+		if in.PageSize < 0 || in.PageSize > application.MaxPageSize {
+			v.AddViolation("pageSize", "must be between 0 and "+strconv.Itoa(application.MaxPageSize))
+		}
+		if in.SortBy != "" && !slices.Contains(model.UserSorts, model.UserSort(in.SortBy)) {
+			v.AddViolation("sortBy", "must be one of CREATED, USERNAME, NAME")
+		}
+		if in.Role != "" && in.Role != string(model.ADMIN) && in.Role != model.USER {
+			v.AddViolation("role", "must be one of ADMIN, USER")
+		}
+		v.Length("skill", in.Skill, 0, application.MaxTagLength)
+	},
+	"SearchPeopleRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.PeopleSearchRequest)
+		v.ObjectId("userId", in.UserId)
+		for _, skill := range in.Skills {
+			v.Length("skills", skill, 1, application.MaxTagLength)
+		}
+		for _, interest := range in.Interests {
+			v.Length("interests", interest, 1, application.MaxTagLength)
+		}
+		v.Length("company", in.Company, 0, 100)
+		v.Length("title", in.Title, 0, 100)
+		v.Length("location", in.Location, 0, 100)
+		if !slices.Contains(model.PositionFilters, model.PositionFilter(in.Position)) {
+			v.AddViolation("position", "must be empty or one of CURRENT, PAST")
+		}
+		if in.PageSize < 0 || in.PageSize > application.MaxPageSize {
+			v.AddViolation("pageSize", "must be between 0 and "+strconv.Itoa(application.MaxPageSize))
+		}
+	},
+	"UpdatePasswordRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.NewPasswordRequest)
+		if in.NewPassword == nil {
+			v.AddViolation("newPassword", "is required")
+			return
+		}
+		v.ObjectId("newPassword.userId", in.NewPassword.UserId)
+		v.Required("newPassword.password", in.NewPassword.Password)
+		v.Matches("newPassword.confirmNewPassword", in.NewPassword.ConfirmNewPassword, in.NewPassword.Password)
+	},
+	"ChangeUsernameRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.NewUsernameRequest)
+		if in.NewUsername == nil {
+			v.AddViolation("newUsername", "is required")
+			return
+		}
+		v.ObjectId("newUsername.userId", in.NewUsername.UserId)
+		v.Username("newUsername.username", in.NewUsername.Username)
+	},
+	"PostExperienceRequest": func(v *application.Validator, req interface{}) {
+		validateExperience(v, req.(*userService.NewExperienceRequest).Experience)
+	},
+	"GetAllUsersExperienceRequest": func(v *application.Validator, req interface{}) {
+		v.ObjectId("userId", req.(*userService.ExperienceRequest).UserId)
+	},
+	"DeleteExperienceRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.DeleteUsersExperienceRequest)
+		v.ObjectId("userId", in.UserId)
+		v.ObjectId("experienceId", in.ExperienceId)
+	},
+	"UpdateExperienceRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.UpdateExperienceRequest)
+		v.ObjectId("userId", in.UserId)
+		if in.Experience == nil {
+			v.AddViolation("experience", "is required")
+			return
+		}
+		v.ObjectId("experience.id", in.Experience.Id)
+	},
+	"GetUserEducationsRequest": validateUserIdRequest,
+	"PostEducationRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.EducationRequest)
+		v.ObjectId("userId", in.UserId)
+		if in.Education == nil {
+			v.AddViolation("education", "is required")
+		}
+	},
+	"UpdateEducationRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.EducationRequest)
+		v.ObjectId("userId", in.UserId)
+		if in.Education == nil {
+			v.AddViolation("education", "is required")
+			return
+		}
+		v.ObjectId("education.id", in.Education.Id)
+	},
+	"DeleteEducationRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.DeleteUsersEducationRequest)
+		v.ObjectId("userId", in.UserId)
+		v.ObjectId("educationId", in.EducationId)
+	},
+	"GetUserCertificationsRequest": validateUserIdRequest,
+	"PostCertificationRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.CertificationRequest)
+		v.ObjectId("userId", in.UserId)
+		if in.Certification == nil {
+			v.AddViolation("certification", "is required")
+		}
+	},
+	"UpdateCertificationRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.CertificationRequest)
+		v.ObjectId("userId", in.UserId)
+		if in.Certification == nil {
+			v.AddViolation("certification", "is required")
+			return
+		}
+		v.ObjectId("certification.id", in.Certification.Id)
+	},
+	"DeleteCertificationRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.DeleteUsersCertificationRequest)
+		v.ObjectId("userId", in.UserId)
+		v.ObjectId("certificationId", in.CertificationId)
+	},
+	"GetUserLanguagesRequest": validateUserIdRequest,
+	"PostLanguageRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.LanguageRequest)
+		v.ObjectId("userId", in.UserId)
+		if in.Language == nil {
+			v.AddViolation("language", "is required")
+		}
+	},
+	"UpdateLanguageRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.LanguageRequest)
+		v.ObjectId("userId", in.UserId)
+		if in.Language == nil {
+			v.AddViolation("language", "is required")
+			return
+		}
+		v.ObjectId("language.id", in.Language.Id)
+	},
+	"DeleteLanguageRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.DeleteUsersLanguageRequest)
+		v.ObjectId("userId", in.UserId)
+		v.ObjectId("languageId", in.LanguageId)
+	},
+	"GetUserProjectsRequest": validateUserIdRequest,
+	"PostProjectRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.ProjectRequest)
+		v.ObjectId("userId", in.UserId)
+		if in.Project == nil {
+			v.AddViolation("project", "is required")
+		}
+	},
+	"UpdateProjectRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.ProjectRequest)
+		v.ObjectId("userId", in.UserId)
+		if in.Project == nil {
+			v.AddViolation("project", "is required")
+			return
+		}
+		v.ObjectId("project.id", in.Project.Id)
+	},
+	"DeleteProjectRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.DeleteUsersProjectRequest)
+		v.ObjectId("userId", in.UserId)
+		v.ObjectId("projectId", in.ProjectId)
+	},
+	"AddUserSkill": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.NewSkillRequest)
+		if in.NewSkill == nil {
+			v.AddViolation("newSkill", "is required")
+			return
+		}
+		v.ObjectId("newSkill.userId", in.NewSkill.UserId)
+		v.Tag("newSkill.skill", in.NewSkill.Skill)
+	},
+	"AddUserInterest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.NewInterestRequest)
+		if in.NewInterest == nil {
+			v.AddViolation("newInterest", "is required")
+			return
+		}
+		v.ObjectId("newInterest.userId", in.NewInterest.UserId)
+		v.Tag("newInterest.interest", in.NewInterest.Interest)
+	},
+	"RemoveSkill": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.RemoveSkillRequest)
+		v.ObjectId("userId", in.UserId)
+		v.Required("skill", in.Skill)
+	},
+	"RemoveInterest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.RemoveInterestRequest)
+		v.ObjectId("userId", in.UserId)
+		v.Required("interest", in.Interest)
+	},
+	"CreatePasswordRecoveryRequest": validateUsernameRequest,
+	"PasswordlessLoginStart":        validateUsernameRequest,
+	"ResolveUsername":               validateUsernameRequest,
+	"PasswordRecoveryRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.NewPasswordRecoveryRequest)
+		v.ObjectId("recoveryId", in.RecoveryId)
+		if in.PasswordRecovery == nil {
+			v.AddViolation("passwordRecovery", "is required")
+			return
+		}
+		v.Required("passwordRecovery.newPassword", in.PasswordRecovery.NewPassword)
+		v.Matches("passwordRecovery.confirmPassword", in.PasswordRecovery.ConfirmPassword, in.PasswordRecovery.NewPassword)
+	},
+	"PasswordlessLogin": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.PasswordlessLoginRequest)
+		v.ObjectId("userId", in.UserId)
+		v.ObjectId("loginId", in.LoginId)
+	},
+	"RemoveKnownDeviceRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.KnownDeviceRequest)
+		v.ObjectId("userId", in.UserId)
+		v.ObjectId("deviceId", in.DeviceId)
+	},
+	"ReportUnrecognizedLogin": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.UnrecognizedLoginRequest)
+		v.ObjectId("userId", in.UserId)
+		v.ObjectId("loginId", in.LoginId)
+	},
+	"AutocompleteTagsRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.AutocompleteTagsRequest)
+		validateTagKind(v, "kind", in.Kind)
+		v.Length("prefix", in.Prefix, 0, application.MaxTagLength)
+		if in.Limit < 0 {
+			v.AddViolation("limit", "must not be negative")
+		}
+	},
+	"PostTagRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.TagRequest)
+		if in.Tag == nil {
+			v.AddViolation("tag", "is required")
+			return
+		}
+		validateTagKind(v, "tag.kind", in.Tag.Kind)
+		v.Tag("tag.name", in.Tag.Name)
+	},
+	"UpdateTagRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.TagRequest)
+		if in.Tag == nil {
+			v.AddViolation("tag", "is required")
+			return
+		}
+		v.ObjectId("tag.id", in.Tag.Id)
+		v.Tag("tag.name", in.Tag.Name)
+	},
+	"AddUserTagsRequest":     validateUserTagsRequest,
+	"RemoveUserTagsRequest":  validateUserTagsRequest,
+	"ReorderUserTagsRequest": validateUserTagsRequest,
+	"RequestEmailChange": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.EmailChangeRequest)
+		v.ObjectId("userId", in.UserId)
+		v.Email("newEmail", in.NewEmail)
+	},
+	"ConfirmEmailChange": validateEmailChangeTokenRequest,
+	"RevertEmailChange":  validateEmailChangeTokenRequest,
+	"DeleteProfileImageRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.ImageRequest)
+		validateImageRequest(v, in.UserId, in.Kind)
+	},
+	"MergeTagsRequest": func(v *application.Validator, req interface{}) {
+		in := req.(*userService.MergeTagsRequest)
+		v.ObjectId("sourceId", in.SourceId)
+		v.ObjectId("targetId", in.TargetId)
+	},
+}
+
+// UnaryValidationInterceptor rejects a request with every field violation
+// found before it reaches the handler.
+func UnaryValidationInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	validate, ok := requestValidators[path.Base(info.FullMethod)]
+	if ok {
+		v := application.NewValidator()
+		validate(v, req)
+		if err := v.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+func validateUserIdRequest(v *application.Validator, req interface{}) {
+	v.ObjectId("userId", req.(*userService.UserIdRequest).UserId)
+}
+
+func validateProfilePrivacyRequest(v *application.Validator, req interface{}) {
+	v.ObjectId("userId", req.(*userService.ProfilePrivacyRequest).UserId)
+}
+
+func validateUsernameRequest(v *application.Validator, req interface{}) {
+	v.Required("username", req.(*userService.UsernameRequest).Username)
+}
+
+func validateTFARequest(v *application.Validator, req interface{}) {
+	in := req.(*userService.TFARequest)
+	if in.Tfa == nil {
+		v.AddViolation("tfa", "is required")
+		return
+	}
+	v.ObjectId("tfa.userId", in.Tfa.UserId)
+	v.Required("tfa.code", in.Tfa.Code)
+}
+
+func validateNewUser(v *application.Validator, user *userService.User) {
+	if user == nil {
+		v.AddViolation("user", "is required")
+		return
+	}
+	validateUser(v, user)
+	if v.Required("user.password", user.Password) {
+		v.Matches("user.confirmPassword", user.ConfirmPassword, user.Password)
+	}
+}
+
+func validateUser(v *application.Validator, user *userService.User) {
+	if user == nil {
+		v.AddViolation("user", "is required")
+		return
+	}
+	if v.Required("user.name", user.Name) {
+		v.Length("user.name", user.Name, 1, application.MaxNameLength)
+	}
+	if v.Required("user.surname", user.Surname) {
+		v.Length("user.surname", user.Surname, 1, application.MaxNameLength)
+	}
+	v.Email("user.email", user.Email)
+	v.PhoneNumber("user.phoneNumber", user.PhoneNumber)
+	v.Username("user.username", user.Username)
+	v.Length("user.bio", user.Bio, 0, application.MaxBioLength)
+	if user.Gender != int64(model.MALE) && user.Gender != int64(model.FEMALE) {
+		v.AddViolation("user.gender", "is not a known gender")
+	}
+	if v.Required("user.birthDate", user.BirthDate) {
+		birthDate, err := parseDate(user.BirthDate)
+		if err != nil {
+			v.AddViolation("user.birthDate", "must be an ISO-8601 date or an RFC 3339 timestamp")
+		} else {
+			v.BirthDate("user.birthDate", birthDate)
+		}
+	}
+	for _, skill := range user.Skills {
+		v.Tag("user.skills", skill)
+	}
+	for _, interest := range user.Interests {
+		v.Tag("user.interests", interest)
+	}
+}
+
+func validateEndorsementRequest(v *application.Validator, req interface{}) {
+	in := req.(*userService.EndorsementRequest)
+	v.ObjectId("userId", in.UserId)
+	v.ObjectId("endorserId", in.EndorserId)
+	v.Tag("skill", in.Skill)
+}
+
+func validateUserTagsRequest(v *application.Validator, req interface{}) {
+	in := req.(*userService.UserTagsRequest)
+	v.ObjectId("userId", in.UserId)
+	validateTagKind(v, "kind", in.Kind)
+	for _, value := range in.Values {
+		v.Tag("values", value)
+	}
+}
+
+func validateEmailChangeTokenRequest(v *application.Validator, req interface{}) {
+	in := req.(*userService.EmailChangeTokenRequest)
+	v.ObjectId("changeId", in.ChangeId)
+	v.Required("token", in.Token)
+}
+
+// validateImageRequest is also called by the streaming image handlers, which
+// the unary interceptor does not reach.
+func validateImageRequest(v *application.Validator, userId string, kind string) {
+	v.ObjectId("userId", userId)
+	if !slices.Contains(model.ImageKinds, model.ImageKind(kind)) {
+		v.AddViolation("kind", "must be one of PROFILE_PICTURE, COVER_IMAGE")
+	}
+}
+
+func validateTagKind(v *application.Validator, field string, kind string) {
+	if !slices.Contains(model.TagKinds, model.TagKind(kind)) {
+		v.AddViolation(field, "must be one of SKILL, INTEREST")
+	}
+}
+
+func validateExperience(v *application.Validator, experience *userService.Experience) {
+	if experience == nil {
+		v.AddViolation("experience", "is required")
+		return
+	}
+	v.ObjectId("experience.userId", experience.UserId)
+	v.Required("experience.name", experience.Name)
+	v.Required("experience.title", experience.Title)
+
+	var start, end time.Time
+	var err error
+	if v.Required("experience.startDate", experience.StartDate) {
+		start, err = parseDate(experience.StartDate)
+		if err != nil {
+			v.AddViolation("experience.startDate", "must be an ISO-8601 date or an RFC 3339 timestamp")
+		}
+	}
+	end, err = parseEndDate(experience.EndDate)
+	if err != nil {
+		v.AddViolation("experience.endDate", "must be an ISO-8601 date, an RFC 3339 timestamp or \"present\"")
+	}
+	if !start.IsZero() {
+		v.DateOrder("experience.endDate", start, end)
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+	"user-microservice/model"
+)
+
+func (handler *UserHandler) AutocompleteTagsRequest(ctx context.Context, in *userService.AutocompleteTagsRequest) (*userService.TagsResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "AutocompleteTagsRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	tags, err := handler.tagService.Autocomplete(ctx, model.TagKind(in.Kind), in.Prefix, in.Limit)
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.TagsResponse{
+		Tags: []*userService.Tag{},
+	}
+	for _, tag := range tags {
+		response.Tags = append(response.Tags, mapTag(tag))
+	}
+	return response, nil
+}
+
+func (handler *UserHandler) PostTagRequest(ctx context.Context, in *userService.TagRequest) (*userService.TagResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "PostTagRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	tag, err := handler.tagService.Create(ctx, mapTagPb(in.Tag))
+	if err != nil {
+		return nil, err
+	}
+	return &userService.TagResponse{Tag: mapTag(tag)}, nil
+}
+
+func (handler *UserHandler) UpdateTagRequest(ctx context.Context, in *userService.TagRequest) (*userService.TagResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "UpdateTagRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	tag, err := handler.tagService.Update(ctx, mapTagPb(in.Tag))
+	if err != nil {
+		return nil, err
+	}
+	return &userService.TagResponse{Tag: mapTag(tag)}, nil
+}
+
+func (handler *UserHandler) MergeTagsRequest(ctx context.Context, in *userService.MergeTagsRequest) (*userService.TagResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "MergeTagsRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	sourceId, err := parseObjectId("sourceId", in.SourceId)
+	if err != nil {
+		return nil, err
+	}
+	targetId, err := parseObjectId("targetId", in.TargetId)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := handler.tagService.Merge(ctx, sourceId, targetId)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.TagResponse{Tag: mapTag(tag)}, nil
+}
+
+func (handler *UserHandler) AddUserTagsRequest(ctx context.Context, in *userService.UserTagsRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "AddUserTagsRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = handler.tagService.AddUserTags(ctx, userId, model.TagKind(in.Kind), in.Values)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
+
+func (handler *UserHandler) RemoveUserTagsRequest(ctx context.Context, in *userService.UserTagsRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "RemoveUserTagsRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = handler.tagService.RemoveUserTags(ctx, userId, model.TagKind(in.Kind), in.Values)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
+
+func (handler *UserHandler) ReorderUserTagsRequest(ctx context.Context, in *userService.UserTagsRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "ReorderUserTagsRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.tagService.ReorderUserTags(ctx, userId, model.TagKind(in.Kind), in.Values)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"context"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+)
+
+func (handler *UserHandler) GetDeletionStatusRequest(ctx context.Context, in *userService.UserIdRequest) (*userService.DeletionStatusResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "GetDeletionStatusRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	id, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	deletion, err := handler.deletionService.Status(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return mapUserDeletion(deletion), nil
+}
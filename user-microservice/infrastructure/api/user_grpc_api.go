@@ -2,31 +2,74 @@ package api
 
 import (
 	"context"
-	"errors"
 	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
 	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/security"
 	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"golang.org/x/exp/slices"
+	"net"
 	"user-microservice/application"
 	"user-microservice/model"
 )
 
 type UserHandler struct {
 	userService.UnimplementedUserServiceServer
-	service           *application.UserService
-	authService       *application.AuthService
-	experienceService *application.ExperienceService
+	service              *application.UserService
+	authService          *application.AuthService
+	experienceService    *application.ExperienceService
+	educationService     *application.EducationService
+	certificationService *application.CertificationService
+	languageService      *application.LanguageService
+	projectService       *application.ProjectService
+	deviceService        *application.DeviceService
+	tagService           *application.TagService
+	endorsementService   *application.EndorsementService
+	completenessService  *application.CompletenessService
+	imageService         *application.ImageService
+	usernameService      *application.UsernameService
+	emailChangeService   *application.EmailChangeService
+	peopleSearchService  *application.PeopleSearchService
+	deletionService      *application.DeletionService
+	privacyService       *application.PrivacyService
+	trustedProxies       []*net.IPNet
 }
 
 func NewUserHandler(
 	service *application.UserService,
 	authService *application.AuthService,
-	experienceService *application.ExperienceService) *UserHandler {
+	experienceService *application.ExperienceService,
+	educationService *application.EducationService,
+	certificationService *application.CertificationService,
+	languageService *application.LanguageService,
+	projectService *application.ProjectService,
+	deviceService *application.DeviceService,
+	tagService *application.TagService,
+	endorsementService *application.EndorsementService,
+	completenessService *application.CompletenessService,
+	imageService *application.ImageService,
+	usernameService *application.UsernameService,
+	emailChangeService *application.EmailChangeService,
+	peopleSearchService *application.PeopleSearchService,
+	deletionService *application.DeletionService,
+	privacyService *application.PrivacyService,
+	trustedProxies []*net.IPNet) *UserHandler {
 	return &UserHandler{
-		service:           service,
-		authService:       authService,
-		experienceService: experienceService,
+		service:              service,
+		authService:          authService,
+		experienceService:    experienceService,
+		educationService:     educationService,
+		certificationService: certificationService,
+		languageService:      languageService,
+		projectService:       projectService,
+		deviceService:        deviceService,
+		tagService:           tagService,
+		endorsementService:   endorsementService,
+		completenessService:  completenessService,
+		imageService:         imageService,
+		usernameService:      usernameService,
+		emailChangeService:   emailChangeService,
+		peopleSearchService:  peopleSearchService,
+		deletionService:      deletionService,
+		privacyService:       privacyService,
+		trustedProxies:       trustedProxies,
 	}
 }
 
@@ -36,7 +79,7 @@ func (handler *UserHandler) GetRequest(ctx context.Context, in *userService.User
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
 	id := in.UserId
-	objectId, err := primitive.ObjectIDFromHex(id)
+	objectId, err := parseObjectId("userId", id)
 	if err != nil {
 		return nil, err
 	}
@@ -44,52 +87,107 @@ func (handler *UserHandler) GetRequest(ctx context.Context, in *userService.User
 	if err != nil {
 		return nil, err
 	}
-	userPb := mapUser(user)
+	tagNames, err := handler.tagService.TagNames(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := handler.endorsementService.CountBySkill(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	userPb := mapUser(user, tagNames)
+	userPb.EndorsementCounts = mapEndorsementCounts(user, counts, tagNames)
 	response := &userService.GetResponse{
 		User: userPb,
 	}
 	return response, nil
 }
 
+// GetAllRequest returns every user at once.
+//
+// Deprecated: use ListUsersRequest, which pages and filters in the database.
 func (handler *UserHandler) GetAllRequest(ctx context.Context, in *userService.EmptyRequest) (*userService.UsersResponse, error) {
 	span := tracer.StartSpanFromContextMetadata(ctx, "GetAllRequest")
 	defer span.Finish()
+	token := bearerToken(ctx)
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	users, err := handler.service.GetAll(ctx)
+	requesterId, err := handler.authService.Requester(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	users, err := handler.service.GetAll(ctx, requesterId)
 	if err != nil {
 		return nil, err
 	}
 	response := &userService.UsersResponse{
 		Users: []*userService.User{},
 	}
+	tagNames, err := handler.tagService.TagNames(ctx, users...)
+	if err != nil {
+		return nil, err
+	}
 	for _, user := range users {
-		current := mapUser(user)
+		current := mapUser(user, tagNames)
 		response.Users = append(response.Users, current)
 	}
 	return response, nil
 }
 
-func (handler *UserHandler) PostRequest(ctx context.Context, in *userService.UserRequest) (*userService.GetResponse, error) {
-	span := tracer.StartSpanFromContextMetadata(ctx, "PostRequest")
+func (handler *UserHandler) ListUsersRequest(ctx context.Context, in *userService.ListUsersRequest) (*userService.ListUsersResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "ListUsersRequest")
 	defer span.Finish()
+	token := bearerToken(ctx)
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	if in.User.Password != in.User.ConfirmPassword {
-		return nil, errors.New("passwords not match")
+	requesterId, err := handler.authService.Requester(ctx, token)
+	if err != nil {
+		return nil, err
 	}
-
-	if in.User.Name == "" || in.User.Surname == "" || in.User.Email == "" || in.User.BirthDate == "" || in.User.Username == "" || in.User.Password == "" {
-		return nil, errors.New("not entered required fields")
+	filter := model.UserFilter{
+		Role:       model.UserRole(in.Role),
+		Confirmed:  in.Confirmed,
+		Private:    in.Private,
+		TFAEnabled: in.TfaEnabled,
+		SkillId:    in.Skill,
+	}
+	users, nextPageToken, err := handler.service.List(ctx, requesterId, filter, model.UserSort(in.SortBy), in.Descending, in.PageToken, in.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.ListUsersResponse{
+		Users:         []*userService.User{},
+		NextPageToken: nextPageToken,
 	}
+	tagNames, err := handler.tagService.TagNames(ctx, users...)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		response.Users = append(response.Users, mapUser(user, tagNames))
+	}
+	return response, nil
+}
+
+func (handler *UserHandler) PostRequest(ctx context.Context, in *userService.UserRequest) (*userService.GetResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "PostRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	userFromRequest := mapUserPb(in.User)
+	userFromRequest, err := mapUserPb(in.User)
+	if err != nil {
+		return nil, err
+	}
 	userFromRequest.Role = model.USER
 	user, err := handler.service.Create(ctx, userFromRequest)
 	if err != nil {
 		return nil, err
 	}
-	userPb := mapUser(user)
+	tagNames, err := handler.tagService.TagNames(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	userPb := mapUser(user, tagNames)
 	response := &userService.GetResponse{
 		User: userPb,
 	}
@@ -101,13 +199,20 @@ func (handler *UserHandler) PostAdminRequest(ctx context.Context, in *userServic
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	userFromRequest := mapUserPb(in.User)
+	userFromRequest, err := mapUserPb(in.User)
+	if err != nil {
+		return nil, err
+	}
 	userFromRequest.Role = model.ADMIN
 	user, err := handler.service.Create(ctx, userFromRequest)
 	if err != nil {
 		return nil, err
 	}
-	userPb := mapUser(user)
+	tagNames, err := handler.tagService.TagNames(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	userPb := mapUser(user, tagNames)
 	response := &userService.GetResponse{
 		User: userPb,
 	}
@@ -119,16 +224,23 @@ func (handler *UserHandler) UpdateRequest(ctx context.Context, in *userService.U
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	if in.User.Name == "" || in.User.Surname == "" || in.User.Email == "" || in.User.BirthDate == "" || in.User.Username == "" {
-		return nil, errors.New("not entered required fields")
+	id, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
 	}
-
-	id, _ := primitive.ObjectIDFromHex(in.UserId)
-	user, err := handler.service.Update(ctx, id, mapUserPb(in.User))
+	userFromRequest, err := mapUserPb(in.User)
+	if err != nil {
+		return nil, err
+	}
+	user, err := handler.service.Update(ctx, id, userFromRequest, mapMask(in.UpdateMask.GetPaths(), userMaskPaths), in.User.Version)
 	if err != nil {
 		return nil, err
 	}
-	userPb := mapUser(user)
+	tagNames, err := handler.tagService.TagNames(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	userPb := mapUser(user, tagNames)
 	response := &userService.GetResponse{
 		User: userPb,
 	}
@@ -139,8 +251,11 @@ func (handler *UserHandler) DeleteRequest(ctx context.Context, in *userService.U
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	id, _ := primitive.ObjectIDFromHex(in.UserId)
-	err := handler.service.Delete(ctx, id)
+	id, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = handler.deletionService.Start(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -151,9 +266,72 @@ func (handler *UserHandler) DeleteRequest(ctx context.Context, in *userService.U
 func (handler *UserHandler) LoginRequest(ctx context.Context, in *userService.CredentialsRequest) (*userService.LoginResponse, error) {
 	span := tracer.StartSpanFromContextMetadata(ctx, "LoginRequest")
 	defer span.Finish()
+	client := clientInfoFromContext(ctx, handler.trustedProxies)
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	return handler.authService.Login(ctx, in, client)
+}
+
+func (handler *UserHandler) GetKnownDevicesRequest(ctx context.Context, in *userService.UserIdRequest) (*userService.KnownDevicesResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "GetKnownDevicesRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := handler.deviceService.GetKnownDevices(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.KnownDevicesResponse{
+		Devices: []*userService.KnownDevice{},
+	}
+	for _, device := range devices {
+		response.Devices = append(response.Devices, mapKnownDevice(device))
+	}
+	return response, nil
+}
+
+func (handler *UserHandler) RemoveKnownDeviceRequest(ctx context.Context, in *userService.KnownDeviceRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "RemoveKnownDeviceRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	deviceId, err := parseObjectId("deviceId", in.DeviceId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.deviceService.RemoveKnownDevice(ctx, userId, deviceId)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
+}
+
+func (handler *UserHandler) ReportUnrecognizedLogin(ctx context.Context, in *userService.UnrecognizedLoginRequest) (*userService.EmptyRequest, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "ReportUnrecognizedLogin")
+	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	return handler.authService.Login(ctx, in)
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	loginId, err := parseObjectId("loginId", in.LoginId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.authService.ReportUnrecognizedLogin(ctx, userId, loginId)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
 }
 
 func (handler *UserHandler) ConfirmRegistration(ctx context.Context, in *userService.ConfirmationRequest) (*userService.ConfirmationResponse, error) {
@@ -169,7 +347,7 @@ func (handler *UserHandler) GetQR2FA(ctx context.Context, in *userService.UserId
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	userId, err := primitive.ObjectIDFromHex(in.UserId)
+	userId, err := parseObjectId("userId", in.UserId)
 	if err != nil {
 		return nil, err
 	}
@@ -191,7 +369,7 @@ func (handler *UserHandler) Enable2FA(ctx context.Context, in *userService.TFARe
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	userId, err := primitive.ObjectIDFromHex(in.Tfa.UserId)
+	userId, err := parseObjectId("userId", in.Tfa.UserId)
 	if err != nil {
 		return nil, err
 	}
@@ -207,14 +385,15 @@ func (handler *UserHandler) Enable2FA(ctx context.Context, in *userService.TFARe
 func (handler *UserHandler) Verify2FA(ctx context.Context, in *userService.TFARequest) (*userService.LoginResponse, error) {
 	span := tracer.StartSpanFromContextMetadata(ctx, "Verify2FA")
 	defer span.Finish()
+	client := clientInfoFromContext(ctx, handler.trustedProxies)
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	userId, err := primitive.ObjectIDFromHex(in.Tfa.UserId)
+	userId, err := parseObjectId("userId", in.Tfa.UserId)
 	if err != nil {
 		return nil, err
 	}
 
-	return handler.authService.Verify2fa(ctx, userId, in.Tfa.Code)
+	return handler.authService.Verify2fa(ctx, userId, in.Tfa.Code, client)
 }
 
 func (handler *UserHandler) Disable2FA(ctx context.Context, in *userService.UserIdRequest) (*userService.EmptyRequest, error) {
@@ -222,7 +401,7 @@ func (handler *UserHandler) Disable2FA(ctx context.Context, in *userService.User
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	userId, err := primitive.ObjectIDFromHex(in.UserId)
+	userId, err := parseObjectId("userId", in.UserId)
 	if err != nil {
 		return nil, err
 	}
@@ -239,18 +418,21 @@ func (handler *UserHandler) SearchUsersRequest(ctx context.Context, in *userServ
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	users, err := handler.service.Search(ctx, in.SearchParam, in.UserId)
+	users, nextPageToken, err := handler.service.Search(ctx, in.SearchParam, in.UserId, in.PageToken, in.PageSize)
 	if err != nil {
 		return nil, err
 	}
 	response := &userService.UsersResponse{
-		Users: []*userService.User{},
+		Users:         []*userService.User{},
+		NextPageToken: nextPageToken,
+	}
+	tagNames, err := handler.tagService.TagNames(ctx, users...)
+	if err != nil {
+		return nil, err
 	}
 	for _, user := range users {
-		if in.UserId != user.Id.Hex() {
-			current := mapUser(user)
-			response.Users = append(response.Users, current)
-		}
+		current := mapUser(user, tagNames)
+		response.Users = append(response.Users, current)
 	}
 	return response, nil
 }
@@ -277,11 +459,11 @@ func (handler *UserHandler) UpdatePasswordRequest(ctx context.Context, in *userS
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
 	if in.NewPassword.Password != in.NewPassword.ConfirmNewPassword {
-		return nil, errors.New("Passwords not match")
+		return nil, application.NewInvalidArgumentError("Passwords not match", application.FieldViolation{Field: "confirmNewPassword", Description: "must match the new password"})
 	}
 
 	id := in.GetNewPassword().GetUserId()
-	objectId, err := primitive.ObjectIDFromHex(id)
+	objectId, err := parseObjectId("userId", id)
 	if err != nil {
 		return nil, err
 	}
@@ -299,14 +481,18 @@ func (handler *UserHandler) UpdatePasswordRequest(ctx context.Context, in *userS
 	if err != nil {
 		return nil, err
 	}
-	user.Password, _ = security.BcryptGenerateFromPassword(in.NewPassword.Password)
+	hashedPassword, _ := security.BcryptGenerateFromPassword(in.NewPassword.Password)
 	if good {
-		_, err = handler.service.UpdatePassword(ctx, user.Id, user)
+		user, err = handler.service.UpdatePassword(ctx, user.Id, hashedPassword)
+		if err != nil {
+			return nil, err
+		}
+		tagNames, err := handler.tagService.TagNames(ctx, user)
 		if err != nil {
 			return nil, err
 		}
 		response := &userService.GetResponse{
-			User: mapUser(user),
+			User: mapUser(user, tagNames),
 		}
 		return response, nil
 	}
@@ -319,28 +505,43 @@ func (handler *UserHandler) ChangeUsernameRequest(ctx context.Context, in *userS
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
 	id := in.GetNewUsername().GetUserId()
-	objectId, err := primitive.ObjectIDFromHex(id)
+	objectId, err := parseObjectId("userId", id)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := handler.service.Get(ctx, objectId)
+	user, err := handler.usernameService.Change(ctx, objectId, in.GetNewUsername().GetUsername())
 	if err != nil {
 		return nil, err
 	}
-	good, err := handler.authService.CheckUsername(ctx, in.GetNewUsername().GetUsername())
-	if good {
-		user.Username = in.GetNewUsername().GetUsername()
-		_, err = handler.service.UpdateUsername(ctx, user.Id, user)
-		if err != nil {
-			return nil, err
-		}
-		response := &userService.GetResponse{
-			User: mapUser(user),
-		}
-		return response, nil
+	tagNames, err := handler.tagService.TagNames(ctx, user)
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	response := &userService.GetResponse{
+		User: mapUser(user, tagNames),
+	}
+	return response, nil
+}
+
+func (handler *UserHandler) ResolveUsername(ctx context.Context, in *userService.UsernameRequest) (*userService.ResolveUsernameResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "ResolveUsername")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	user, redirected, err := handler.usernameService.Resolve(ctx, in.Username)
+	if err != nil {
+		return nil, err
+	}
+	tagNames, err := handler.tagService.TagNames(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.ResolveUsernameResponse{
+		User:       mapUser(user, tagNames),
+		Redirected: redirected,
+	}
+	return response, nil
 }
 
 func (handler *UserHandler) PostExperienceRequest(ctx context.Context, in *userService.NewExperienceRequest) (*userService.NewExperienceResponse, error) {
@@ -348,7 +549,10 @@ func (handler *UserHandler) PostExperienceRequest(ctx context.Context, in *userS
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	experienceFromRequest := mapExperiencePb(in.Experience)
+	experienceFromRequest, err := mapExperiencePb(in.Experience)
+	if err != nil {
+		return nil, err
+	}
 
 	experience, err := handler.experienceService.Create(ctx, experienceFromRequest)
 
@@ -390,18 +594,60 @@ func (handler *UserHandler) DeleteExperienceRequest(ctx context.Context, in *use
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	id, _ := primitive.ObjectIDFromHex(in.ExperienceId)
-	handler.experienceService.Delete(ctx, id)
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseObjectId("experienceId", in.ExperienceId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.experienceService.Delete(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	}
 	response := &userService.EmptyRequest{}
 	return response, nil
 }
 
+func (handler *UserHandler) UpdateExperienceRequest(ctx context.Context, in *userService.UpdateExperienceRequest) (*userService.NewExperienceResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "UpdateExperienceRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	experienceId, err := parseObjectId("experience.id", in.Experience.Id)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := mapExperiencePb(in.Experience)
+	if err != nil {
+		return nil, err
+	}
+	changes.Id = experienceId
+
+	experience, err := handler.experienceService.Update(ctx, userId, changes, mapMask(in.UpdateMask.GetPaths(), experienceMaskPaths))
+	if err != nil {
+		return nil, err
+	}
+	response := &userService.NewExperienceResponse{
+		Experience: mapExperience(experience),
+	}
+	return response, nil
+}
+
 func (handler *UserHandler) IsUserPrivateRequest(ctx context.Context, in *userService.UserIdRequest) (*userService.PrivateResponse, error) {
 	span := tracer.StartSpanFromContextMetadata(ctx, "IsUserPrivateRequest")
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	id, _ := primitive.ObjectIDFromHex(in.UserId)
+	id, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
 
 	isPrivate, err := handler.service.IsUserPrivate(ctx, id)
 
@@ -417,16 +663,15 @@ func (handler *UserHandler) AddUserSkill(ctx context.Context, in *userService.Ne
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	id, _ := primitive.ObjectIDFromHex(in.NewSkill.UserId)
-	user, _ := handler.service.Get(ctx, id)
-	if slices.Contains(user.Skills, in.NewSkill.Skill) {
-		return nil, errors.New("interest already exists")
+	id, err := parseObjectId("newSkill.userId", in.NewSkill.UserId)
+	if err != nil {
+		return nil, err
 	}
-	user.Skills = append(user.Skills, in.NewSkill.Skill)
-
-	user, err := handler.service.Update(ctx, id, user)
-
-	return &userService.EmptyRequest{}, err
+	_, err = handler.tagService.AddUserTag(ctx, id, model.SKILL, in.NewSkill.Skill)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
 }
 
 func (handler *UserHandler) AddUserInterest(ctx context.Context, in *userService.NewInterestRequest) (*userService.EmptyRequest, error) {
@@ -434,17 +679,15 @@ func (handler *UserHandler) AddUserInterest(ctx context.Context, in *userService
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	id, _ := primitive.ObjectIDFromHex(in.NewInterest.UserId)
-	user, _ := handler.service.Get(ctx, id)
-	if slices.Contains(user.Interests, in.NewInterest.Interest) {
-		return nil, errors.New("interest already exists")
+	id, err := parseObjectId("newInterest.userId", in.NewInterest.UserId)
+	if err != nil {
+		return nil, err
 	}
-
-	user.Interests = append(user.Interests, in.NewInterest.Interest)
-
-	user, err := handler.service.Update(ctx, id, user)
-
-	return &userService.EmptyRequest{}, err
+	_, err = handler.tagService.AddUserTag(ctx, id, model.INTEREST, in.NewInterest.Interest)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
 }
 
 func (handler *UserHandler) RemoveInterest(ctx context.Context, in *userService.RemoveInterestRequest) (*userService.EmptyRequest, error) {
@@ -452,13 +695,15 @@ func (handler *UserHandler) RemoveInterest(ctx context.Context, in *userService.
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	id, _ := primitive.ObjectIDFromHex(in.UserId)
-	user, _ := handler.service.Get(ctx, id)
-	user.Interests = remove(user.Interests, in.Interest)
-
-	user, err := handler.service.Update(ctx, id, user)
-
-	return &userService.EmptyRequest{}, err
+	id, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.tagService.RemoveUserTag(ctx, id, model.INTEREST, in.Interest)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
 }
 
 func (handler *UserHandler) RemoveSkill(ctx context.Context, in *userService.RemoveSkillRequest) (*userService.EmptyRequest, error) {
@@ -466,13 +711,15 @@ func (handler *UserHandler) RemoveSkill(ctx context.Context, in *userService.Rem
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	id, _ := primitive.ObjectIDFromHex(in.UserId)
-	user, _ := handler.service.Get(ctx, id)
-	user.Skills = remove(user.Skills, in.Skill)
-
-	user, err := handler.service.Update(ctx, id, user)
-
-	return &userService.EmptyRequest{}, err
+	id, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.tagService.RemoveUserTag(ctx, id, model.SKILL, in.Skill)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.EmptyRequest{}, nil
 }
 
 func (handler *UserHandler) ApiTokenRequest(ctx context.Context, in *userService.UserIdRequest) (*userService.ApiTokenResponse, error) {
@@ -480,7 +727,7 @@ func (handler *UserHandler) ApiTokenRequest(ctx context.Context, in *userService
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	id, err := primitive.ObjectIDFromHex(in.UserId)
+	id, err := parseObjectId("userId", in.UserId)
 	if err != nil {
 		return nil, err
 	}
@@ -497,7 +744,7 @@ func (handler *UserHandler) ApiTokenCreateRequest(ctx context.Context, in *userS
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	id, err := primitive.ObjectIDFromHex(in.UserId)
+	id, err := parseObjectId("userId", in.UserId)
 	if err != nil {
 		return nil, err
 	}
@@ -514,7 +761,7 @@ func (handler *UserHandler) ApiTokenRemoveRequest(ctx context.Context, in *userS
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	id, err := primitive.ObjectIDFromHex(in.UserId)
+	id, err := parseObjectId("userId", in.UserId)
 	if err != nil {
 		return nil, err
 	}
@@ -569,32 +816,70 @@ func (handler *UserHandler) PasswordlessLogin(ctx context.Context, in *userServi
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(ctx, span)
 
-	uid, _ := primitive.ObjectIDFromHex(in.UserId)
-	lid, _ := primitive.ObjectIDFromHex(in.LoginId)
-	return handler.authService.PasswordlessLogin(ctx, uid, lid)
+	uid, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	lid, err := parseObjectId("loginId", in.LoginId)
+	if err != nil {
+		return nil, err
+	}
+	return handler.authService.PasswordlessLogin(ctx, uid, lid, clientInfoFromContext(ctx, handler.trustedProxies))
 }
 
+// ChangeProfilePrivacy flips the privacy of a profile. It is kept for clients
+// that have not moved to SetProfilePrivacyRequest yet.
+//
+// Deprecated: use SetProfilePrivacyRequest, which sets the privacy to a given
+// value so that a repeated call does not flip it back.
 func (handler *UserHandler) ChangeProfilePrivacy(ctx context.Context, in *userService.UserIdRequest) (*userService.EmptyRequest, error) {
 	span := tracer.StartSpanFromContextMetadata(ctx, "ChangeProfilePrivacy")
 	defer span.Finish()
-	ctx = tracer.ContextWithSpan(ctx, span)
+	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	userId, err := primitive.ObjectIDFromHex(in.UserId)
+	userId, err := parseObjectId("userId", in.UserId)
 	if err != nil {
 		return nil, err
 	}
-	err = handler.service.ChangeProfilePrivacy(ctx, userId)
+	user, err := handler.service.Get(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = handler.privacyService.Set(ctx, userId, !user.Private)
 	if err != nil {
 		return nil, err
 	}
 	return &userService.EmptyRequest{}, nil
 }
 
-func remove(s []string, r string) []string {
-	for i, v := range s {
-		if v == r {
-			return append(s[:i], s[i+1:]...)
-		}
+func (handler *UserHandler) SetProfilePrivacyRequest(ctx context.Context, in *userService.ProfilePrivacyRequest) (*userService.ProfilePrivacyResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "SetProfilePrivacyRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	user, err := handler.privacyService.Set(ctx, userId, in.Private)
+	if err != nil {
+		return nil, err
+	}
+	return &userService.ProfilePrivacyResponse{Private: user.Private, SyncPending: user.PrivacyPending}, nil
+}
+
+func (handler *UserHandler) GetProfileCompleteness(ctx context.Context, in *userService.UserIdRequest) (*userService.ProfileCompletenessResponse, error) {
+	span := tracer.StartSpanFromContextMetadata(ctx, "GetProfileCompleteness")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	userId, err := parseObjectId("userId", in.UserId)
+	if err != nil {
+		return nil, err
+	}
+	completeness, err := handler.completenessService.Get(ctx, userId)
+	if err != nil {
+		return nil, err
 	}
-	return s
+	return mapProfileCompleteness(completeness), nil
 }
@@ -0,0 +1,105 @@
+package clients
+
+import (
+	"sync"
+	"time"
+)
+
+type circuitState string
+
+const (
+	circuitClosed   circuitState = "closed"
+	circuitOpen     circuitState = "open"
+	circuitHalfOpen circuitState = "half-open"
+)
+
+// circuitBreaker stops calls to a service that keeps failing. After threshold
+// failures in a row it opens and rejects every call for cooldown; then it lets
+// a single probe through and closes again if the probe succeeds.
+type circuitBreaker struct {
+	lock      sync.Mutex
+	state     circuitState
+	failures  int64
+	threshold int64
+	cooldown  time.Duration
+	openedAt  time.Time
+	probing   bool
+	opened    int64
+}
+
+func newCircuitBreaker(threshold int64, cooldown time.Duration) *circuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &circuitBreaker{
+		state:     circuitClosed,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow reports whether a call may go out. Every allowed call has to be
+// followed by Success or Failure.
+func (breaker *circuitBreaker) Allow() bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	switch breaker.state {
+	case circuitOpen:
+		if time.Since(breaker.openedAt) < breaker.cooldown {
+			return false
+		}
+		breaker.state = circuitHalfOpen
+		breaker.probing = true
+		return true
+	case circuitHalfOpen:
+		if breaker.probing {
+			return false
+		}
+		breaker.probing = true
+		return true
+	}
+	return true
+}
+
+func (breaker *circuitBreaker) Success() {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	breaker.state = circuitClosed
+	breaker.failures = 0
+	breaker.probing = false
+}
+
+func (breaker *circuitBreaker) Failure() {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	breaker.failures++
+	breaker.probing = false
+	if breaker.state == circuitHalfOpen || breaker.failures >= breaker.threshold {
+		if breaker.state != circuitOpen {
+			breaker.opened++
+		}
+		breaker.state = circuitOpen
+		breaker.openedAt = time.Now()
+	}
+}
+
+// Release ends an allowed call whose outcome says nothing about the health
+// of the service, such as one the caller cancelled.
+func (breaker *circuitBreaker) Release() {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	breaker.probing = false
+}
+
+func (breaker *circuitBreaker) State() circuitState {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.state
+}
+
+// Opened counts how many times the breaker has opened.
+func (breaker *circuitBreaker) Opened() int64 {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.opened
+}
@@ -0,0 +1,187 @@
+package clients
+
+import (
+	"context"
+	"expvar"
+	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the connection service while
+// the circuit breaker is open.
+var ErrCircuitOpen = status.Error(codes.Unavailable, "connection service is unavailable: circuit breaker is open")
+
+type ConnectionClientOptions struct {
+	// ReadTimeout bounds every attempt of a query, WriteTimeout every call
+	// that changes connections.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Retries is how many times a failed query is repeated. Writes are not
+	// retried here: their callers already retry them with backoff.
+	Retries      int64
+	RetryBackoff time.Duration
+	// BreakerFailures failures in a row open the circuit for BreakerCooldown.
+	BreakerFailures int64
+	BreakerCooldown time.Duration
+	// MetricsName is the expvar the client publishes its counters under.
+	MetricsName string
+}
+
+// ConnectionClient wraps the generated connection service client with a
+// deadline on every call, retries with jitter for queries and a circuit
+// breaker shared by all calls. It publishes per method counters of calls,
+// failures, retries and rejected calls, and the state of the breaker.
+type ConnectionClient struct {
+	client  connectionService.ConnectionServiceClient
+	options ConnectionClientOptions
+	breaker *circuitBreaker
+	metrics *expvar.Map
+	random  *rand.Rand
+	lock    sync.Mutex
+}
+
+func NewConnectionClient(client connectionService.ConnectionServiceClient, options ConnectionClientOptions) *ConnectionClient {
+	connectionClient := &ConnectionClient{
+		client:  client,
+		options: options,
+		breaker: newCircuitBreaker(options.BreakerFailures, options.BreakerCooldown),
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	connectionClient.metrics = expvar.NewMap(options.MetricsName)
+	connectionClient.metrics.Set("circuit", expvar.Func(func() interface{} {
+		return connectionClient.breaker.State()
+	}))
+	connectionClient.metrics.Set("circuitOpened", expvar.Func(func() interface{} {
+		return connectionClient.breaker.Opened()
+	}))
+	return connectionClient
+}
+
+func (client *ConnectionClient) IsBlockedAny(ctx context.Context, in *connectionService.Block, opts ...grpc.CallOption) (*connectionService.BlockedResponse, error) {
+	var response *connectionService.BlockedResponse
+	err := client.query(ctx, "IsBlockedAny", func(ctx context.Context) (err error) {
+		response, err = client.client.IsBlockedAny(ctx, in, opts...)
+		return err
+	})
+	return response, err
+}
+
+func (client *ConnectionClient) IsConnected(ctx context.Context, in *connectionService.Connection, opts ...grpc.CallOption) (*connectionService.ConnectedResponse, error) {
+	var response *connectionService.ConnectedResponse
+	err := client.query(ctx, "IsConnected", func(ctx context.Context) (err error) {
+		response, err = client.client.IsConnected(ctx, in, opts...)
+		return err
+	})
+	return response, err
+}
+
+func (client *ConnectionClient) GetBlockedAny(ctx context.Context, in *connectionService.UserIdRequest, opts ...grpc.CallOption) (*connectionService.UserIdsResponse, error) {
+	var response *connectionService.UserIdsResponse
+	err := client.query(ctx, "GetBlockedAny", func(ctx context.Context) (err error) {
+		response, err = client.client.GetBlockedAny(ctx, in, opts...)
+		return err
+	})
+	return response, err
+}
+
+func (client *ConnectionClient) ApproveAllConnection(ctx context.Context, in *connectionService.UserIdRequest, opts ...grpc.CallOption) (*connectionService.EmptyRequest, error) {
+	var response *connectionService.EmptyRequest
+	err := client.write(ctx, "ApproveAllConnection", func(ctx context.Context) (err error) {
+		response, err = client.client.ApproveAllConnection(ctx, in, opts...)
+		return err
+	})
+	return response, err
+}
+
+func (client *ConnectionClient) PurgeUser(ctx context.Context, in *connectionService.UserIdRequest, opts ...grpc.CallOption) (*connectionService.EmptyRequest, error) {
+	var response *connectionService.EmptyRequest
+	err := client.write(ctx, "PurgeUser", func(ctx context.Context) (err error) {
+		response, err = client.client.PurgeUser(ctx, in, opts...)
+		return err
+	})
+	return response, err
+}
+
+func (client *ConnectionClient) query(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	return client.invoke(ctx, method, client.options.ReadTimeout, client.options.Retries, call)
+}
+
+func (client *ConnectionClient) write(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	return client.invoke(ctx, method, client.options.WriteTimeout, 0, call)
+}
+
+func (client *ConnectionClient) invoke(ctx context.Context, method string, timeout time.Duration, retries int64, call func(ctx context.Context) error) error {
+	client.metrics.Add(method+".calls", 1)
+	for attempt := int64(0); ; attempt++ {
+		if !client.breaker.Allow() {
+			client.metrics.Add(method+".rejected", 1)
+			return ErrCircuitOpen
+		}
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := call(callCtx)
+		cancel()
+		if err == nil {
+			client.breaker.Success()
+			return nil
+		}
+		if ctx.Err() != nil {
+			// The caller gave up, which says nothing about the service.
+			client.breaker.Release()
+			client.metrics.Add(method+".failures", 1)
+			return err
+		}
+		if serviceFailure(err) {
+			client.breaker.Failure()
+		} else {
+			// The service answered, only not with a result.
+			client.breaker.Success()
+		}
+		if attempt >= retries || !transient(err) {
+			client.metrics.Add(method+".failures", 1)
+			return err
+		}
+		client.metrics.Add(method+".retries", 1)
+		select {
+		case <-ctx.Done():
+			client.metrics.Add(method+".failures", 1)
+			return err
+		case <-time.After(client.retryDelay(attempt)):
+		}
+	}
+}
+
+// retryDelay picks a random delay up to a limit that doubles with every
+// attempt, so that replicas retrying at once spread out.
+func (client *ConnectionClient) retryDelay(attempt int64) time.Duration {
+	limit := client.options.RetryBackoff << attempt
+	if limit <= 0 {
+		return 0
+	}
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return time.Duration(client.random.Int63n(int64(limit)) + 1)
+}
+
+// serviceFailure reports whether err means the connection service is down
+// or overloaded rather than that it rejected the request.
+func serviceFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+// transient reports whether the same call may well succeed when repeated.
+func transient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,319 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConnectionService counts the calls it receives and answers each of
+// them with handle.
+type fakeConnectionService struct {
+	connectionService.UnimplementedConnectionServiceServer
+	lock   sync.Mutex
+	calls  map[string]int
+	handle func(ctx context.Context, method string) error
+}
+
+func (service *fakeConnectionService) call(ctx context.Context, method string) error {
+	service.lock.Lock()
+	service.calls[method]++
+	handle := service.handle
+	service.lock.Unlock()
+	if handle == nil {
+		return nil
+	}
+	return handle(ctx, method)
+}
+
+func (service *fakeConnectionService) count(method string) int {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+	return service.calls[method]
+}
+
+func (service *fakeConnectionService) setHandle(handle func(ctx context.Context, method string) error) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+	service.handle = handle
+}
+
+func (service *fakeConnectionService) IsBlockedAny(ctx context.Context, in *connectionService.Block) (*connectionService.BlockedResponse, error) {
+	return &connectionService.BlockedResponse{}, service.call(ctx, "IsBlockedAny")
+}
+
+func (service *fakeConnectionService) GetBlockedAny(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.UserIdsResponse, error) {
+	return &connectionService.UserIdsResponse{UserIds: []string{"blocked"}}, service.call(ctx, "GetBlockedAny")
+}
+
+func (service *fakeConnectionService) PurgeUser(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.EmptyRequest, error) {
+	return &connectionService.EmptyRequest{}, service.call(ctx, "PurgeUser")
+}
+
+// testClients keeps expvar names unique, they are global to the test
+// binary.
+var testClients int64
+
+func testOptions(t *testing.T) ConnectionClientOptions {
+	testClients++
+	return ConnectionClientOptions{
+		ReadTimeout:     time.Second,
+		WriteTimeout:    time.Second,
+		Retries:         0,
+		RetryBackoff:    time.Millisecond,
+		BreakerFailures: 100,
+		BreakerCooldown: time.Hour,
+		MetricsName:     "connectionClient." + t.Name() + "." + strconv.FormatInt(testClients, 10),
+	}
+}
+
+// newTestClient serves service on an in-memory listener and returns a
+// client that talks to it through a real gRPC connection.
+func newTestClient(t *testing.T, service *fakeConnectionService, options ConnectionClientOptions) *ConnectionClient {
+	service.calls = map[string]int{}
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	connectionService.RegisterConnectionServiceServer(server, service)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewConnectionClient(connectionService.NewConnectionServiceClient(conn), options)
+}
+
+func unavailable(ctx context.Context, method string) error {
+	return status.Error(codes.Unavailable, "down")
+}
+
+func waitForDone(ctx context.Context, method string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestEveryAttemptHasItsOwnDeadline(t *testing.T) {
+	service := &fakeConnectionService{handle: waitForDone}
+	options := testOptions(t)
+	options.ReadTimeout = 50 * time.Millisecond
+	options.Retries = 2
+	client := newTestClient(t, service, options)
+
+	start := time.Now()
+	_, err := client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if calls := service.count("GetBlockedAny"); calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("attempts did not time out on their own, took %v", elapsed)
+	}
+}
+
+func TestWritesUseTheWriteTimeout(t *testing.T) {
+	service := &fakeConnectionService{handle: func(ctx context.Context, method string) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}}
+	options := testOptions(t)
+	options.ReadTimeout = 20 * time.Millisecond
+	options.WriteTimeout = time.Second
+	client := newTestClient(t, service, options)
+
+	if _, err := client.PurgeUser(context.Background(), &connectionService.UserIdRequest{UserId: "user"}); err != nil {
+		t.Fatalf("write should outlast the read timeout: %v", err)
+	}
+	if _, err := client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"}); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected the query to time out, got %v", err)
+	}
+}
+
+func TestOnlyQueriesAreRetried(t *testing.T) {
+	service := &fakeConnectionService{handle: unavailable}
+	options := testOptions(t)
+	options.Retries = 2
+	client := newTestClient(t, service, options)
+
+	_, err := client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+	if calls := service.count("GetBlockedAny"); calls != 3 {
+		t.Fatalf("expected the query to be tried 3 times, got %d", calls)
+	}
+
+	_, err = client.PurgeUser(context.Background(), &connectionService.UserIdRequest{UserId: "user"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+	if calls := service.count("PurgeUser"); calls != 1 {
+		t.Fatalf("expected the write to be tried once, got %d", calls)
+	}
+}
+
+func TestRejectedQueriesAreNotRetried(t *testing.T) {
+	service := &fakeConnectionService{handle: func(ctx context.Context, method string) error {
+		return status.Error(codes.InvalidArgument, "bad id")
+	}}
+	options := testOptions(t)
+	options.Retries = 2
+	options.BreakerFailures = 1
+	client := newTestClient(t, service, options)
+
+	_, err := client.IsBlockedAny(context.Background(), &connectionService.Block{UserId: "user", BlockUserId: "other"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	if calls := service.count("IsBlockedAny"); calls != 1 {
+		t.Fatalf("expected a single attempt, got %d", calls)
+	}
+	if state := client.breaker.State(); state != circuitClosed {
+		t.Fatalf("a rejected request must not open the breaker, state is %s", state)
+	}
+}
+
+func TestBreakerOpensAfterFailuresInARow(t *testing.T) {
+	service := &fakeConnectionService{handle: unavailable}
+	options := testOptions(t)
+	options.BreakerFailures = 3
+	client := newTestClient(t, service, options)
+
+	for i := 0; i < 3; i++ {
+		if state := client.breaker.State(); state != circuitClosed {
+			t.Fatalf("breaker opened after %d failures", i)
+		}
+		client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"})
+	}
+	if state := client.breaker.State(); state != circuitOpen {
+		t.Fatalf("expected the breaker to be open, state is %s", state)
+	}
+
+	_, err := client.PurgeUser(context.Background(), &connectionService.UserIdRequest{UserId: "user"})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if calls := service.count("PurgeUser"); calls != 0 {
+		t.Fatalf("an open breaker must not call the service, got %d calls", calls)
+	}
+	if opened := client.breaker.Opened(); opened != 1 {
+		t.Fatalf("expected the breaker to have opened once, got %d", opened)
+	}
+}
+
+func TestHalfOpenBreakerLetsOneProbeThrough(t *testing.T) {
+	service := &fakeConnectionService{handle: unavailable}
+	options := testOptions(t)
+	options.BreakerFailures = 1
+	options.BreakerCooldown = 50 * time.Millisecond
+	client := newTestClient(t, service, options)
+
+	client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"})
+	if state := client.breaker.State(); state != circuitOpen {
+		t.Fatalf("expected the breaker to be open, state is %s", state)
+	}
+	time.Sleep(options.BreakerCooldown)
+
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	service.setHandle(func(ctx context.Context, method string) error {
+		close(arrived)
+		<-release
+		return nil
+	})
+	probe := make(chan error)
+	go func() {
+		_, err := client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"})
+		probe <- err
+	}()
+	<-arrived
+
+	_, err := client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected calls during the probe to be rejected, got %v", err)
+	}
+	service.setHandle(nil)
+	close(release)
+	if err := <-probe; err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if state := client.breaker.State(); state != circuitClosed {
+		t.Fatalf("expected a successful probe to close the breaker, state is %s", state)
+	}
+	if _, err := client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"}); err != nil {
+		t.Fatalf("expected calls to go through again, got %v", err)
+	}
+	if calls := service.count("GetBlockedAny"); calls != 3 {
+		t.Fatalf("expected 3 calls to reach the service, got %d", calls)
+	}
+}
+
+func TestCancelledCallsDoNotCountAsFailures(t *testing.T) {
+	service := &fakeConnectionService{handle: waitForDone}
+	options := testOptions(t)
+	options.BreakerFailures = 1
+	options.Retries = 2
+	client := newTestClient(t, service, options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.GetBlockedAny(ctx, &connectionService.UserIdRequest{UserId: "user"})
+	if err == nil {
+		t.Fatal("expected the cancelled call to fail")
+	}
+	if calls := service.count("GetBlockedAny"); calls != 1 {
+		t.Fatalf("a cancelled call must not be retried, got %d attempts", calls)
+	}
+	if state := client.breaker.State(); state != circuitClosed {
+		t.Fatalf("a cancelled call must not open the breaker, state is %s", state)
+	}
+
+	service.setHandle(nil)
+	if _, err := client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"}); err != nil {
+		t.Fatalf("expected the next call to go through, got %v", err)
+	}
+}
+
+func TestCancelledProbeLetsTheNextCallProbe(t *testing.T) {
+	service := &fakeConnectionService{handle: unavailable}
+	options := testOptions(t)
+	options.BreakerFailures = 1
+	options.BreakerCooldown = 50 * time.Millisecond
+	client := newTestClient(t, service, options)
+
+	client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"})
+	time.Sleep(options.BreakerCooldown)
+
+	service.setHandle(waitForDone)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	client.GetBlockedAny(ctx, &connectionService.UserIdRequest{UserId: "user"})
+	if state := client.breaker.State(); state != circuitHalfOpen {
+		t.Fatalf("expected the breaker to stay half-open, state is %s", state)
+	}
+
+	service.setHandle(nil)
+	if _, err := client.GetBlockedAny(context.Background(), &connectionService.UserIdRequest{UserId: "user"}); err != nil {
+		t.Fatalf("expected a new probe after the cancelled one, got %v", err)
+	}
+	if state := client.breaker.State(); state != circuitClosed {
+		t.Fatalf("expected the breaker to close, state is %s", state)
+	}
+}
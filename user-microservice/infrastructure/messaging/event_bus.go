@@ -0,0 +1,67 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+	"user-microservice/model"
+)
+
+// deliveredKeysKept bounds how many keys the bus remembers to drop
+// redelivered events.
+const deliveredKeysKept = 10000
+
+type EventHandler func(ctx context.Context, event *model.OutboxEvent) error
+
+// EventBus delivers events to handlers in the same process. It is meant for
+// tests and for running without a broker. An event nobody is subscribed to is
+// delivered to nobody, like a broker topic without consumers, rather than
+// kept back: holding it would hold every later event of the user as well.
+// Every handler sees an event once, however many times it is published; a
+// failing handler gets it again on the next publish.
+type EventBus struct {
+	lock      sync.Mutex
+	handlers  []EventHandler
+	delivered []map[string]bool
+	order     [][]string
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+func (bus *EventBus) Subscribe(handler EventHandler) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	bus.handlers = append(bus.handlers, handler)
+	bus.delivered = append(bus.delivered, map[string]bool{})
+	bus.order = append(bus.order, nil)
+}
+
+func (bus *EventBus) Publish(ctx context.Context, event *model.OutboxEvent) error {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	var failed error
+	for i, handler := range bus.handlers {
+		if bus.delivered[i][event.Key] {
+			continue
+		}
+		err := handler(ctx, event)
+		if err != nil {
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+		bus.delivered[i][event.Key] = true
+		bus.order[i] = append(bus.order[i], event.Key)
+		if len(bus.order[i]) > deliveredKeysKept {
+			delete(bus.delivered[i], bus.order[i][0])
+			bus.order[i] = bus.order[i][1:]
+		}
+	}
+	return failed
+}
+
+func (bus *EventBus) Close() error {
+	return nil
+}
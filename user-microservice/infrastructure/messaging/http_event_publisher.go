@@ -0,0 +1,71 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"user-microservice/model"
+)
+
+const httpPublishTimeout = 10 * time.Second
+
+// eventMessage is the body of a published event.
+type eventMessage struct {
+	Key        string                `json:"key"`
+	Type       model.DomainEventType `json:"type"`
+	UserId     string                `json:"userId"`
+	OccurredAt time.Time             `json:"occurredAt"`
+	Payload    json.RawMessage       `json:"payload"`
+}
+
+// HttpEventPublisher posts every event as JSON to a single endpoint, such as
+// a broker's HTTP bridge. The key is also sent as the Idempotency-Key header.
+// Any 2xx response counts as delivered.
+type HttpEventPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func NewHttpEventPublisher(url string) *HttpEventPublisher {
+	return &HttpEventPublisher{
+		url:    url,
+		client: &http.Client{Timeout: httpPublishTimeout},
+	}
+}
+
+func (publisher *HttpEventPublisher) Publish(ctx context.Context, event *model.OutboxEvent) error {
+	body, err := json.Marshal(eventMessage{
+		Key:        event.Key,
+		Type:       event.Type,
+		UserId:     event.UserId,
+		OccurredAt: event.OccurredAt,
+		Payload:    json.RawMessage(event.Payload),
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, publisher.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Idempotency-Key", event.Key)
+	request.Header.Set("X-Event-Type", string(event.Type))
+	response, err := publisher.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("publishing event %s: %s", event.Key, response.Status)
+	}
+	return nil
+}
+
+func (publisher *HttpEventPublisher) Close() error {
+	publisher.client.CloseIdleConnections()
+	return nil
+}
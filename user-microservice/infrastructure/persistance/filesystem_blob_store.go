@@ -0,0 +1,83 @@
+package persistance
+
+import (
+	"context"
+	"errors"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+	"os"
+	"path/filepath"
+	"strings"
+	"user-microservice/model"
+)
+
+// FileSystemBlobStore keeps every blob as a file below root, using the key as
+// the relative path.
+type FileSystemBlobStore struct {
+	root string
+}
+
+func NewFileSystemBlobStore(root string) model.BlobStore {
+	return &FileSystemBlobStore{
+		root: root,
+	}
+}
+
+func (store *FileSystemBlobStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	span := tracer.StartSpanFromContext(ctx, "FileSystemBlobStore.Put")
+	defer span.Finish()
+
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so that readers never see half a blob
+	temp := path + ".tmp"
+	err = os.WriteFile(temp, data, 0o640)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+func (store *FileSystemBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	span := tracer.StartSpanFromContext(ctx, "FileSystemBlobStore.Get")
+	defer span.Finish()
+
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, model.ErrBlobNotFound
+	}
+	return data, err
+}
+
+func (store *FileSystemBlobStore) Delete(ctx context.Context, key string) error {
+	span := tracer.StartSpanFromContext(ctx, "FileSystemBlobStore.Delete")
+	defer span.Finish()
+
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// path resolves a key below root and refuses keys that would escape it.
+func (store *FileSystemBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errors.New("invalid blob key: " + key)
+	}
+	return filepath.Join(store.root, clean), nil
+}
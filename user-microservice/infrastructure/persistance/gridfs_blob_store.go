@@ -0,0 +1,85 @@
+package persistance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"user-microservice/model"
+)
+
+// GridFSBlobStore keeps blobs in a GridFS bucket of the users database, using
+// the key as the file name.
+type GridFSBlobStore struct {
+	bucket *gridfs.Bucket
+}
+
+func NewGridFSBlobStore(client *mongo.Client, bucketName string) (model.BlobStore, error) {
+	bucket, err := gridfs.NewBucket(client.Database(DATABASE), options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, err
+	}
+	return &GridFSBlobStore{
+		bucket: bucket,
+	}, nil
+}
+
+// Put replaces any earlier blob stored under the same key.
+func (store *GridFSBlobStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	span := tracer.StartSpanFromContext(ctx, "GridFSBlobStore.Put")
+	defer span.Finish()
+
+	err := store.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	opts := options.GridFSUpload().SetMetadata(bson.M{"contentType": contentType})
+	_, err = store.bucket.UploadFromStream(key, bytes.NewReader(data), opts)
+	return err
+}
+
+func (store *GridFSBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	span := tracer.StartSpanFromContext(ctx, "GridFSBlobStore.Get")
+	defer span.Finish()
+
+	var buffer bytes.Buffer
+	_, err := store.bucket.DownloadToStreamByName(key, &buffer)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, model.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (store *GridFSBlobStore) Delete(ctx context.Context, key string) error {
+	span := tracer.StartSpanFromContext(ctx, "GridFSBlobStore.Delete")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	cursor, err := store.bucket.Find(bson.M{"filename": key})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var files []struct {
+		Id interface{} `bson:"_id"`
+	}
+	err = cursor.All(ctx, &files)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err = store.bucket.Delete(file.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package persistance
+
+import (
+	"context"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+)
+
+// caseInsensitive is the collation of the username and email indexes. Queries
+// on those fields have to use it too, both to hit the index and to match the
+// way uniqueness is enforced.
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+type collectionIndexes struct {
+	collection string
+	indexes    []mongo.IndexModel
+}
+
+// indexDefinitions lists every index the store relies on. Each index has a
+// fixed name so that changing its options shows up as a conflict instead of a
+// second index next to the old one.
+var indexDefinitions = []collectionIndexes{
+	{COLLECTION, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetName("username_unique").
+			SetUnique(true).SetCollation(caseInsensitive)},
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetName("email_unique").
+			SetUnique(true).SetCollation(caseInsensitive).
+			SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}})},
+		{Keys: bson.D{{Key: "confirmationId", Value: 1}}, Options: options.Index().SetName("confirmationId")},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("name_id").
+			SetCollation(caseInsensitive)},
+		{Keys: bson.D{{Key: "search.words", Value: 1}}, Options: options.Index().SetName("search_words")},
+		{Keys: bson.D{{Key: "search.grams", Value: 1}}, Options: options.Index().SetName("search_grams")},
+		{Keys: bson.D{{Key: "skills", Value: 1}}, Options: options.Index().SetName("skills")},
+		{Keys: bson.D{{Key: "interests", Value: 1}}, Options: options.Index().SetName("interests")},
+		{Keys: bson.D{{Key: "privacyPending", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("privacyPending_id").
+			SetPartialFilterExpression(bson.M{"privacyPending": true})},
+	}},
+	{"experiences", userIdIndex()},
+	{"educations", userIdIndex()},
+	{"certifications", userIdIndex()},
+	{"languages", userIdIndex()},
+	{"projects", userIdIndex()},
+	{"passwordRecoveryRequests", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "validTo", Value: 1}}, Options: options.Index().SetName("validTo_ttl").SetExpireAfterSeconds(0)},
+	}},
+	{"passwordlessLogins", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "creationTime", Value: 1}}, Options: options.Index().SetName("creationTime_ttl").SetExpireAfterSeconds(15 * 60)},
+	}},
+	{"sessions", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetName("tokenHash_unique").SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetName("userId")},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0)},
+	}},
+	{"loginRecords", userIdIndex()},
+	{"knownDevices", userIdIndex()},
+	{"auditEvents", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sequence", Value: 1}}, Options: options.Index().SetName("sequence_unique").SetUnique(true)},
+	}},
+	{"auditCheckpoints", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sequence", Value: 1}}, Options: options.Index().SetName("sequence")},
+	}},
+	{"tags", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "keys", Value: 1}}, Options: options.Index().SetName("kind_keys_unique").SetUnique(true)},
+		{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "usage", Value: -1}}, Options: options.Index().SetName("kind_usage")},
+	}},
+	{"endorsements", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "skillId", Value: 1}, {Key: "endorserId", Value: 1}},
+			Options: options.Index().SetName("userId_skillId_endorserId_unique").SetUnique(true)},
+		{Keys: bson.D{{Key: "skillId", Value: 1}}, Options: options.Index().SetName("skillId")},
+	}},
+	{"profileCompleteness", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetName("userId_unique").SetUnique(true)},
+	}},
+	{"usernameHistory", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "oldUsername", Value: 1}, {Key: "timestamp", Value: -1}}, Options: options.Index().SetName("oldUsername_timestamp").
+			SetCollation(caseInsensitive)},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "timestamp", Value: -1}}, Options: options.Index().SetName("userId_timestamp")},
+	}},
+	{"emailChanges", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetName("userId")},
+		// Confirmed changes stay around while their revert link is valid.
+		{Keys: bson.D{{Key: "validTo", Value: 1}}, Options: options.Index().SetName("validTo_ttl").SetExpireAfterSeconds(8 * 24 * 60 * 60)},
+	}},
+	{"privacySyncs", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "nextAttemptAt", Value: 1}}, Options: options.Index().SetName("nextAttemptAt")},
+	}},
+	{"userDeletions", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetName("userId_unique").SetUnique(true)},
+		{Keys: bson.D{{Key: "state", Value: 1}, {Key: "nextAttemptAt", Value: 1}}, Options: options.Index().SetName("state_nextAttemptAt")},
+	}},
+	{"outbox", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetName("key_unique").SetUnique(true)},
+		{Keys: bson.D{{Key: "published", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("published_id").
+			SetPartialFilterExpression(bson.M{"published": false})},
+		// Published events are kept for a week to look into deliveries.
+		{Keys: bson.D{{Key: "publishedAt", Value: 1}}, Options: options.Index().SetName("publishedAt_ttl").SetExpireAfterSeconds(7 * 24 * 60 * 60)},
+	}},
+}
+
+func userIdIndex() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetName("userId")},
+	}
+}
+
+// EnsureIndexes creates every index in indexDefinitions that does not exist
+// yet. Creating an existing index is a no-op, so it is safe to run on every
+// start. It fails when existing documents violate a unique index.
+func EnsureIndexes(ctx context.Context, client *mongo.Client) error {
+	span := tracer.StartSpanFromContext(ctx, "EnsureIndexes")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	database := client.Database(DATABASE)
+	for _, definition := range indexDefinitions {
+		names, err := database.Collection(definition.collection).Indexes().CreateMany(ctx, definition.indexes)
+		if err != nil {
+			return err
+		}
+		log.Printf("indexes of %s: %v", definition.collection, names)
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package persistance
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"user-microservice/model"
+)
+
+const (
+	invertedIndexFile    = "users.idx"
+	invertedIndexVersion = 1
+	invertedIndexFlush   = 5 * time.Second
+	maxIndexedBioWords   = 200
+)
+
+// Scores of a query token by where it matched. They follow the ranking of
+// the Mongo search so that switching backends does not reorder results much.
+const (
+	scoreExactUsername  = 100
+	scoreUsernameWord   = 80
+	scoreNameWord       = 60
+	scoreFuzzyUsername  = 35
+	scoreFuzzyName      = 30
+	scoreBioWord        = 20
+	scoreFuzzyBioWord   = 10
+	minFuzzyTokenLength = 4
+	longFuzzyToken      = 8
+)
+
+type termFields uint8
+
+const (
+	inUsername termFields = 1 << iota
+	inName
+	inBio
+)
+
+// indexedUser is what the index keeps of a user, already folded. It is also
+// what the snapshot on disk holds; the postings are rebuilt from it on load.
+type indexedUser struct {
+	Username      string
+	UsernameWords []string
+	NameWords     []string
+	BioWords      []string
+}
+
+type indexSnapshot struct {
+	Version    int
+	Users      map[string]*indexedUser
+	Checkpoint []byte
+}
+
+// InvertedIndex is an embedded search index kept in memory and saved to a
+// single file on local disk. Every query token is looked up as a prefix of the
+// indexed words and, when it is long enough, as a word within one or two typos
+// of it. The file is rewritten in the background a few seconds after a change
+// and on Close; whatever a crash loses is replayed from the change feed,
+// whose position is saved with it.
+type InvertedIndex struct {
+	mutex      sync.RWMutex
+	path       string
+	users      map[primitive.ObjectID]*indexedUser
+	postings   map[string]map[primitive.ObjectID]termFields
+	terms      []string
+	checkpoint []byte
+	dirty      bool
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+func NewInvertedIndex(dir string) (*InvertedIndex, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	index := &InvertedIndex{
+		path:     filepath.Join(dir, invertedIndexFile),
+		users:    map[primitive.ObjectID]*indexedUser{},
+		postings: map[string]map[primitive.ObjectID]termFields{},
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	err = index.load()
+	if err != nil {
+		// The index can always be rebuilt, so a bad file only costs a
+		// reindex.
+		log.Printf("discarding search index %s: %v", index.path, err)
+		index.reset()
+	}
+	go index.flushPeriodically()
+	return index, nil
+}
+
+func (index *InvertedIndex) Index(ctx context.Context, user *model.User) error {
+	if user.Role != model.USER {
+		return index.Remove(ctx, user.Id)
+	}
+	username := model.FoldSearchText(user.Username)
+	indexed := &indexedUser{
+		Username:      username,
+		UsernameWords: append([]string{username}, model.SearchTokens(username)...),
+		NameWords:     model.SearchTokens(model.FoldSearchText(user.Name + " " + user.Surname)),
+		BioWords:      model.SearchTokens(model.FoldSearchText(user.Bio)),
+	}
+	if len(indexed.BioWords) > maxIndexedBioWords {
+		indexed.BioWords = indexed.BioWords[:maxIndexedBioWords]
+	}
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.unindex(user.Id)
+	index.add(user.Id, indexed)
+	index.dirty = true
+	return nil
+}
+
+func (index *InvertedIndex) Remove(ctx context.Context, userId primitive.ObjectID) error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	if index.unindex(userId) {
+		index.dirty = true
+	}
+	return nil
+}
+
+func (index *InvertedIndex) Reset(ctx context.Context) error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.reset()
+	index.dirty = true
+	return nil
+}
+
+func (index *InvertedIndex) Checkpoint() []byte {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return index.checkpoint
+}
+
+func (index *InvertedIndex) SetCheckpoint(position []byte) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	if !bytes.Equal(index.checkpoint, position) {
+		index.checkpoint = position
+		index.dirty = true
+	}
+}
+
+// Search scores every user against each token by the best place the token
+// matched and keeps users that matched all tokens. Without tokens every user
+// matches with a score of 0.
+func (index *InvertedIndex) Search(ctx context.Context, query *model.UserSearchQuery) ([]*model.UserSearchHit, error) {
+	index.mutex.RLock()
+	for index.terms == nil {
+		index.mutex.RUnlock()
+		index.sortTerms()
+		index.mutex.RLock()
+	}
+	defer index.mutex.RUnlock()
+
+	var scores map[primitive.ObjectID]int64
+	if len(query.Tokens) == 0 {
+		scores = make(map[primitive.ObjectID]int64, len(index.users))
+		for id := range index.users {
+			scores[id] = 0
+		}
+	}
+	for i, token := range query.Tokens {
+		tokenScores := index.scoreToken(token)
+		if i == 0 {
+			scores = tokenScores
+			continue
+		}
+		for id, score := range scores {
+			if tokenScore, ok := tokenScores[id]; ok {
+				scores[id] = score + tokenScore
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	for _, id := range query.ExcludeIds {
+		delete(scores, id)
+	}
+
+	hits := make([]*model.UserSearchHit, 0, len(scores))
+	for id, score := range scores {
+		if query.After != nil && !ranksAfter(score, id, query.After) {
+			continue
+		}
+		hits = append(hits, &model.UserSearchHit{UserId: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return bytes.Compare(hits[i].UserId[:], hits[j].UserId[:]) < 0
+	})
+	if query.Limit > 0 && int64(len(hits)) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+	return hits, nil
+}
+
+// Close stops the background flush and saves the index one last time.
+func (index *InvertedIndex) Close() error {
+	close(index.stop)
+	<-index.stopped
+	return index.flush()
+}
+
+func ranksAfter(score int64, id primitive.ObjectID, cursor *model.UserSearchCursor) bool {
+	if score != cursor.Score {
+		return score < cursor.Score
+	}
+	return bytes.Compare(id[:], cursor.Id[:]) > 0
+}
+
+// scoreToken returns the best score of every user the token matched.
+func (index *InvertedIndex) scoreToken(token string) map[primitive.ObjectID]int64 {
+	scores := map[primitive.ObjectID]int64{}
+	raise := func(id primitive.ObjectID, score int64) {
+		if score > scores[id] {
+			scores[id] = score
+		}
+	}
+
+	start := sort.SearchStrings(index.terms, token)
+	for i := start; i < len(index.terms) && strings.HasPrefix(index.terms[i], token); i++ {
+		for id, fields := range index.postings[index.terms[i]] {
+			switch {
+			case fields&inUsername != 0 && index.users[id].Username == token:
+				raise(id, scoreExactUsername)
+			case fields&inUsername != 0:
+				raise(id, scoreUsernameWord)
+			case fields&inName != 0:
+				raise(id, scoreNameWord)
+			default:
+				raise(id, scoreBioWord)
+			}
+		}
+	}
+
+	distance := maxTypos(token)
+	if distance == 0 {
+		return scores
+	}
+	for _, term := range index.terms {
+		if strings.HasPrefix(term, token) || !withinDistance(token, term, distance) {
+			continue
+		}
+		for id, fields := range index.postings[term] {
+			switch {
+			case fields&inUsername != 0:
+				raise(id, scoreFuzzyUsername)
+			case fields&inName != 0:
+				raise(id, scoreFuzzyName)
+			default:
+				raise(id, scoreFuzzyBioWord)
+			}
+		}
+	}
+	return scores
+}
+
+func maxTypos(token string) int {
+	length := len([]rune(token))
+	switch {
+	case length >= longFuzzyToken:
+		return 2
+	case length >= minFuzzyTokenLength:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// withinDistance reports whether the Levenshtein distance of a and b is at
+// most max, giving up on a row as soon as every entry in it exceeds max.
+func withinDistance(a string, b string, max int) bool {
+	source, target := []rune(a), []rune(b)
+	if diff := len(source) - len(target); diff > max || -diff > max {
+		return false
+	}
+	previous := make([]int, len(target)+1)
+	current := make([]int, len(target)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(source); i++ {
+		current[0] = i
+		best := current[0]
+		for j := 1; j <= len(target); j++ {
+			cost := 1
+			if source[i-1] == target[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if current[j] < best {
+				best = current[j]
+			}
+		}
+		if best > max {
+			return false
+		}
+		previous, current = current, previous
+	}
+	return previous[len(target)] <= max
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+	for _, value := range values[1:] {
+		if value < min {
+			min = value
+		}
+	}
+	return min
+}
+
+func (index *InvertedIndex) add(id primitive.ObjectID, user *indexedUser) {
+	index.users[id] = user
+	post := func(words []string, field termFields) {
+		for _, word := range words {
+			users, ok := index.postings[word]
+			if !ok {
+				users = map[primitive.ObjectID]termFields{}
+				index.postings[word] = users
+				index.terms = nil
+			}
+			users[id] |= field
+		}
+	}
+	post(user.UsernameWords, inUsername)
+	post(user.NameWords, inName)
+	post(user.BioWords, inBio)
+}
+
+func (index *InvertedIndex) unindex(id primitive.ObjectID) bool {
+	user, ok := index.users[id]
+	if !ok {
+		return false
+	}
+	delete(index.users, id)
+	for _, words := range [][]string{user.UsernameWords, user.NameWords, user.BioWords} {
+		for _, word := range words {
+			delete(index.postings[word], id)
+			if len(index.postings[word]) == 0 {
+				delete(index.postings, word)
+				index.terms = nil
+			}
+		}
+	}
+	return true
+}
+
+func (index *InvertedIndex) reset() {
+	index.users = map[primitive.ObjectID]*indexedUser{}
+	index.postings = map[string]map[primitive.ObjectID]termFields{}
+	index.terms = nil
+	index.checkpoint = nil
+}
+
+// sortTerms rebuilds the sorted term list that prefix lookups run on after
+// words were added or removed.
+func (index *InvertedIndex) sortTerms() {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	if index.terms != nil {
+		return
+	}
+	terms := make([]string, 0, len(index.postings))
+	for term := range index.postings {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	index.terms = terms
+}
+
+func (index *InvertedIndex) load() error {
+	file, err := os.Open(index.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var snapshot indexSnapshot
+	err = gob.NewDecoder(file).Decode(&snapshot)
+	if err != nil {
+		return err
+	}
+	if snapshot.Version != invertedIndexVersion {
+		return errors.New("index was written by another version")
+	}
+	for hex, user := range snapshot.Users {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return err
+		}
+		index.add(id, user)
+	}
+	index.checkpoint = snapshot.Checkpoint
+	return nil
+}
+
+// flush writes the index to a temporary file and renames it over the old one,
+// so that a crash midway leaves the previous snapshot intact.
+func (index *InvertedIndex) flush() error {
+	index.mutex.Lock()
+	if !index.dirty {
+		index.mutex.Unlock()
+		return nil
+	}
+	snapshot := indexSnapshot{
+		Version:    invertedIndexVersion,
+		Users:      make(map[string]*indexedUser, len(index.users)),
+		Checkpoint: index.checkpoint,
+	}
+	for id, user := range index.users {
+		snapshot.Users[id.Hex()] = user
+	}
+	index.dirty = false
+	index.mutex.Unlock()
+
+	err := index.write(&snapshot)
+	if err != nil {
+		index.mutex.Lock()
+		index.dirty = true
+		index.mutex.Unlock()
+	}
+	return err
+}
+
+func (index *InvertedIndex) write(snapshot *indexSnapshot) error {
+	temporary := index.path + ".tmp"
+	file, err := os.Create(temporary)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(file).Encode(snapshot)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	return os.Rename(temporary, index.path)
+}
+
+func (index *InvertedIndex) flushPeriodically() {
+	defer close(index.stopped)
+	ticker := time.NewTicker(invertedIndexFlush)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-index.stop:
+			return
+		case <-ticker.C:
+			if err := index.flush(); err != nil {
+				log.Printf("failed to save search index: %v", err)
+			}
+		}
+	}
+}
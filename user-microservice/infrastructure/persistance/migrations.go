@@ -0,0 +1,258 @@
+package persistance
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+	"user-microservice/model"
+)
+
+// migrations is the ordered list of every schema change. Append new ones at
+// the end with the next version; never change or reorder one that has shipped.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "rename fields to the explicit camelCase bson names",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return renameFields(ctx, db, fieldRenames, false)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return renameFields(ctx, db, fieldRenames, true)
+		},
+	},
+	{
+		Version:     2,
+		Description: "rename fields of embedded profile pictures and cover images",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return renameImageFields(ctx, db, false)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return renameImageFields(ctx, db, true)
+		},
+	},
+	{
+		Version:     3,
+		Description: "fill in user fields missing from old documents",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			users := db.Collection(COLLECTION)
+			defaults := []bson.M{
+				{"version": 0},
+				{"skills": bson.A{}},
+				{"interests": bson.A{}},
+			}
+			for _, value := range defaults {
+				for field := range value {
+					filter := bson.M{"$or": bson.A{bson.M{field: bson.M{"$exists": false}}, bson.M{field: nil}}}
+					if _, err := users.UpdateMany(ctx, filter, bson.M{"$set": value}); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		// The defaults are what the old code assumed for missing fields, so
+		// there is nothing to undo.
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return nil
+		},
+	},
+	{
+		Version:     4,
+		Description: "build the search fields of users",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			users := db.Collection(COLLECTION)
+			cursor, err := users.Find(ctx, bson.M{})
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+			for cursor.Next(ctx) {
+				var user model.User
+				if err := cursor.Decode(&user); err != nil {
+					return err
+				}
+				_, err := users.UpdateOne(ctx, bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"search": model.NewUserSearch(&user)}})
+				if err != nil {
+					return err
+				}
+			}
+			return cursor.Err()
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(COLLECTION).UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"search": ""}})
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "give sessions an expiry so that they are removed",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// Tokens live far shorter than a day, so no session still in use
+			// is removed early.
+			expiresAt := bson.M{"$add": bson.A{"$issuedAt", legacySessionLifetime.Milliseconds()}}
+			_, err := db.Collection("sessions").UpdateMany(ctx,
+				bson.M{"expiresAt": bson.M{"$exists": false}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.M{"expiresAt": expiresAt}}}})
+			return err
+		},
+		// Old code ignores the expiry, so there is nothing to undo.
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return nil
+		},
+	},
+}
+
+const legacySessionLifetime = 24 * time.Hour
+
+// fieldRenames maps the names the driver derived from Go field names before
+// the models had bson tags to the names in the tags, per collection.
+var fieldRenames = map[string]map[string]string{
+	COLLECTION: {
+		"phonenumber":    "phoneNumber",
+		"birthdate":      "birthDate",
+		"tfasecret":      "tfaSecret",
+		"tfaenabled":     "tfaEnabled",
+		"apitoken":       "apiToken",
+		"profilepicture": "profilePicture",
+		"coverimage":     "coverImage",
+	},
+	"experiences": {
+		"userid":         "userId",
+		"startdate":      "startDate",
+		"enddate":        "endDate",
+		"experiencetype": "experienceType",
+		"employmenttype": "employmentType",
+	},
+	"educations": {
+		"userid":       "userId",
+		"fieldofstudy": "fieldOfStudy",
+		"startdate":    "startDate",
+		"enddate":      "endDate",
+	},
+	"certifications": {
+		"userid":         "userId",
+		"credentialid":   "credentialId",
+		"credentialurl":  "credentialUrl",
+		"issuedate":      "issueDate",
+		"expirationdate": "expirationDate",
+	},
+	"languages": {
+		"userid": "userId",
+	},
+	"projects": {
+		"userid":    "userId",
+		"startdate": "startDate",
+		"enddate":   "endDate",
+	},
+	"passwordRecoveryRequests": {
+		"userid":  "userId",
+		"validto": "validTo",
+	},
+	"passwordlessLogins": {
+		"userid":       "userId",
+		"creationtime": "creationTime",
+	},
+	"sessions": {
+		"userid":    "userId",
+		"tokenhash": "tokenHash",
+		"issuedat":  "issuedAt",
+	},
+	"loginRecords": {
+		"userid":     "userId",
+		"useragent":  "userAgent",
+		"newdevice":  "newDevice",
+		"newnetwork": "newNetwork",
+	},
+	"knownDevices": {
+		"userid":    "userId",
+		"useragent": "userAgent",
+		"lastip":    "lastIp",
+		"firstseen": "firstSeen",
+		"lastseen":  "lastSeen",
+	},
+	"auditEvents": {
+		"userid":   "userId",
+		"prevhash": "prevHash",
+	},
+	"auditCheckpoints": {
+		"publickey": "publicKey",
+	},
+	"endorsements": {
+		"userid":     "userId",
+		"skillid":    "skillId",
+		"endorserid": "endorserId",
+	},
+	"profileCompleteness": {
+		"userid":     "userId",
+		"computedat": "computedAt",
+	},
+	"usernameHistory": {
+		"userid":        "userId",
+		"oldusername":   "oldUsername",
+		"newusername":   "newUsername",
+		"reserveduntil": "reservedUntil",
+	},
+	"emailChanges": {
+		"userid":           "userId",
+		"oldemail":         "oldEmail",
+		"newemail":         "newEmail",
+		"confirmtokenhash": "confirmTokenHash",
+		"validto":          "validTo",
+		"revokesessions":   "revokeSessions",
+		"reverttokenhash":  "revertTokenHash",
+		"revertvalidto":    "revertValidTo",
+	},
+}
+
+// renameFields renames the fields of every collection in renames, or renames
+// them back when reverse is set. The indexes of those collections are dropped
+// because they point at the old names; EnsureIndexes builds them again on
+// the next start.
+func renameFields(ctx context.Context, db *mongo.Database, renames map[string]map[string]string, reverse bool) error {
+	for collection, fields := range renames {
+		rename := bson.M{}
+		for from, to := range fields {
+			if reverse {
+				from, to = to, from
+			}
+			rename[from] = to
+		}
+		if err := dropIndexes(ctx, db.Collection(collection)); err != nil {
+			return err
+		}
+		if _, err := db.Collection(collection).UpdateMany(ctx, bson.M{}, bson.M{"$rename": rename}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renameImageFields(ctx context.Context, db *mongo.Database, reverse bool) error {
+	users := db.Collection(COLLECTION)
+	for _, image := range []string{"profilePicture", "coverImage"} {
+		for from, to := range map[string]string{"contenttype": "contentType", "uploadedat": "uploadedAt"} {
+			if reverse {
+				from, to = to, from
+			}
+			// Users without an image hold null, which $rename cannot
+			// traverse, so only documents that have the old field are touched.
+			filter := bson.M{image + "." + from: bson.M{"$exists": true}}
+			update := bson.M{"$rename": bson.M{image + "." + from: image + "." + to}}
+			if _, err := users.UpdateMany(ctx, filter, update); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func dropIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().DropAll(ctx)
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) && commandError.Name == "NamespaceNotFound" {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,297 @@
+package persistance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	MIGRATIONS_COLLECTION = "schema_migrations"
+	migrationLockId       = "lock"
+	migrationLockTTL      = 10 * time.Minute
+	migrationLockRenew    = time.Minute
+	migrationLockWait     = 5 * time.Minute
+	migrationLockPoll     = 2 * time.Second
+)
+
+// Migration changes the schema of the users database. Up and Down must be
+// idempotent: a replica may die halfway through, and the next run starts the
+// same migration again from the beginning.
+type Migration struct {
+	Version     int64
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+type MigrationStatus struct {
+	Version     int64
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+type appliedMigration struct {
+	Version     int64     `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+type migrationLock struct {
+	Id        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// Migrator applies migrations in version order and records each applied one
+// in schema_migrations. The same collection holds a lock document so that only
+// one replica migrates at a time.
+type Migrator struct {
+	db         *mongo.Database
+	migrations *mongo.Collection
+	list       []Migration
+	owner      string
+}
+
+func NewMigrator(client *mongo.Client) *Migrator {
+	db := client.Database(DATABASE)
+	hostname, _ := os.Hostname()
+	return &Migrator{
+		db:         db,
+		migrations: db.Collection(MIGRATIONS_COLLECTION),
+		list:       migrations,
+		owner:      fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano()),
+	}
+}
+
+// Up applies every pending migration up to and including target, or all of
+// them when target is 0, and returns the ones it applied.
+func (migrator *Migrator) Up(ctx context.Context, target int64) ([]Migration, error) {
+	if err := validateMigrations(migrator.list); err != nil {
+		return nil, err
+	}
+	var done []Migration
+	err := migrator.withLock(ctx, func(ctx context.Context) error {
+		applied, err := migrator.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrator.list {
+			if target > 0 && migration.Version > target {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			log.Printf("applying migration %d: %s", migration.Version, migration.Description)
+			if err := migration.Up(ctx, migrator.db); err != nil {
+				return fmt.Errorf("migration %d failed: %w", migration.Version, err)
+			}
+			_, err := migrator.migrations.InsertOne(ctx, appliedMigration{
+				Version:     migration.Version,
+				Description: migration.Description,
+				AppliedAt:   time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the given number of most recently applied migrations and
+// returns the ones it rolled back.
+func (migrator *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if err := validateMigrations(migrator.list); err != nil {
+		return nil, err
+	}
+	var done []Migration
+	err := migrator.withLock(ctx, func(ctx context.Context) error {
+		applied, err := migrator.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(migrator.list) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := migrator.list[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			log.Printf("rolling back migration %d: %s", migration.Version, migration.Description)
+			if err := migration.Down(ctx, migrator.db); err != nil {
+				return fmt.Errorf("rollback of migration %d failed: %w", migration.Version, err)
+			}
+			_, err := migrator.migrations.DeleteOne(ctx, bson.M{"_id": migration.Version})
+			if err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every known migration and whether it is applied. Versions
+// recorded in the database but unknown to this build are listed too, so that
+// running an older build against a newer schema is easy to spot.
+func (migrator *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	applied, err := migrator.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []*MigrationStatus
+	for _, migration := range migrator.list {
+		status := &MigrationStatus{Version: migration.Version, Description: migration.Description}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range applied {
+		statuses = append(statuses, &MigrationStatus{
+			Version:     record.Version,
+			Description: record.Description + " (unknown to this build)",
+			Applied:     true,
+			AppliedAt:   record.AppliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+func (migrator *Migrator) applied(ctx context.Context) (map[int64]*appliedMigration, error) {
+	cursor, err := migrator.migrations.Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []*appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := map[int64]*appliedMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// withLock runs fn while holding the migration lock. A lock left behind by a
+// replica that died is taken over once it expires, so the lock is extended
+// while fn runs; if it is lost anyway, the context of fn is cancelled and
+// withLock fails.
+func (migrator *Migrator) withLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	deadline := time.Now().Add(migrationLockWait)
+	for {
+		acquired, err := migrator.lock(ctx)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the migration lock")
+		}
+		log.Println("waiting for another replica to finish migrating")
+		time.Sleep(migrationLockPoll)
+	}
+	defer func() {
+		_, err := migrator.migrations.DeleteOne(context.Background(), bson.M{"_id": migrationLockId, "owner": migrator.owner})
+		if err != nil {
+			log.Printf("failed to release the migration lock: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lost := make(chan struct{})
+	go migrator.heartbeat(ctx, cancel, lost)
+	err := fn(ctx)
+	select {
+	case <-lost:
+		if err == nil {
+			err = context.Canceled
+		}
+		return fmt.Errorf("lost the migration lock while migrating: %w", err)
+	default:
+		return err
+	}
+}
+
+// heartbeat extends the lock every migrationLockRenew until ctx is done. When
+// another replica took the lock over, or the lock could not be extended
+// before it expired, it closes lost and cancels the migration.
+func (migrator *Migrator) heartbeat(ctx context.Context, cancel context.CancelFunc, lost chan struct{}) {
+	ticker := time.NewTicker(migrationLockRenew)
+	defer ticker.Stop()
+	expiresAt := time.Now().Add(migrationLockTTL)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		next := time.Now().Add(migrationLockTTL)
+		filter := bson.M{"_id": migrationLockId, "owner": migrator.owner}
+		result, err := migrator.migrations.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"expiresAt": next}})
+		switch {
+		case err == nil && result.MatchedCount == 1:
+			expiresAt = next
+			continue
+		case err == nil:
+			log.Println("the migration lock was taken over by another replica")
+		case time.Now().Before(expiresAt):
+			log.Printf("failed to extend the migration lock: %v", err)
+			continue
+		default:
+			log.Printf("the migration lock expired, it could not be extended: %v", err)
+		}
+		close(lost)
+		cancel()
+		return
+	}
+}
+
+func (migrator *Migrator) lock(ctx context.Context) (bool, error) {
+	now := time.Now()
+	lock := migrationLock{Id: migrationLockId, Owner: migrator.owner, ExpiresAt: now.Add(migrationLockTTL)}
+	_, err := migrator.migrations.InsertOne(ctx, lock)
+	if err == nil {
+		return true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+	filter := bson.M{"_id": migrationLockId, "expiresAt": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{"owner": lock.Owner, "expiresAt": lock.ExpiresAt}}
+	err = migrator.migrations.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate()).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func validateMigrations(list []Migration) error {
+	for i, migration := range list {
+		if migration.Version <= 0 || (i > 0 && migration.Version <= list[i-1].Version) {
+			return fmt.Errorf("migration versions must be positive and increasing, got %d", migration.Version)
+		}
+		if migration.Up == nil || migration.Down == nil {
+			return fmt.Errorf("migration %d must define both Up and Down", migration.Version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package persistance
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"user-microservice/model"
+)
+
+// MongoSearchIndex searches the search fields that the store keeps on every
+// user document. Those are written together with the user, so there is
+// nothing to index, reset or catch up on.
+type MongoSearchIndex struct {
+	store model.UserStore
+}
+
+func NewMongoSearchIndex(store model.UserStore) model.SearchIndex {
+	return &MongoSearchIndex{store: store}
+}
+
+func (index *MongoSearchIndex) Search(ctx context.Context, query *model.UserSearchQuery) ([]*model.UserSearchHit, error) {
+	return index.store.SearchUsers(ctx, query)
+}
+
+func (index *MongoSearchIndex) Index(ctx context.Context, user *model.User) error {
+	return nil
+}
+
+func (index *MongoSearchIndex) Remove(ctx context.Context, userId primitive.ObjectID) error {
+	return nil
+}
+
+func (index *MongoSearchIndex) Reset(ctx context.Context) error {
+	return nil
+}
+
+func (index *MongoSearchIndex) Checkpoint() []byte {
+	return nil
+}
+
+func (index *MongoSearchIndex) SetCheckpoint(position []byte) {
+}
+
+func (index *MongoSearchIndex) Close() error {
+	return nil
+}
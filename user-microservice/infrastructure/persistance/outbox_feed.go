@@ -0,0 +1,39 @@
+package persistance
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"user-microservice/model"
+)
+
+// OutboxFeed watches the outbox collection for inserted events through a
+// Mongo change stream. Like UserChangeFeed it needs a replica set.
+type OutboxFeed struct {
+	outbox *mongo.Collection
+}
+
+func NewOutboxFeed(client *mongo.Client) model.OutboxFeed {
+	return &OutboxFeed{outbox: client.Database(DATABASE).Collection("outbox")}
+}
+
+func (feed *OutboxFeed) Follow(ctx context.Context, added func()) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": "insert"}}},
+		{{Key: "$project", Value: bson.M{"_id": 1}}},
+	}
+	stream, err := feed.outbox.Watch(ctx, pipeline)
+	if err != nil {
+		return feedError(err)
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		added()
+	}
+	if errors.Is(stream.Err(), context.Canceled) {
+		return nil
+	}
+	return feedError(stream.Err())
+}
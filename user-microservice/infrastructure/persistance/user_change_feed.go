@@ -0,0 +1,111 @@
+package persistance
+
+import (
+	"context"
+	"errors"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"user-microservice/model"
+)
+
+// Server error codes of change streams.
+const (
+	changeStreamNotSupported     = 40573
+	changeStreamHistoryLost      = 286
+	changeStreamFatalError       = 280
+	changeStreamResumeTokenError = 260
+)
+
+// UserChangeFeed reports writes to the users collection through a Mongo
+// change stream. Positions are resume tokens. Change streams need a replica
+// set; a standalone server reports model.ErrFeedUnsupported.
+type UserChangeFeed struct {
+	users *mongo.Collection
+}
+
+func NewUserChangeFeed(client *mongo.Client) model.UserChangeFeed {
+	return &UserChangeFeed{users: client.Database(DATABASE).Collection(COLLECTION)}
+}
+
+func (feed *UserChangeFeed) Position(ctx context.Context) ([]byte, error) {
+	span := tracer.StartSpanFromContext(ctx, "ChangeFeedPosition")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	stream, err := feed.users.Watch(ctx, userChangesPipeline())
+	if err != nil {
+		return nil, feedError(err)
+	}
+	defer stream.Close(ctx)
+	// The first batch is enough for the server to report where the stream
+	// stands, even when it is empty.
+	stream.TryNext(ctx)
+	if err := stream.Err(); err != nil {
+		return nil, feedError(err)
+	}
+	return stream.ResumeToken(), nil
+}
+
+func (feed *UserChangeFeed) Follow(ctx context.Context, position []byte, apply func(change *model.UserChange) error) error {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if position != nil {
+		opts.SetResumeAfter(bson.Raw(position))
+	}
+	stream, err := feed.users.Watch(ctx, userChangesPipeline(), opts)
+	if err != nil {
+		return feedError(err)
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var event struct {
+			OperationType string `bson:"operationType"`
+			DocumentKey   struct {
+				Id primitive.ObjectID `bson:"_id"`
+			} `bson:"documentKey"`
+			FullDocument *model.User `bson:"fullDocument"`
+		}
+		err = stream.Decode(&event)
+		if err != nil {
+			return err
+		}
+		change := &model.UserChange{UserId: event.DocumentKey.Id, Position: stream.ResumeToken()}
+		// An update looked up after a later delete has no document either.
+		if event.OperationType != "delete" {
+			change.User = event.FullDocument
+		}
+		err = apply(change)
+		if err != nil {
+			return err
+		}
+	}
+	if errors.Is(stream.Err(), context.Canceled) {
+		return nil
+	}
+	return feedError(stream.Err())
+}
+
+func userChangesPipeline() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}}},
+	}
+}
+
+func feedError(err error) error {
+	var serverError mongo.ServerError
+	if !errors.As(err, &serverError) {
+		return err
+	}
+	switch {
+	case serverError.HasErrorCode(changeStreamNotSupported):
+		return model.ErrFeedUnsupported
+	case serverError.HasErrorCode(changeStreamHistoryLost),
+		serverError.HasErrorCode(changeStreamFatalError),
+		serverError.HasErrorCode(changeStreamResumeTokenError):
+		return model.ErrFeedPositionLost
+	}
+	return err
+}
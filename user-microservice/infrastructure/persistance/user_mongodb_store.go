@@ -2,10 +2,16 @@ package persistance
 
 import (
 	"context"
+	"errors"
 	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+	"log"
+	"regexp"
+	"sync"
 	"time"
 	"user-microservice/model"
 )
@@ -18,27 +24,85 @@ const (
 type UserMongoDBStore struct {
 	users                    *mongo.Collection
 	experiences              *mongo.Collection
+	educations               *mongo.Collection
+	certifications           *mongo.Collection
+	languages                *mongo.Collection
+	projects                 *mongo.Collection
 	passwordRecoveryRequests *mongo.Collection
 	passwordlessLogins       *mongo.Collection
+	auditEvents              *mongo.Collection
+	auditCheckpoints         *mongo.Collection
+	sessions                 *mongo.Collection
+	loginRecords             *mongo.Collection
+	knownDevices             *mongo.Collection
+	tags                     *mongo.Collection
+	endorsements             *mongo.Collection
+	profileCompleteness      *mongo.Collection
+	usernameHistory          *mongo.Collection
+	emailChanges             *mongo.Collection
+	outbox                   *mongo.Collection
+	leases                   *mongo.Collection
+	userDeletions            *mongo.Collection
+	privacySyncs             *mongo.Collection
+	client                   *mongo.Client
+	transactionsLock         sync.Mutex
+	transactions             *bool
 }
 
 func NewUserMongoDBStore(client *mongo.Client) model.UserStore {
 	users := client.Database(DATABASE).Collection(COLLECTION)
 	experiences := client.Database(DATABASE).Collection("experiences")
+	educations := client.Database(DATABASE).Collection("educations")
+	certifications := client.Database(DATABASE).Collection("certifications")
+	languages := client.Database(DATABASE).Collection("languages")
+	projects := client.Database(DATABASE).Collection("projects")
 	passwordRecoveryRequests := client.Database(DATABASE).Collection("passwordRecoveryRequests")
 	passwordlessLogins := client.Database(DATABASE).Collection("passwordlessLogins")
+	auditEvents := client.Database(DATABASE).Collection("auditEvents")
+	auditCheckpoints := client.Database(DATABASE).Collection("auditCheckpoints")
+	sessions := client.Database(DATABASE).Collection("sessions")
+	loginRecords := client.Database(DATABASE).Collection("loginRecords")
+	knownDevices := client.Database(DATABASE).Collection("knownDevices")
+	tags := client.Database(DATABASE).Collection("tags")
+	endorsements := client.Database(DATABASE).Collection("endorsements")
+	profileCompleteness := client.Database(DATABASE).Collection("profileCompleteness")
+	usernameHistory := client.Database(DATABASE).Collection("usernameHistory")
+	emailChanges := client.Database(DATABASE).Collection("emailChanges")
+	outbox := client.Database(DATABASE).Collection("outbox")
+	leases := client.Database(DATABASE).Collection("leases")
+	userDeletions := client.Database(DATABASE).Collection("userDeletions")
+	privacySyncs := client.Database(DATABASE).Collection("privacySyncs")
 	return &UserMongoDBStore{
 		users:                    users,
 		experiences:              experiences,
+		educations:               educations,
+		certifications:           certifications,
+		languages:                languages,
+		projects:                 projects,
 		passwordRecoveryRequests: passwordRecoveryRequests,
 		passwordlessLogins:       passwordlessLogins,
+		auditEvents:              auditEvents,
+		auditCheckpoints:         auditCheckpoints,
+		sessions:                 sessions,
+		loginRecords:             loginRecords,
+		knownDevices:             knownDevices,
+		tags:                     tags,
+		endorsements:             endorsements,
+		profileCompleteness:      profileCompleteness,
+		usernameHistory:          usernameHistory,
+		emailChanges:             emailChanges,
+		outbox:                   outbox,
+		leases:                   leases,
+		userDeletions:            userDeletions,
+		privacySyncs:             privacySyncs,
+		client:                   client,
 	}
 }
 
 func (store *UserMongoDBStore) Get(ctx context.Context, id primitive.ObjectID) (user *model.User, err error) {
 	span := tracer.StartSpanFromContext(ctx, "Get")
 	defer span.Finish()
-	ctx = tracer.ContextWithSpan(context.Background(), span)
+	ctx = tracer.ContextWithSpan(ctx, span)
 
 	filter := bson.M{"_id": id}
 	return store.filterOne(ctx, filter)
@@ -50,7 +114,7 @@ func (store *UserMongoDBStore) GetByEmail(ctx context.Context, email string) (us
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
 	filter := bson.M{"email": email}
-	return store.filterOne(ctx, filter)
+	return store.filterOneCaseInsensitive(ctx, filter)
 }
 
 func (store *UserMongoDBStore) GetByUsername(ctx context.Context, username string) (user *model.User, err error) {
@@ -59,7 +123,7 @@ func (store *UserMongoDBStore) GetByUsername(ctx context.Context, username strin
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
 	filter := bson.M{"username": username}
-	return store.filterOne(ctx, filter)
+	return store.filterOneCaseInsensitive(ctx, filter)
 }
 
 func (store *UserMongoDBStore) GetByConfirmationId(ctx context.Context, confirmationId string) (user *model.User, err error) {
@@ -80,11 +144,23 @@ func (store *UserMongoDBStore) GetAll(ctx context.Context) ([]*model.User, error
 	return store.filter(ctx, filter)
 }
 
+// GetByIds returns the users with the given ids in no particular order,
+// leaving out ids that do not exist.
+func (store *UserMongoDBStore) GetByIds(ctx context.Context, ids []primitive.ObjectID) ([]*model.User, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetByIds")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": bson.M{"$in": ids}}
+	return store.filter(ctx, filter)
+}
+
 func (store *UserMongoDBStore) Create(ctx context.Context, user *model.User) (*model.User, error) {
 	span := tracer.StartSpanFromContext(ctx, "Create")
 	defer span.Finish()
-	ctx = tracer.ContextWithSpan(context.Background(), span)
+	ctx = tracer.ContextWithSpan(ctx, span)
 
+	user.Search = model.NewUserSearch(user)
 	result, err := store.users.InsertOne(ctx, user)
 	if err != nil {
 		return nil, err
@@ -93,28 +169,202 @@ func (store *UserMongoDBStore) Create(ctx context.Context, user *model.User) (*m
 	return user, nil
 }
 
+// Update replaces the user only if it is still at the version it was read
+// at and bumps the version, otherwise it returns ErrVersionConflict. Users
+// stored before versioning count as version 0.
 func (store *UserMongoDBStore) Update(ctx context.Context, userId primitive.ObjectID, user *model.User) (*model.User, error) {
 	span := tracer.StartSpanFromContext(ctx, "Update")
 	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"_id": userId, "version": user.Version}
+	if user.Version == 0 {
+		filter = bson.M{"_id": userId, "$or": bson.A{bson.M{"version": 0}, bson.M{"version": bson.M{"$exists": false}}}}
+	}
+	user.Id = userId
+	user.Version++
+	user.Search = model.NewUserSearch(user)
+	result, err := store.users.UpdateOne(ctx, filter, bson.M{"$set": user})
+	if err != nil {
+		user.Version--
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		user.Version--
+		_, err = store.Get(ctx, userId)
+		if err == nil {
+			err = model.ErrVersionConflict
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpdateProfile writes the profile fields only if the user is still at the
+// given version and bumps the version. Users stored before versioning count
+// as version 0.
+func (store *UserMongoDBStore) UpdateProfile(ctx context.Context, userId primitive.ObjectID, version int64, profile *model.UserProfile) (user *model.User, err error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateProfile")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": userId, "version": version}
+	if version == 0 {
+		filter = bson.M{"_id": userId, "$or": bson.A{bson.M{"version": 0}, bson.M{"version": bson.M{"$exists": false}}}}
+	}
+	update := bson.M{"$set": profile, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = store.users.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		_, err = store.Get(ctx, userId)
+		if err == nil {
+			err = model.ErrVersionConflict
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	// A write that got in between rebuilt the search fields itself, so the
+	// version check only keeps this one from overwriting them.
+	user.Search = model.NewUserSearch(user)
+	_, err = store.users.UpdateOne(ctx, bson.M{"_id": userId, "version": user.Version}, bson.M{"$set": bson.M{"search": user.Search}})
+	return user, err
+}
+
+// AddUserTags appends the ids that the user does not have yet, in the given
+// order, and returns the ones that were added.
+func (store *UserMongoDBStore) AddUserTags(ctx context.Context, userId primitive.ObjectID, kind model.TagKind, ids []string) ([]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "AddUserTags")
+	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	updatedUser := bson.M{
-		"$set": user,
+	update := bson.M{
+		"$addToSet": bson.M{userTagField(kind): bson.M{"$each": ids}},
+		"$inc":      bson.M{"version": 1},
+	}
+	before, err := store.updateTags(ctx, userId, update)
+	if err != nil {
+		return nil, err
+	}
+	var added []string
+	for _, id := range ids {
+		if !slices.Contains(*tagsOf(before, kind), id) && !slices.Contains(added, id) {
+			added = append(added, id)
+		}
 	}
-	filter := bson.M{"_id": userId}
-	_, err := store.users.UpdateOne(ctx, filter, updatedUser)
+	return added, nil
+}
 
+// RemoveUserTags pulls the ids from the user and returns the ones the user
+// actually had.
+func (store *UserMongoDBStore) RemoveUserTags(ctx context.Context, userId primitive.ObjectID, kind model.TagKind, ids []string) ([]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "RemoveUserTags")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	update := bson.M{
+		"$pull": bson.M{userTagField(kind): bson.M{"$in": ids}},
+		"$inc":  bson.M{"version": 1},
+	}
+	before, err := store.updateTags(ctx, userId, update)
 	if err != nil {
 		return nil, err
 	}
-	user.Id = userId
-	return user, nil
+	var removed []string
+	for _, id := range *tagsOf(before, kind) {
+		if slices.Contains(ids, id) {
+			removed = append(removed, id)
+		}
+	}
+	return removed, nil
+}
+
+// ReorderUserTags stores the ids in the given order. It only succeeds when
+// the user has exactly these ids, otherwise ErrVersionConflict is returned.
+func (store *UserMongoDBStore) ReorderUserTags(ctx context.Context, userId primitive.ObjectID, kind model.TagKind, ids []string) error {
+	span := tracer.StartSpanFromContext(ctx, "ReorderUserTags")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	field := userTagField(kind)
+	filter := bson.M{"_id": userId, field: bson.M{"$all": ids, "$size": len(ids)}}
+	if len(ids) == 0 {
+		filter = bson.M{"_id": userId, field: bson.M{"$size": 0}}
+	}
+	update := bson.M{"$set": bson.M{field: ids}, "$inc": bson.M{"version": 1}}
+	result, err := store.users.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		_, err = store.Get(ctx, userId)
+		if err == nil {
+			err = model.ErrVersionConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// ChangeEmail moves a user from one address to another. It fails with
+// mongo.ErrNoDocuments when the user no longer has the from address.
+func (store *UserMongoDBStore) ChangeEmail(ctx context.Context, userId primitive.ObjectID, from string, to string) (user *model.User, err error) {
+	span := tracer.StartSpanFromContext(ctx, "ChangeEmail")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": userId, "email": from}
+	update := bson.M{"$set": bson.M{"email": to}, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = store.users.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+	return
+}
+
+// SetUserImage replaces or, when image is nil, removes a picture of the user
+// and returns the one it replaced.
+func (store *UserMongoDBStore) SetUserImage(ctx context.Context, userId primitive.ObjectID, kind model.ImageKind, image *model.Image) (*model.Image, error) {
+	span := tracer.StartSpanFromContext(ctx, "SetUserImage")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	field := "profilePicture"
+	if kind == model.COVER_IMAGE {
+		field = "coverImage"
+	}
+	update := bson.M{"$set": bson.M{field: image}, "$inc": bson.M{"version": 1}}
+	if image == nil {
+		update = bson.M{"$unset": bson.M{field: ""}, "$inc": bson.M{"version": 1}}
+	}
+	var before *model.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := store.users.FindOneAndUpdate(ctx, bson.M{"_id": userId}, update, opts).Decode(&before)
+	if err != nil {
+		return nil, err
+	}
+	if kind == model.COVER_IMAGE {
+		return before.CoverImage, nil
+	}
+	return before.ProfilePicture, nil
+}
+
+func (store *UserMongoDBStore) updateTags(ctx context.Context, userId primitive.ObjectID, update bson.M) (before *model.User, err error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err = store.users.FindOneAndUpdate(ctx, bson.M{"_id": userId}, update, opts).Decode(&before)
+	return
+}
+
+func tagsOf(user *model.User, kind model.TagKind) *[]string {
+	if kind == model.INTEREST {
+		return &user.Interests
+	}
+	return &user.Skills
 }
 
 func (store *UserMongoDBStore) Delete(ctx context.Context, id primitive.ObjectID) error {
 	span := tracer.StartSpanFromContext(ctx, "Delete")
 	defer span.Finish()
-	ctx = tracer.ContextWithSpan(context.Background(), span)
+	ctx = tracer.ContextWithSpan(ctx, span)
 
 	filter := bson.M{"_id": id}
 	_, err := store.users.DeleteOne(ctx, filter)
@@ -149,13 +399,21 @@ func (store *UserMongoDBStore) filter(ctx context.Context, filter interface{}) (
 func (store *UserMongoDBStore) filterOne(ctx context.Context, filter interface{}) (product *model.User, err error) {
 	span := tracer.StartSpanFromContext(ctx, "filterOne")
 	defer span.Finish()
-	ctx = tracer.ContextWithSpan(context.Background(), span)
+	ctx = tracer.ContextWithSpan(ctx, span)
 
 	result := store.users.FindOne(ctx, filter)
 	err = result.Decode(&product)
 	return
 }
 
+// filterOneCaseInsensitive matches with the collation of the unique username
+// and email indexes.
+func (store *UserMongoDBStore) filterOneCaseInsensitive(ctx context.Context, filter interface{}) (user *model.User, err error) {
+	opts := options.FindOne().SetCollation(caseInsensitive)
+	err = store.users.FindOne(ctx, filter, opts).Decode(&user)
+	return
+}
+
 func decode(ctx context.Context, cursor *mongo.Cursor) (users []*model.User, err error) {
 	span := tracer.StartSpanFromContext(ctx, "decode")
 	defer span.Finish()
@@ -190,13 +448,315 @@ func decodeExperience(ctx context.Context, cursor *mongo.Cursor) (experiences []
 	return
 }
 
-func (store *UserMongoDBStore) GetAllWithoutAdmins(ctx context.Context) ([]*model.User, error) {
-	span := tracer.StartSpanFromContext(ctx, "GetAll")
+// SearchUsers finds users that are not admins and match every token, either by
+// a word prefix or, for tokens of three letters or more, by the trigrams of the
+// username, name and surname. Each token is scored by where it matched (exact
+// username, username prefix, name prefix, anywhere in the username or name,
+// bio word) and a hit is ranked by the sum.
+func (store *UserMongoDBStore) SearchUsers(ctx context.Context, query *model.UserSearchQuery) ([]*model.UserSearchHit, error) {
+	span := tracer.StartSpanFromContext(ctx, "SearchUsers")
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
+	var tokenMatches, tokenScores bson.A
+	for _, token := range query.Tokens {
+		match := bson.A{bson.M{"search.words": bson.M{"$regex": "^" + regexp.QuoteMeta(token)}}}
+		if grams := model.SearchGrams(token); len(grams) > 0 {
+			match = append(match, bson.M{"search.grams": bson.M{"$all": grams}})
+		}
+		tokenMatches = append(tokenMatches, bson.M{"$or": match})
+		tokenScores = append(tokenScores, searchTokenScore(token))
+	}
 	filter := bson.M{"role": model.USER}
-	return store.filter(ctx, filter)
+	if len(query.ExcludeIds) > 0 {
+		filter["_id"] = bson.M{"$nin": query.ExcludeIds}
+	}
+	// Without tokens every user matches with a score of 0.
+	pipeline := mongo.Pipeline{}
+	if len(query.Tokens) > 0 {
+		filter["$and"] = tokenMatches
+		pipeline = append(pipeline,
+			bson.D{{Key: "$match", Value: filter}},
+			bson.D{{Key: "$addFields", Value: bson.M{"tokenScores": tokenScores}}},
+			// Trigrams match when all of them occur anywhere in the name, so
+			// a token they found may still not be part of it.
+			bson.D{{Key: "$match", Value: bson.M{"$expr": bson.M{"$gt": bson.A{bson.M{"$min": "$tokenScores"}, 0}}}}},
+			bson.D{{Key: "$addFields", Value: bson.M{"score": bson.M{"$sum": "$tokenScores"}}}},
+		)
+	} else {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$match", Value: filter}},
+			bson.D{{Key: "$addFields", Value: bson.M{"score": 0}}},
+		)
+	}
+	if query.After != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"score": bson.M{"$lt": query.After.Score}},
+			bson.M{"score": query.After.Score, "_id": bson.M{"$gt": query.After.Id}},
+		}}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: query.Limit}},
+	)
+
+	cursor, err := store.users.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var hits []*model.UserSearchHit
+	for cursor.Next(ctx) {
+		var hit struct {
+			model.User `bson:",inline"`
+			Score      int64 `bson:"score"`
+		}
+		err = cursor.Decode(&hit)
+		if err != nil {
+			return nil, err
+		}
+		user := hit.User
+		hits = append(hits, &model.UserSearchHit{UserId: user.Id, User: &user, Score: hit.Score})
+	}
+	return hits, cursor.Err()
+}
+
+// SearchPeople matches public profiles against the query. Work experience is
+// matched first, in the experiences collection, and narrows the users to the
+// ones it found. The page and the facets then come from a single aggregation
+// so that they count the same matches.
+func (store *UserMongoDBStore) SearchPeople(ctx context.Context, query *model.PeopleSearchQuery) (*model.PeopleSearchResult, error) {
+	span := tracer.StartSpanFromContext(ctx, "SearchPeople")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"role": model.USER, "private": false}
+	var conditions bson.A
+	if len(query.ExcludeIds) > 0 {
+		conditions = append(conditions, bson.M{"_id": bson.M{"$nin": query.ExcludeIds}})
+	}
+	if query.HasExperienceFilter() {
+		userIds, err := store.experiencedUserIds(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, bson.M{"_id": bson.M{"$in": userIds}})
+	}
+	if len(query.Skills) > 0 {
+		conditions = append(conditions, tagCondition("skills", query.Skills, query.AllSkills))
+	}
+	if len(query.Interests) > 0 {
+		conditions = append(conditions, tagCondition("interests", query.Interests, query.AllInterests))
+	}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+
+	if query.After != nil {
+		// Facets cover every match and do not change between pages, so only
+		// the first page pays for them.
+		filter["_id"] = bson.M{"$gt": *query.After}
+		cursor, err := store.users.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}).SetLimit(query.Limit))
+		if err != nil {
+			return nil, err
+		}
+		defer cursor.Close(ctx)
+		users, err := decode(ctx, cursor)
+		if err != nil {
+			return nil, err
+		}
+		return &model.PeopleSearchResult{Users: users}, nil
+	}
+
+	facets := bson.M{
+		"users": bson.A{bson.M{"$sort": bson.M{"_id": 1}}, bson.M{"$limit": query.Limit}},
+		"total": bson.A{bson.M{"$count": "count"}},
+		"skills": bson.A{
+			bson.M{"$unwind": "$skills"},
+			bson.M{"$group": bson.M{"_id": "$skills", "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": query.FacetLimit},
+		},
+		"companies": bson.A{
+			bson.M{"$lookup": bson.M{
+				"from": "experiences",
+				"let":  bson.M{"userId": bson.M{"$toString": "$_id"}},
+				"pipeline": bson.A{
+					bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$userId", "$$userId"}}, "experienceType": model.WORK}},
+					bson.M{"$project": bson.M{"name": 1}},
+				},
+				"as": "experiences",
+			}},
+			bson.M{"$unwind": "$experiences"},
+			// Spellings that differ only in case count as one company,
+			// shown as the first spelling found.
+			bson.M{"$group": bson.M{
+				"_id":   bson.M{"$toLower": "$experiences.name"},
+				"name":  bson.M{"$first": "$experiences.name"},
+				"users": bson.M{"$addToSet": "$_id"},
+			}},
+			bson.M{"$project": bson.M{"_id": "$name", "count": bson.M{"$size": "$users"}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": query.FacetLimit},
+		},
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: facets}},
+	}
+	cursor, err := store.users.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Users     []*model.User       `bson:"users"`
+		Total     []*model.FacetCount `bson:"total"`
+		Skills    []*model.FacetCount `bson:"skills"`
+		Companies []*model.FacetCount `bson:"companies"`
+	}
+	err = cursor.All(ctx, &results)
+	if err != nil {
+		return nil, err
+	}
+	result := &model.PeopleSearchResult{}
+	if len(results) > 0 {
+		result.Users = results[0].Users
+		result.Skills = results[0].Skills
+		result.Companies = results[0].Companies
+		if len(results[0].Total) > 0 {
+			result.Total = results[0].Total[0].Count
+		}
+	}
+	return result, nil
+}
+
+// experiencedUserIds returns the ids of users with a work experience that
+// matches the company, title, location and position of the query.
+func (store *UserMongoDBStore) experiencedUserIds(ctx context.Context, query *model.PeopleSearchQuery) ([]primitive.ObjectID, error) {
+	filter := bson.M{"experienceType": model.WORK}
+	if query.Company != "" {
+		filter["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(query.Company) + "$", "$options": "i"}
+	}
+	if query.Title != "" {
+		filter["title"] = bson.M{"$regex": regexp.QuoteMeta(query.Title), "$options": "i"}
+	}
+	if query.Location != "" {
+		filter["location"] = bson.M{"$regex": regexp.QuoteMeta(query.Location), "$options": "i"}
+	}
+	switch query.Position {
+	case model.CURRENT_POSITION:
+		filter["endDate"] = bson.M{"$in": bson.A{time.Time{}, nil}}
+	case model.PAST_POSITION:
+		filter["endDate"] = bson.M{"$gt": time.Time{}}
+	}
+	values, err := store.experiences.Distinct(ctx, "userId", filter)
+	if err != nil {
+		return nil, err
+	}
+	ids := []primitive.ObjectID{}
+	for _, value := range values {
+		if hex, ok := value.(string); ok {
+			if id, err := primitive.ObjectIDFromHex(hex); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func tagCondition(field string, ids []string, all bool) bson.M {
+	if all {
+		return bson.M{field: bson.M{"$all": ids}}
+	}
+	return bson.M{field: bson.M{"$in": ids}}
+}
+
+func searchTokenScore(token string) bson.M {
+	contains := func(field interface{}, value string) bson.M {
+		return bson.M{"$gte": bson.A{bson.M{"$indexOfCP": bson.A{field, value}}, 0}}
+	}
+	startsWith := func(field string) bson.M {
+		return bson.M{"$eq": bson.A{bson.M{"$indexOfCP": bson.A{field, token}}, 0}}
+	}
+	return bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{"case": bson.M{"$eq": bson.A{"$search.username", token}}, "then": 100},
+			bson.M{"case": startsWith("$search.username"), "then": 80},
+			bson.M{"case": bson.M{"$or": bson.A{startsWith("$search.fullName"), contains("$search.fullName", " "+token)}}, "then": 60},
+			bson.M{"case": bson.M{"$or": bson.A{contains("$search.username", token), contains("$search.fullName", token)}}, "then": 40},
+			bson.M{"case": contains(bson.M{"$concat": bson.A{" ", "$search.bio"}}, " "+token), "then": 20},
+		},
+		"default": 0,
+	}}
+}
+
+// ListUsers returns up to query.Limit users after query.After in the
+// requested order. Every filter is part of the Mongo query, and paging uses
+// the sort field rather than skip so that later pages cost the same as the
+// first.
+func (store *UserMongoDBStore) ListUsers(ctx context.Context, query *model.UserListQuery) ([]*model.User, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListUsers")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{}
+	if query.Filter.Role != "" {
+		filter["role"] = query.Filter.Role
+	}
+	if query.Filter.Confirmed != nil {
+		filter["confirmed"] = *query.Filter.Confirmed
+	}
+	if query.Filter.Private != nil {
+		filter["private"] = *query.Filter.Private
+	}
+	if query.Filter.TFAEnabled != nil {
+		filter["tfaEnabled"] = *query.Filter.TFAEnabled
+	}
+	if query.Filter.SkillId != "" {
+		filter["skills"] = query.Filter.SkillId
+	}
+
+	direction, compare := 1, "$gt"
+	if query.Descending {
+		direction, compare = -1, "$lt"
+	}
+	var sort bson.D
+	opts := options.Find().SetLimit(query.Limit)
+	switch query.Sort {
+	case model.SORT_USERNAME:
+		// Usernames are unique under the collation, so they need no tie
+		// breaker.
+		sort = bson.D{{Key: "username", Value: direction}}
+		opts.SetCollation(caseInsensitive)
+		if query.After != nil {
+			filter["username"] = bson.M{compare: query.After.Value}
+		}
+	case model.SORT_NAME:
+		sort = bson.D{{Key: "name", Value: direction}, {Key: "_id", Value: direction}}
+		opts.SetCollation(caseInsensitive)
+		if query.After != nil {
+			filter["$or"] = bson.A{
+				bson.M{"name": bson.M{compare: query.After.Value}},
+				bson.M{"name": query.After.Value, "_id": bson.M{compare: query.After.Id}},
+			}
+		}
+	default:
+		// Object ids start with their creation time.
+		sort = bson.D{{Key: "_id", Value: direction}}
+		if query.After != nil {
+			filter["_id"] = bson.M{compare: query.After.Id}
+		}
+	}
+
+	cursor, err := store.users.Find(ctx, filter, opts.SetSort(sort))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	return decode(ctx, cursor)
 }
 
 func (store *UserMongoDBStore) GetExperiencesByUserId(ctx context.Context, id string) ([]*model.Experience, error) {
@@ -204,7 +764,7 @@ func (store *UserMongoDBStore) GetExperiencesByUserId(ctx context.Context, id st
 	defer span.Finish()
 	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	filter := bson.M{"userid": id}
+	filter := bson.M{"userId": id}
 	cursor, err := store.experiences.Find(ctx, filter)
 	defer cursor.Close(ctx)
 
@@ -227,9 +787,33 @@ func (store *UserMongoDBStore) CreateExperience(ctx context.Context, experience
 	return experience, nil
 }
 
+func (store *UserMongoDBStore) GetExperience(ctx context.Context, id primitive.ObjectID) (experience *model.Experience, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetExperience")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": id}
+	result := store.experiences.FindOne(ctx, filter)
+	err = result.Decode(&experience)
+	return
+}
+
 func (store *UserMongoDBStore) UpdateExperience(ctx context.Context, experienceId primitive.ObjectID, experience *model.Experience) (*model.Experience, error) {
-	//TODO implement me
-	panic("implement me")
+	span := tracer.StartSpanFromContext(ctx, "UpdateExperience")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	updatedExperience := bson.M{
+		"$set": experience,
+	}
+	filter := bson.M{"_id": experienceId}
+	_, err := store.experiences.UpdateOne(ctx, filter, updatedExperience)
+
+	if err != nil {
+		return nil, err
+	}
+	experience.Id = experienceId
+	return experience, nil
 }
 
 func (store *UserMongoDBStore) DeleteExperience(ctx context.Context, id primitive.ObjectID) error {
@@ -245,73 +829,1205 @@ func (store *UserMongoDBStore) DeleteExperience(ctx context.Context, id primitiv
 	return nil
 }
 
-func (store *UserMongoDBStore) GetPasswordRecoveryRequest(ctx context.Context, id primitive.ObjectID) (passwordRecoveryRequest *model.PasswordRecoveryRequest, err error) {
-	span := tracer.StartSpanFromContext(ctx, "GetPasswordRecoveryRequest")
+func (store *UserMongoDBStore) GetEducationsByUserId(ctx context.Context, userId string) ([]*model.Education, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetEducationsByUserId")
 	defer span.Finish()
-	ctx = tracer.ContextWithSpan(ctx, span)
+	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	filter := bson.M{"_id": id}
+	filter := bson.M{"userId": userId}
+	cursor, err := store.educations.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
-	result := store.passwordRecoveryRequests.FindOne(ctx, filter)
-	err = result.Decode(&passwordRecoveryRequest)
+	var educations []*model.Education
+	err = cursor.All(ctx, &educations)
+	return educations, err
+}
+
+func (store *UserMongoDBStore) GetEducation(ctx context.Context, id primitive.ObjectID) (education *model.Education, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetEducation")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": id}
+	result := store.educations.FindOne(ctx, filter)
+	err = result.Decode(&education)
 	return
 }
 
-func (store *UserMongoDBStore) CreatePasswordRecoveryRequest(ctx context.Context, passwordRecoveryRequest *model.PasswordRecoveryRequest) (*model.PasswordRecoveryRequest, error) {
-	span := tracer.StartSpanFromContext(ctx, "CreatePasswordRecoveryRequest")
+func (store *UserMongoDBStore) CreateEducation(ctx context.Context, education *model.Education) (*model.Education, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateEducation")
 	defer span.Finish()
-	ctx = tracer.ContextWithSpan(ctx, span)
+	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	result, err := store.passwordRecoveryRequests.InsertOne(ctx, passwordRecoveryRequest)
+	result, err := store.educations.InsertOne(ctx, education)
 	if err != nil {
 		return nil, err
 	}
-	passwordRecoveryRequest.Id = result.InsertedID.(primitive.ObjectID)
-	return passwordRecoveryRequest, nil
+	education.Id = result.InsertedID.(primitive.ObjectID)
+	return education, nil
 }
 
-func (store *UserMongoDBStore) DeletePasswordRecoveryRequest(ctx context.Context, id primitive.ObjectID) error {
-	span := tracer.StartSpanFromContext(ctx, "DeletePasswordRecoveryRequest")
+func (store *UserMongoDBStore) UpdateEducation(ctx context.Context, educationId primitive.ObjectID, education *model.Education) (*model.Education, error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateEducation")
 	defer span.Finish()
-	ctx = tracer.ContextWithSpan(ctx, span)
+	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	filter := bson.M{"_id": id}
-	_, err := store.passwordRecoveryRequests.DeleteOne(ctx, filter)
+	filter := bson.M{"_id": educationId}
+	_, err := store.educations.UpdateOne(ctx, filter, bson.M{"$set": education})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	education.Id = educationId
+	return education, nil
 }
 
-func (store *UserMongoDBStore) CreatePasswordlessRequest(ctx context.Context, userId primitive.ObjectID) (string, error) {
-	span := tracer.StartSpanFromContext(ctx, "CreatePasswordlessRequest")
+func (store *UserMongoDBStore) DeleteEducation(ctx context.Context, id primitive.ObjectID) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteEducation")
 	defer span.Finish()
-	ctx = tracer.ContextWithSpan(ctx, span)
+	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	loginReq := new(model.PasswordlessLogin)
-	loginReq.Id = primitive.NewObjectID()
-	loginReq.UserId = userId.Hex()
-	loginReq.CreationTime = time.Now()
+	filter := bson.M{"_id": id}
+	_, err := store.educations.DeleteOne(ctx, filter)
+	return err
+}
 
-	_, err := store.passwordlessLogins.InsertOne(ctx, loginReq)
+func (store *UserMongoDBStore) GetCertificationsByUserId(ctx context.Context, userId string) ([]*model.Certification, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetCertificationsByUserId")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
 
+	filter := bson.M{"userId": userId}
+	cursor, err := store.certifications.Find(ctx, filter)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return loginReq.Id.Hex(), nil
+	defer cursor.Close(ctx)
+
+	var certifications []*model.Certification
+	err = cursor.All(ctx, &certifications)
+	return certifications, err
 }
 
-func (store *UserMongoDBStore) GetPasswordlessRequest(ctx context.Context, userId primitive.ObjectID, loginId primitive.ObjectID) (bool, error) {
-	span := tracer.StartSpanFromContext(ctx, "GetPasswordlessRequest")
+func (store *UserMongoDBStore) GetCertification(ctx context.Context, id primitive.ObjectID) (certification *model.Certification, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetCertification")
 	defer span.Finish()
-	ctx = tracer.ContextWithSpan(ctx, span)
+	ctx = tracer.ContextWithSpan(context.Background(), span)
 
-	filter := bson.M{"_id": loginId, "creationtime": bson.M{
+	filter := bson.M{"_id": id}
+	result := store.certifications.FindOne(ctx, filter)
+	err = result.Decode(&certification)
+	return
+}
+
+func (store *UserMongoDBStore) CreateCertification(ctx context.Context, certification *model.Certification) (*model.Certification, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateCertification")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	result, err := store.certifications.InsertOne(ctx, certification)
+	if err != nil {
+		return nil, err
+	}
+	certification.Id = result.InsertedID.(primitive.ObjectID)
+	return certification, nil
+}
+
+func (store *UserMongoDBStore) UpdateCertification(ctx context.Context, certificationId primitive.ObjectID, certification *model.Certification) (*model.Certification, error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateCertification")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": certificationId}
+	_, err := store.certifications.UpdateOne(ctx, filter, bson.M{"$set": certification})
+	if err != nil {
+		return nil, err
+	}
+	certification.Id = certificationId
+	return certification, nil
+}
+
+func (store *UserMongoDBStore) DeleteCertification(ctx context.Context, id primitive.ObjectID) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteCertification")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": id}
+	_, err := store.certifications.DeleteOne(ctx, filter)
+	return err
+}
+
+func (store *UserMongoDBStore) GetLanguagesByUserId(ctx context.Context, userId string) ([]*model.Language, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetLanguagesByUserId")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"userId": userId}
+	cursor, err := store.languages.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var languages []*model.Language
+	err = cursor.All(ctx, &languages)
+	return languages, err
+}
+
+func (store *UserMongoDBStore) GetLanguage(ctx context.Context, id primitive.ObjectID) (language *model.Language, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetLanguage")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": id}
+	result := store.languages.FindOne(ctx, filter)
+	err = result.Decode(&language)
+	return
+}
+
+func (store *UserMongoDBStore) CreateLanguage(ctx context.Context, language *model.Language) (*model.Language, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateLanguage")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	result, err := store.languages.InsertOne(ctx, language)
+	if err != nil {
+		return nil, err
+	}
+	language.Id = result.InsertedID.(primitive.ObjectID)
+	return language, nil
+}
+
+func (store *UserMongoDBStore) UpdateLanguage(ctx context.Context, languageId primitive.ObjectID, language *model.Language) (*model.Language, error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateLanguage")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": languageId}
+	_, err := store.languages.UpdateOne(ctx, filter, bson.M{"$set": language})
+	if err != nil {
+		return nil, err
+	}
+	language.Id = languageId
+	return language, nil
+}
+
+func (store *UserMongoDBStore) DeleteLanguage(ctx context.Context, id primitive.ObjectID) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteLanguage")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": id}
+	_, err := store.languages.DeleteOne(ctx, filter)
+	return err
+}
+
+func (store *UserMongoDBStore) GetProjectsByUserId(ctx context.Context, userId string) ([]*model.Project, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetProjectsByUserId")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"userId": userId}
+	cursor, err := store.projects.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var projects []*model.Project
+	err = cursor.All(ctx, &projects)
+	return projects, err
+}
+
+func (store *UserMongoDBStore) GetProject(ctx context.Context, id primitive.ObjectID) (project *model.Project, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetProject")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": id}
+	result := store.projects.FindOne(ctx, filter)
+	err = result.Decode(&project)
+	return
+}
+
+func (store *UserMongoDBStore) CreateProject(ctx context.Context, project *model.Project) (*model.Project, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateProject")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	result, err := store.projects.InsertOne(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	project.Id = result.InsertedID.(primitive.ObjectID)
+	return project, nil
+}
+
+func (store *UserMongoDBStore) UpdateProject(ctx context.Context, projectId primitive.ObjectID, project *model.Project) (*model.Project, error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateProject")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": projectId}
+	_, err := store.projects.UpdateOne(ctx, filter, bson.M{"$set": project})
+	if err != nil {
+		return nil, err
+	}
+	project.Id = projectId
+	return project, nil
+}
+
+func (store *UserMongoDBStore) DeleteProject(ctx context.Context, id primitive.ObjectID) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteProject")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(context.Background(), span)
+
+	filter := bson.M{"_id": id}
+	_, err := store.projects.DeleteOne(ctx, filter)
+	return err
+}
+
+func (store *UserMongoDBStore) GetPasswordRecoveryRequest(ctx context.Context, id primitive.ObjectID) (passwordRecoveryRequest *model.PasswordRecoveryRequest, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetPasswordRecoveryRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"_id": id}
+
+	result := store.passwordRecoveryRequests.FindOne(ctx, filter)
+	err = result.Decode(&passwordRecoveryRequest)
+	return
+}
+
+func (store *UserMongoDBStore) CreatePasswordRecoveryRequest(ctx context.Context, passwordRecoveryRequest *model.PasswordRecoveryRequest) (*model.PasswordRecoveryRequest, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreatePasswordRecoveryRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.passwordRecoveryRequests.InsertOne(ctx, passwordRecoveryRequest)
+	if err != nil {
+		return nil, err
+	}
+	passwordRecoveryRequest.Id = result.InsertedID.(primitive.ObjectID)
+	return passwordRecoveryRequest, nil
+}
+
+func (store *UserMongoDBStore) DeletePasswordRecoveryRequest(ctx context.Context, id primitive.ObjectID) error {
+	span := tracer.StartSpanFromContext(ctx, "DeletePasswordRecoveryRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"_id": id}
+	_, err := store.passwordRecoveryRequests.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *UserMongoDBStore) CreatePasswordlessRequest(ctx context.Context, userId primitive.ObjectID) (string, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreatePasswordlessRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	loginReq := new(model.PasswordlessLogin)
+	loginReq.Id = primitive.NewObjectID()
+	loginReq.UserId = userId.Hex()
+	loginReq.CreationTime = time.Now()
+
+	_, err := store.passwordlessLogins.InsertOne(ctx, loginReq)
+
+	if err != nil {
+		return "", err
+	}
+	return loginReq.Id.Hex(), nil
+}
+
+func (store *UserMongoDBStore) GetPasswordlessRequest(ctx context.Context, userId primitive.ObjectID, loginId primitive.ObjectID) (bool, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetPasswordlessRequest")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"_id": loginId, "creationTime": bson.M{
 		"$gte": primitive.NewDateTimeFromTime(time.Now().Add(-time.Minute * time.Duration(15))),
-	}, "userid": bson.M{"$eq": userId.Hex()}}
+	}, "userId": bson.M{"$eq": userId.Hex()}}
 	result := store.passwordlessLogins.FindOne(ctx, filter)
 	if result.Err() != nil {
 		return false, nil
 	}
 	return true, nil
 }
+
+func (store *UserMongoDBStore) CreateSession(ctx context.Context, session *model.Session) (*model.Session, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateSession")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.sessions.InsertOne(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	session.Id = result.InsertedID.(primitive.ObjectID)
+	return session, nil
+}
+
+func (store *UserMongoDBStore) GetSessionByTokenHash(ctx context.Context, tokenHash string) (session *model.Session, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetSessionByTokenHash")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"tokenHash": tokenHash}
+	result := store.sessions.FindOne(ctx, filter)
+	err = result.Decode(&session)
+	return
+}
+
+func (store *UserMongoDBStore) RevokeUserSessions(ctx context.Context, userId string) error {
+	span := tracer.StartSpanFromContext(ctx, "RevokeUserSessions")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"userId": userId, "revoked": false}
+	_, err := store.sessions.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+func (store *UserMongoDBStore) CreateLoginRecord(ctx context.Context, record *model.LoginRecord) (*model.LoginRecord, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateLoginRecord")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.loginRecords.InsertOne(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	record.Id = result.InsertedID.(primitive.ObjectID)
+	return record, nil
+}
+
+func (store *UserMongoDBStore) GetLoginRecord(ctx context.Context, id primitive.ObjectID) (record *model.LoginRecord, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetLoginRecord")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"_id": id}
+	result := store.loginRecords.FindOne(ctx, filter)
+	err = result.Decode(&record)
+	return
+}
+
+func (store *UserMongoDBStore) GetKnownDevices(ctx context.Context, userId string) ([]*model.KnownDevice, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetKnownDevices")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"userId": userId}
+	opts := options.Find().SetSort(bson.M{"lastSeen": -1})
+	cursor, err := store.knownDevices.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var devices []*model.KnownDevice
+	err = cursor.All(ctx, &devices)
+	return devices, err
+}
+
+func (store *UserMongoDBStore) CreateKnownDevice(ctx context.Context, device *model.KnownDevice) (*model.KnownDevice, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateKnownDevice")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.knownDevices.InsertOne(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	device.Id = result.InsertedID.(primitive.ObjectID)
+	return device, nil
+}
+
+func (store *UserMongoDBStore) UpdateKnownDevice(ctx context.Context, deviceId primitive.ObjectID, device *model.KnownDevice) (*model.KnownDevice, error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateKnownDevice")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"_id": deviceId}
+	_, err := store.knownDevices.UpdateOne(ctx, filter, bson.M{"$set": device})
+	if err != nil {
+		return nil, err
+	}
+	device.Id = deviceId
+	return device, nil
+}
+
+func (store *UserMongoDBStore) DeleteKnownDevice(ctx context.Context, id primitive.ObjectID) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteKnownDevice")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"_id": id}
+	_, err := store.knownDevices.DeleteOne(ctx, filter)
+	return err
+}
+
+func (store *UserMongoDBStore) GetLastAuditEvent(ctx context.Context) (event *model.AuditEvent, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetLastAuditEvent")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	opts := options.FindOne().SetSort(bson.M{"sequence": -1})
+	result := store.auditEvents.FindOne(ctx, bson.D{{}}, opts)
+	err = result.Decode(&event)
+	return
+}
+
+func (store *UserMongoDBStore) GetAuditEvents(ctx context.Context, fromSequence int64, limit int64) ([]*model.AuditEvent, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAuditEvents")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"sequence": bson.M{"$gte": fromSequence}}
+	opts := options.Find().SetSort(bson.M{"sequence": 1}).SetLimit(limit)
+	cursor, err := store.auditEvents.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []*model.AuditEvent
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+func (store *UserMongoDBStore) CreateAuditEvent(ctx context.Context, event *model.AuditEvent) (*model.AuditEvent, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateAuditEvent")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.auditEvents.InsertOne(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	event.Id = result.InsertedID.(primitive.ObjectID)
+	return event, nil
+}
+
+func (store *UserMongoDBStore) GetAuditCheckpoints(ctx context.Context) ([]*model.AuditCheckpoint, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetAuditCheckpoints")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	opts := options.Find().SetSort(bson.M{"sequence": 1})
+	cursor, err := store.auditCheckpoints.Find(ctx, bson.D{{}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var checkpoints []*model.AuditCheckpoint
+	err = cursor.All(ctx, &checkpoints)
+	return checkpoints, err
+}
+
+func (store *UserMongoDBStore) CreateAuditCheckpoint(ctx context.Context, checkpoint *model.AuditCheckpoint) (*model.AuditCheckpoint, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateAuditCheckpoint")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.auditCheckpoints.InsertOne(ctx, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.Id = result.InsertedID.(primitive.ObjectID)
+	return checkpoint, nil
+}
+
+func (store *UserMongoDBStore) GetTag(ctx context.Context, id primitive.ObjectID) (tag *model.Tag, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetTag")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result := store.tags.FindOne(ctx, bson.M{"_id": id})
+	err = result.Decode(&tag)
+	return
+}
+
+func (store *UserMongoDBStore) GetTagsByIds(ctx context.Context, ids []primitive.ObjectID) ([]*model.Tag, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetTagsByIds")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	return store.filterTags(ctx, bson.M{"_id": bson.M{"$in": ids}})
+}
+
+func (store *UserMongoDBStore) GetTagByKey(ctx context.Context, kind model.TagKind, key string) (tag *model.Tag, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetTagByKey")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result := store.tags.FindOne(ctx, bson.M{"kind": kind, "keys": key})
+	err = result.Decode(&tag)
+	return
+}
+
+func (store *UserMongoDBStore) GetTags(ctx context.Context, kind model.TagKind) ([]*model.Tag, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetTags")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	return store.filterTags(ctx, bson.M{"kind": kind})
+}
+
+// SearchTags matches the prefix against every key of a tag and returns the
+// most used tags first.
+func (store *UserMongoDBStore) SearchTags(ctx context.Context, kind model.TagKind, prefix string, limit int64) ([]*model.Tag, error) {
+	span := tracer.StartSpanFromContext(ctx, "SearchTags")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"kind": kind, "keys": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}}
+	opts := options.Find().SetSort(bson.D{{Key: "usage", Value: -1}, {Key: "name", Value: 1}}).SetLimit(limit)
+	cursor, err := store.tags.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var tags []*model.Tag
+	err = cursor.All(ctx, &tags)
+	return tags, err
+}
+
+func (store *UserMongoDBStore) CreateTag(ctx context.Context, tag *model.Tag) (*model.Tag, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateTag")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.tags.InsertOne(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	tag.Id = result.InsertedID.(primitive.ObjectID)
+	return tag, nil
+}
+
+func (store *UserMongoDBStore) UpdateTag(ctx context.Context, tagId primitive.ObjectID, tag *model.Tag) (*model.Tag, error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateTag")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.tags.UpdateOne(ctx, bson.M{"_id": tagId}, bson.M{"$set": tag})
+	if err != nil {
+		return nil, err
+	}
+	tag.Id = tagId
+	return tag, nil
+}
+
+func (store *UserMongoDBStore) DeleteTag(ctx context.Context, id primitive.ObjectID) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteTag")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.tags.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (store *UserMongoDBStore) IncrementTagUsage(ctx context.Context, id primitive.ObjectID, delta int64) error {
+	span := tracer.StartSpanFromContext(ctx, "IncrementTagUsage")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.tags.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"usage": delta}})
+	return err
+}
+
+// GetUserIdsWithTag returns the ids of the users that have the tag.
+func (store *UserMongoDBStore) GetUserIdsWithTag(ctx context.Context, kind model.TagKind, id string) ([]primitive.ObjectID, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetUserIdsWithTag")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	values, err := store.users.Distinct(ctx, "_id", bson.M{userTagField(kind): id})
+	if err != nil {
+		return nil, err
+	}
+	ids := []primitive.ObjectID{}
+	for _, value := range values {
+		if id, ok := value.(primitive.ObjectID); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (store *UserMongoDBStore) CountUserTag(ctx context.Context, kind model.TagKind, id string) (int64, error) {
+	span := tracer.StartSpanFromContext(ctx, "CountUserTag")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	return store.users.CountDocuments(ctx, bson.M{userTagField(kind): id})
+}
+
+func (store *UserMongoDBStore) GetEndorsementsByUserId(ctx context.Context, userId string) ([]*model.Endorsement, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetEndorsementsByUserId")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	opts := options.Find().SetSort(bson.M{"timestamp": -1})
+	cursor, err := store.endorsements.Find(ctx, bson.M{"userId": userId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var endorsements []*model.Endorsement
+	err = cursor.All(ctx, &endorsements)
+	return endorsements, err
+}
+
+func (store *UserMongoDBStore) GetEndorsement(ctx context.Context, userId string, skillId string, endorserId string) (endorsement *model.Endorsement, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetEndorsement")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"userId": userId, "skillId": skillId, "endorserId": endorserId}
+	result := store.endorsements.FindOne(ctx, filter)
+	err = result.Decode(&endorsement)
+	return
+}
+
+func (store *UserMongoDBStore) CreateEndorsement(ctx context.Context, endorsement *model.Endorsement) (*model.Endorsement, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateEndorsement")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.endorsements.InsertOne(ctx, endorsement)
+	if err != nil {
+		return nil, err
+	}
+	endorsement.Id = result.InsertedID.(primitive.ObjectID)
+	return endorsement, nil
+}
+
+func (store *UserMongoDBStore) DeleteEndorsement(ctx context.Context, id primitive.ObjectID) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteEndorsement")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.endorsements.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (store *UserMongoDBStore) DeleteSkillEndorsements(ctx context.Context, userId string, skillId string) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteSkillEndorsements")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.endorsements.DeleteMany(ctx, bson.M{"userId": userId, "skillId": skillId})
+	return err
+}
+
+func (store *UserMongoDBStore) ReplaceEndorsementSkill(ctx context.Context, fromId string, toId string) error {
+	span := tracer.StartSpanFromContext(ctx, "ReplaceEndorsementSkill")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.endorsements.UpdateMany(ctx, bson.M{"skillId": fromId}, bson.M{"$set": bson.M{"skillId": toId}})
+	return err
+}
+
+// DeleteUserEndorsements drops the endorsements a user received and gave.
+func (store *UserMongoDBStore) DeleteUserEndorsements(ctx context.Context, userId string) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteUserEndorsements")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.endorsements.DeleteMany(ctx, bson.M{"$or": bson.A{bson.M{"userId": userId}, bson.M{"endorserId": userId}}})
+	return err
+}
+
+func (store *UserMongoDBStore) GetProfileCompleteness(ctx context.Context, userId string) (completeness *model.ProfileCompleteness, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetProfileCompleteness")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result := store.profileCompleteness.FindOne(ctx, bson.M{"userId": userId})
+	err = result.Decode(&completeness)
+	return
+}
+
+// SaveProfileCompleteness replaces the cached score of a user, creating it on
+// the first save.
+func (store *UserMongoDBStore) SaveProfileCompleteness(ctx context.Context, completeness *model.ProfileCompleteness) (*model.ProfileCompleteness, error) {
+	span := tracer.StartSpanFromContext(ctx, "SaveProfileCompleteness")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	update := bson.M{"$set": bson.M{
+		"percentage": completeness.Percentage,
+		"missing":    completeness.Missing,
+		"computedAt": completeness.ComputedAt,
+	}}
+	opts := options.Update().SetUpsert(true)
+	_, err := store.profileCompleteness.UpdateOne(ctx, bson.M{"userId": completeness.UserId}, update, opts)
+	if err != nil {
+		return nil, err
+	}
+	return completeness, nil
+}
+
+func (store *UserMongoDBStore) DeleteProfileCompleteness(ctx context.Context, userId string) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteProfileCompleteness")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.profileCompleteness.DeleteOne(ctx, bson.M{"userId": userId})
+	return err
+}
+
+func (store *UserMongoDBStore) CreateUsernameChange(ctx context.Context, change *model.UsernameChange) (*model.UsernameChange, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateUsernameChange")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.usernameHistory.InsertOne(ctx, change)
+	if err != nil {
+		return nil, err
+	}
+	change.Id = result.InsertedID.(primitive.ObjectID)
+	return change, nil
+}
+
+// GetLatestUsernameChange returns the most recent rename away from
+// oldUsername, which is the one that decides who the name points to.
+func (store *UserMongoDBStore) GetLatestUsernameChange(ctx context.Context, oldUsername string) (change *model.UsernameChange, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetLatestUsernameChange")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	opts := options.FindOne().SetSort(bson.M{"timestamp": -1}).SetCollation(caseInsensitive)
+	result := store.usernameHistory.FindOne(ctx, bson.M{"oldUsername": oldUsername}, opts)
+	err = result.Decode(&change)
+	return
+}
+
+func (store *UserMongoDBStore) CountUsernameChanges(ctx context.Context, userId string, since time.Time) (int64, error) {
+	span := tracer.StartSpanFromContext(ctx, "CountUsernameChanges")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	return store.usernameHistory.CountDocuments(ctx, bson.M{"userId": userId, "timestamp": bson.M{"$gte": since}})
+}
+
+// DeleteUsernameChanges drops the renames of a user, which frees their former
+// usernames.
+func (store *UserMongoDBStore) DeleteUsernameChanges(ctx context.Context, userId string) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteUsernameChanges")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.usernameHistory.DeleteMany(ctx, bson.M{"userId": userId})
+	return err
+}
+
+func (store *UserMongoDBStore) CreateEmailChange(ctx context.Context, change *model.EmailChange) (*model.EmailChange, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateEmailChange")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.emailChanges.InsertOne(ctx, change)
+	if err != nil {
+		return nil, err
+	}
+	change.Id = result.InsertedID.(primitive.ObjectID)
+	return change, nil
+}
+
+func (store *UserMongoDBStore) GetEmailChange(ctx context.Context, id primitive.ObjectID) (change *model.EmailChange, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetEmailChange")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result := store.emailChanges.FindOne(ctx, bson.M{"_id": id})
+	err = result.Decode(&change)
+	return
+}
+
+func (store *UserMongoDBStore) UpdateEmailChange(ctx context.Context, change *model.EmailChange) error {
+	span := tracer.StartSpanFromContext(ctx, "UpdateEmailChange")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.emailChanges.ReplaceOne(ctx, bson.M{"_id": change.Id}, change)
+	return err
+}
+
+// DeletePendingEmailChanges drops the unconfirmed requests of a user so that
+// only the newest confirmation link works.
+func (store *UserMongoDBStore) DeletePendingEmailChanges(ctx context.Context, userId string) error {
+	span := tracer.StartSpanFromContext(ctx, "DeletePendingEmailChanges")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.emailChanges.DeleteMany(ctx, bson.M{"userId": userId, "confirmed": false})
+	return err
+}
+
+// RunInTransaction runs fn in a multi-document transaction. The store calls
+// made with the context passed to fn take part in it. fn may run more than
+// once when the transaction hits a transient error. A standalone server has
+// no transactions, so there fn runs on its own and its writes are not atomic.
+func (store *UserMongoDBStore) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	span := tracer.StartSpanFromContext(ctx, "RunInTransaction")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	supported, err := store.supportsTransactions(ctx)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return fn(ctx)
+	}
+	session, err := store.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+	_, err = session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionContext)
+	})
+	return err
+}
+
+// supportsTransactions asks the server once whether it is a replica set
+// member or a mongos.
+func (store *UserMongoDBStore) supportsTransactions(ctx context.Context) (bool, error) {
+	store.transactionsLock.Lock()
+	defer store.transactionsLock.Unlock()
+	if store.transactions != nil {
+		return *store.transactions, nil
+	}
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := store.client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, err
+	}
+	supported := hello.SetName != "" || hello.Msg == "isdbgrid"
+	if !supported {
+		log.Println("database is a standalone server, writes and their outbox events are not atomic")
+	}
+	store.transactions = &supported
+	return supported, nil
+}
+
+func (store *UserMongoDBStore) CreateOutboxEvent(ctx context.Context, event *model.OutboxEvent) (*model.OutboxEvent, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateOutboxEvent")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.outbox.InsertOne(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	event.Id = result.InsertedID.(primitive.ObjectID)
+	return event, nil
+}
+
+// GetPendingOutboxEvents returns unpublished events after the given id in the
+// order they were emitted, including those waiting for a retry.
+func (store *UserMongoDBStore) GetPendingOutboxEvents(ctx context.Context, after primitive.ObjectID, limit int64) ([]*model.OutboxEvent, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetPendingOutboxEvents")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"published": false, "_id": bson.M{"$gt": after}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+	cursor, err := store.outbox.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []*model.OutboxEvent
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+func (store *UserMongoDBStore) MarkOutboxEventPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	span := tracer.StartSpanFromContext(ctx, "MarkOutboxEventPublished")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	update := bson.M{"$set": bson.M{"published": true, "publishedAt": at}, "$inc": bson.M{"attempts": 1}, "$unset": bson.M{"lastError": ""}}
+	_, err := store.outbox.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (store *UserMongoDBStore) MarkOutboxEventFailed(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time, lastError string) error {
+	span := tracer.StartSpanFromContext(ctx, "MarkOutboxEventFailed")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	update := bson.M{"$set": bson.M{"nextAttemptAt": nextAttemptAt, "lastError": lastError}, "$inc": bson.M{"attempts": 1}}
+	_, err := store.outbox.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// AcquireLease takes or renews the named lease for owner until ttl from now.
+// It returns false while another owner holds an unexpired lease.
+func (store *UserMongoDBStore) AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	span := tracer.StartSpanFromContext(ctx, "AcquireLease")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	now := time.Now()
+	filter := bson.M{"_id": name, "$or": bson.A{bson.M{"owner": owner}, bson.M{"expiresAt": bson.M{"$lt": now}}}}
+	update := bson.M{"$set": bson.M{"owner": owner, "expiresAt": now.Add(ttl)}}
+	_, err := store.leases.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (store *UserMongoDBStore) ReleaseLease(ctx context.Context, name string, owner string) error {
+	span := tracer.StartSpanFromContext(ctx, "ReleaseLease")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.leases.DeleteOne(ctx, bson.M{"_id": name, "owner": owner})
+	return err
+}
+
+func (store *UserMongoDBStore) CreateUserDeletion(ctx context.Context, deletion *model.UserDeletion) (*model.UserDeletion, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateUserDeletion")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	result, err := store.userDeletions.InsertOne(ctx, deletion)
+	if err != nil {
+		return nil, err
+	}
+	deletion.Id = result.InsertedID.(primitive.ObjectID)
+	return deletion, nil
+}
+
+func (store *UserMongoDBStore) GetUserDeletion(ctx context.Context, userId string) (deletion *model.UserDeletion, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetUserDeletion")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	err = store.userDeletions.FindOne(ctx, bson.M{"userId": userId}).Decode(&deletion)
+	return
+}
+
+// GetDueUserDeletions returns running deletions whose next attempt is due,
+// the longest waiting first.
+func (store *UserMongoDBStore) GetDueUserDeletions(ctx context.Context, now time.Time, limit int64) ([]*model.UserDeletion, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetDueUserDeletions")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"state": model.DELETION_RUNNING, "nextAttemptAt": bson.M{"$lte": now}}
+	opts := options.Find().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).SetLimit(limit)
+	cursor, err := store.userDeletions.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var deletions []*model.UserDeletion
+	err = cursor.All(ctx, &deletions)
+	return deletions, err
+}
+
+func (store *UserMongoDBStore) UpdateUserDeletion(ctx context.Context, deletion *model.UserDeletion) error {
+	span := tracer.StartSpanFromContext(ctx, "UpdateUserDeletion")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.userDeletions.ReplaceOne(ctx, bson.M{"_id": deletion.Id}, deletion)
+	return err
+}
+
+// MarkDeletionTagReleased records that the usage of a tag was lowered for a
+// deleted user. It reports false when that was already recorded.
+func (store *UserMongoDBStore) MarkDeletionTagReleased(ctx context.Context, userId string, tagId string) (bool, error) {
+	span := tracer.StartSpanFromContext(ctx, "MarkDeletionTagReleased")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"userId": userId, "releasedTags": bson.M{"$ne": tagId}}
+	result, err := store.userDeletions.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"releasedTags": tagId}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// DeleteUserAccess drops everything a user could sign in or recover their
+// account with: sessions, passwordless logins, password recovery requests,
+// email changes, known devices and login records.
+func (store *UserMongoDBStore) DeleteUserAccess(ctx context.Context, userId string) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteUserAccess")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	return deleteByUserId(ctx, userId, store.sessions, store.passwordlessLogins, store.passwordRecoveryRequests,
+		store.emailChanges, store.knownDevices, store.loginRecords)
+}
+
+// DeleteUserProfileSections drops the experiences, educations,
+// certifications, languages and projects of a user.
+func (store *UserMongoDBStore) DeleteUserProfileSections(ctx context.Context, userId string) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteUserProfileSections")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	return deleteByUserId(ctx, userId, store.experiences, store.educations, store.certifications, store.languages, store.projects)
+}
+
+// SetUserPrivacy sets the privacy of a user and marks it as not yet applied
+// by the connection service. The second result is false when the user
+// already had that privacy, in which case nothing is written.
+func (store *UserMongoDBStore) SetUserPrivacy(ctx context.Context, userId primitive.ObjectID, private bool) (user *model.User, changed bool, err error) {
+	span := tracer.StartSpanFromContext(ctx, "SetUserPrivacy")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"_id": userId, "private": bson.M{"$ne": private}}
+	update := bson.M{"$set": bson.M{"private": private, "privacyPending": true}, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = store.users.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		user, err = store.Get(ctx, userId)
+		return user, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// ClearPrivacyPending records that the connection service applied the
+// privacy of a user, unless it changed again in the meantime.
+func (store *UserMongoDBStore) ClearPrivacyPending(ctx context.Context, userId primitive.ObjectID, private bool) error {
+	span := tracer.StartSpanFromContext(ctx, "ClearPrivacyPending")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.users.UpdateOne(ctx, bson.M{"_id": userId, "private": private, "privacyPending": true},
+		bson.M{"$unset": bson.M{"privacyPending": ""}, "$inc": bson.M{"version": 1}})
+	return err
+}
+
+func (store *UserMongoDBStore) GetPrivacyPendingUsers(ctx context.Context, after primitive.ObjectID, limit int64) ([]*model.User, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetPrivacyPendingUsers")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"privacyPending": true, "_id": bson.M{"$gt": after}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+	cursor, err := store.users.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	return decode(ctx, cursor)
+}
+
+// SavePrivacySync stores the sync of a user, replacing a pending one.
+func (store *UserMongoDBStore) SavePrivacySync(ctx context.Context, sync *model.PrivacySync) error {
+	span := tracer.StartSpanFromContext(ctx, "SavePrivacySync")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.privacySyncs.ReplaceOne(ctx, bson.M{"_id": sync.UserId}, sync, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (store *UserMongoDBStore) GetPrivacySync(ctx context.Context, userId string) (sync *model.PrivacySync, err error) {
+	span := tracer.StartSpanFromContext(ctx, "GetPrivacySync")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	err = store.privacySyncs.FindOne(ctx, bson.M{"_id": userId}).Decode(&sync)
+	return
+}
+
+func (store *UserMongoDBStore) GetDuePrivacySyncs(ctx context.Context, now time.Time, limit int64) ([]*model.PrivacySync, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetDuePrivacySyncs")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	opts := options.Find().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).SetLimit(limit)
+	cursor, err := store.privacySyncs.Find(ctx, bson.M{"nextAttemptAt": bson.M{"$lte": now}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var syncs []*model.PrivacySync
+	err = cursor.All(ctx, &syncs)
+	return syncs, err
+}
+
+// MarkPrivacySyncFailed schedules the next attempt of a sync, unless a newer
+// change replaced it.
+func (store *UserMongoDBStore) MarkPrivacySyncFailed(ctx context.Context, userId string, revision int64, nextAttemptAt time.Time, lastError string) error {
+	span := tracer.StartSpanFromContext(ctx, "MarkPrivacySyncFailed")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	filter := bson.M{"_id": userId, "revision": revision}
+	update := bson.M{"$set": bson.M{"nextAttemptAt": nextAttemptAt, "lastError": lastError}, "$inc": bson.M{"attempts": 1}}
+	_, err := store.privacySyncs.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// DeletePrivacySync drops a finished sync, unless a newer change replaced it.
+func (store *UserMongoDBStore) DeletePrivacySync(ctx context.Context, userId string, revision int64) error {
+	span := tracer.StartSpanFromContext(ctx, "DeletePrivacySync")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	_, err := store.privacySyncs.DeleteOne(ctx, bson.M{"_id": userId, "revision": revision})
+	return err
+}
+
+func deleteByUserId(ctx context.Context, userId string, collections ...*mongo.Collection) error {
+	for _, collection := range collections {
+		_, err := collection.DeleteMany(ctx, bson.M{"userId": userId})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *UserMongoDBStore) filterTags(ctx context.Context, filter interface{}) ([]*model.Tag, error) {
+	cursor, err := store.tags.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var tags []*model.Tag
+	err = cursor.All(ctx, &tags)
+	return tags, err
+}
+
+func userTagField(kind model.TagKind) string {
+	if kind == model.INTEREST {
+		return "interests"
+	}
+	return "skills"
+}
@@ -31,10 +31,37 @@ func main() {
 		log.Info("Failed to log to file, using default stderr")
 	}
 
-	log.Info("Server starting...")
-	
 	config := cfg.NewConfig()
 	server := startup.NewServer(config)
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify-audit":
+			if !server.VerifyAuditTrail() {
+				os.Exit(1)
+			}
+			return
+		case "backfill-tags":
+			if !server.BackfillTags() {
+				os.Exit(1)
+			}
+			return
+		case "reindex-search":
+			if !server.ReindexSearch() {
+				os.Exit(1)
+			}
+			return
+		case "migrate":
+			if !server.Migrate(os.Args[2:]) {
+				os.Exit(1)
+			}
+			return
+		default:
+			log.Fatal("Unknown command: " + os.Args[1])
+		}
+	}
+
+	log.Info("Server starting...")
 	server.Start()
 	defer server.Stop()
 }
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type AuditEvent struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Sequence  int64              `json:"sequence" bson:"sequence"`
+	Type      AuditEventType     `json:"type" bson:"type"`
+	UserId    string             `json:"userId" bson:"userId"`
+	Details   map[string]string  `json:"details" bson:"details"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+	PrevHash  string             `json:"prevHash" bson:"prevHash"`
+	Hash      string             `json:"hash" bson:"hash"`
+}
+
+type AuditCheckpoint struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Sequence  int64              `json:"sequence" bson:"sequence"`
+	Hash      string             `json:"hash" bson:"hash"`
+	Signature string             `json:"signature" bson:"signature"`
+	PublicKey string             `json:"publicKey" bson:"publicKey"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+}
+
+type AuditEventType string
+
+const (
+	LOGIN_SUCCEEDED             AuditEventType = "LOGIN_SUCCEEDED"
+	LOGIN_FAILED                               = "LOGIN_FAILED"
+	TFA_VERIFIED                               = "TFA_VERIFIED"
+	TFA_VERIFICATION_FAILED                    = "TFA_VERIFICATION_FAILED"
+	TFA_ENABLED                                = "TFA_ENABLED"
+	TFA_DISABLED                               = "TFA_DISABLED"
+	API_TOKEN_CREATED                          = "API_TOKEN_CREATED"
+	PASSWORD_RECOVERY_REQUESTED                = "PASSWORD_RECOVERY_REQUESTED"
+	PASSWORD_RECOVERED                         = "PASSWORD_RECOVERED"
+	UNRECOGNIZED_LOGIN_REPORTED                = "UNRECOGNIZED_LOGIN_REPORTED"
+	USERNAME_CHANGED                           = "USERNAME_CHANGED"
+	EMAIL_CHANGE_REQUESTED                     = "EMAIL_CHANGE_REQUESTED"
+	EMAIL_CHANGED                              = "EMAIL_CHANGED"
+	EMAIL_CHANGE_REVERTED                      = "EMAIL_CHANGE_REVERTED"
+)
@@ -0,0 +1,23 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type Certification struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId         string             `json:"userId" bson:"userId"`
+	Name           string             `json:"name" bson:"name"`
+	Issuer         string             `json:"issuer" bson:"issuer"`
+	CredentialId   string             `json:"credentialId" bson:"credentialId"`
+	CredentialUrl  string             `json:"credentialUrl" bson:"credentialUrl"`
+	IssueDate      time.Time          `json:"issueDate" bson:"issueDate"`
+	ExpirationDate time.Time          `json:"expirationDate" bson:"expirationDate"`
+}
+
+// IsExpired reports whether the certification has an expiration date that
+// has already passed.
+func (certification *Certification) IsExpired() bool {
+	return !certification.ExpirationDate.IsZero() && certification.ExpirationDate.Before(time.Now())
+}
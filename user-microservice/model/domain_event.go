@@ -0,0 +1,95 @@
+package model
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type DomainEventType string
+
+const (
+	USER_REGISTERED_EVENT  DomainEventType = "UserRegistered"
+	USER_CONFIRMED_EVENT                   = "UserConfirmed"
+	PRIVACY_CHANGED_EVENT                  = "PrivacyChanged"
+	USERNAME_CHANGED_EVENT                 = "UsernameChanged"
+	USER_DELETED_EVENT                     = "UserDeleted"
+)
+
+// DomainEvent is something that happened to a user that other services act
+// on. Events of one user are published in the order they were emitted.
+type DomainEvent interface {
+	EventType() DomainEventType
+	EventUserId() string
+}
+
+type UserRegistered struct {
+	UserId   string `json:"userId"`
+	Username string `json:"username"`
+	Private  bool   `json:"private"`
+}
+
+type UserConfirmed struct {
+	UserId string `json:"userId"`
+}
+
+type PrivacyChanged struct {
+	UserId  string `json:"userId"`
+	Private bool   `json:"private"`
+}
+
+type UsernameChanged struct {
+	UserId      string `json:"userId"`
+	OldUsername string `json:"oldUsername"`
+	NewUsername string `json:"newUsername"`
+}
+
+type UserDeleted struct {
+	UserId string `json:"userId"`
+}
+
+func (event *UserRegistered) EventType() DomainEventType  { return USER_REGISTERED_EVENT }
+func (event *UserConfirmed) EventType() DomainEventType   { return USER_CONFIRMED_EVENT }
+func (event *PrivacyChanged) EventType() DomainEventType  { return PRIVACY_CHANGED_EVENT }
+func (event *UsernameChanged) EventType() DomainEventType { return USERNAME_CHANGED_EVENT }
+func (event *UserDeleted) EventType() DomainEventType     { return USER_DELETED_EVENT }
+
+func (event *UserRegistered) EventUserId() string  { return event.UserId }
+func (event *UserConfirmed) EventUserId() string   { return event.UserId }
+func (event *PrivacyChanged) EventUserId() string  { return event.UserId }
+func (event *UsernameChanged) EventUserId() string { return event.UserId }
+func (event *UserDeleted) EventUserId() string     { return event.UserId }
+
+// OutboxEvent is a domain event stored together with the change that caused
+// it and kept until it has been published. Key stays the same however many
+// times the event is delivered, so consumers use it to drop duplicates.
+// Payload is the event encoded as JSON.
+type OutboxEvent struct {
+	Id            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Key           string             `json:"key" bson:"key"`
+	Type          DomainEventType    `json:"type" bson:"type"`
+	UserId        string             `json:"userId" bson:"userId"`
+	Payload       string             `json:"payload" bson:"payload"`
+	OccurredAt    time.Time          `json:"occurredAt" bson:"occurredAt"`
+	Published     bool               `json:"published" bson:"published"`
+	PublishedAt   *time.Time         `json:"publishedAt,omitempty" bson:"publishedAt,omitempty"`
+	Attempts      int64              `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time          `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LastError     string             `json:"lastError,omitempty" bson:"lastError,omitempty"`
+}
+
+// EventPublisher hands outbox events to a message broker. Delivery is at
+// least once: an event is published again when Publish fails or its success
+// could not be recorded.
+type EventPublisher interface {
+	Publish(ctx context.Context, event *OutboxEvent) error
+	Close() error
+}
+
+// OutboxFeed reports new outbox events so that they are published right away
+// instead of on the next poll.
+type OutboxFeed interface {
+	// Follow calls added after every new event until ctx is done or the
+	// feed fails.
+	Follow(ctx context.Context, added func()) error
+}
@@ -0,0 +1,23 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type Education struct {
+	Id           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId       string             `json:"userId" bson:"userId"`
+	Institution  string             `json:"institution" bson:"institution"`
+	Degree       string             `json:"degree" bson:"degree"`
+	FieldOfStudy string             `json:"fieldOfStudy" bson:"fieldOfStudy"`
+	Grade        string             `json:"grade" bson:"grade"`
+	Description  string             `json:"description" bson:"description"`
+	StartDate    time.Time          `json:"startDate" bson:"startDate"`
+	EndDate      time.Time          `json:"endDate" bson:"endDate"`
+}
+
+// IsCurrent reports whether the education is still in progress.
+func (education *Education) IsCurrent() bool {
+	return education.EndDate.IsZero()
+}
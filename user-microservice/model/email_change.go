@@ -0,0 +1,23 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// EmailChange tracks a request to move an account to NewEmail. Only hashes of
+// the confirmation and revert tokens are kept; the tokens themselves are only
+// ever sent by email.
+type EmailChange struct {
+	Id               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId           string             `json:"userId" bson:"userId"`
+	OldEmail         string             `json:"oldEmail" bson:"oldEmail"`
+	NewEmail         string             `json:"newEmail" bson:"newEmail"`
+	ConfirmTokenHash string             `json:"confirmTokenHash" bson:"confirmTokenHash"`
+	ValidTo          time.Time          `json:"validTo" bson:"validTo"`
+	RevokeSessions   bool               `json:"revokeSessions" bson:"revokeSessions"`
+	Confirmed        bool               `json:"confirmed" bson:"confirmed"`
+	RevertTokenHash  string             `json:"revertTokenHash" bson:"revertTokenHash"`
+	RevertValidTo    time.Time          `json:"revertValidTo" bson:"revertValidTo"`
+	Reverted         bool               `json:"reverted" bson:"reverted"`
+}
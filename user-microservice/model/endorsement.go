@@ -0,0 +1,16 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Endorsement records that EndorserId vouched for one skill of UserId. SkillId
+// is the id of the canonical skill tag.
+type Endorsement struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId     string             `json:"userId" bson:"userId"`
+	SkillId    string             `json:"skillId" bson:"skillId"`
+	EndorserId string             `json:"endorserId" bson:"endorserId"`
+	Timestamp  time.Time          `json:"timestamp" bson:"timestamp"`
+}
@@ -1,16 +1,72 @@
 package model
 
 import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 )
 
 type Experience struct {
 	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	UserId         string             `json:"userId"`
-	Name           string             `json:"name"`
-	Title          string             `json:"title"`
-	StartDate      time.Time          `json:"startDate"`
-	EndDate        time.Time          `json:"endDate"`
-	ExperienceType bool               `json:"experienceType"`
+	UserId         string             `json:"userId" bson:"userId"`
+	Name           string             `json:"name" bson:"name"`
+	Title          string             `json:"title" bson:"title"`
+	StartDate      time.Time          `json:"startDate" bson:"startDate"`
+	EndDate        time.Time          `json:"endDate" bson:"endDate"`
+	ExperienceType ExperienceType     `json:"experienceType" bson:"experienceType"`
+	EmploymentType EmploymentType     `json:"employmentType" bson:"employmentType"`
+	Location       string             `json:"location" bson:"location"`
+	Description    string             `json:"description" bson:"description"`
 }
+
+// IsCurrent reports whether the experience is still ongoing.
+func (experience *Experience) IsCurrent() bool {
+	return experience.EndDate.IsZero()
+}
+
+type ExperienceType string
+
+const (
+	WORK          ExperienceType = "WORK"
+	EDUCATION                    = "EDUCATION"
+	VOLUNTEERING                 = "VOLUNTEERING"
+	CERTIFICATION                = "CERTIFICATION"
+)
+
+var ExperienceTypes = []ExperienceType{WORK, EDUCATION, VOLUNTEERING, CERTIFICATION}
+
+// UnmarshalBSONValue also accepts the boolean stored by earlier versions,
+// where true meant work and false meant education.
+func (experienceType *ExperienceType) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.Boolean:
+		if value.Boolean() {
+			*experienceType = WORK
+		} else {
+			*experienceType = EDUCATION
+		}
+	case bsontype.String:
+		*experienceType = ExperienceType(value.StringValue())
+	case bsontype.Null, bsontype.Undefined:
+		*experienceType = ""
+	default:
+		return fmt.Errorf("cannot decode %v into an ExperienceType", t)
+	}
+	return nil
+}
+
+type EmploymentType string
+
+const (
+	FULL_TIME     EmploymentType = "FULL_TIME"
+	PART_TIME                    = "PART_TIME"
+	SELF_EMPLOYED                = "SELF_EMPLOYED"
+	FREELANCE                    = "FREELANCE"
+	CONTRACT                     = "CONTRACT"
+	INTERNSHIP                   = "INTERNSHIP"
+)
+
+var EmploymentTypes = []EmploymentType{FULL_TIME, PART_TIME, SELF_EMPLOYED, FREELANCE, CONTRACT, INTERNSHIP}
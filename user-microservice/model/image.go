@@ -0,0 +1,37 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Image is an uploaded picture of a user. Every stored size, including the
+// cleaned original, is a blob under Key + "/" + size.
+type Image struct {
+	Key         string    `json:"key" bson:"key"`
+	ContentType string    `json:"contentType" bson:"contentType"`
+	Width       int64     `json:"width" bson:"width"`
+	Height      int64     `json:"height" bson:"height"`
+	Sizes       []string  `json:"sizes" bson:"sizes"`
+	UploadedAt  time.Time `json:"uploadedAt" bson:"uploadedAt"`
+}
+
+type ImageKind string
+
+const (
+	PROFILE_PICTURE ImageKind = "PROFILE_PICTURE"
+	COVER_IMAGE     ImageKind = "COVER_IMAGE"
+)
+
+var ImageKinds = []ImageKind{PROFILE_PICTURE, COVER_IMAGE}
+
+// BlobStore keeps binary content such as images outside of the user documents.
+// Get returns ErrBlobNotFound for an unknown key and Delete ignores it.
+type BlobStore interface {
+	Put(ctx context.Context, key string, contentType string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
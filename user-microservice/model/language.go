@@ -0,0 +1,22 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Language struct {
+	Id          primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	UserId      string              `json:"userId" bson:"userId"`
+	Name        string              `json:"name" bson:"name"`
+	Proficiency LanguageProficiency `json:"proficiency" bson:"proficiency"`
+}
+
+type LanguageProficiency int64
+
+const (
+	ELEMENTARY LanguageProficiency = iota
+	LIMITED_WORKING
+	PROFESSIONAL_WORKING
+	FULL_PROFESSIONAL
+	NATIVE
+)
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type ClientInfo struct {
+	Ip          string
+	UserAgent   string
+	Fingerprint string
+}
+
+type LoginRecord struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId      string             `json:"userId" bson:"userId"`
+	Ip          string             `json:"ip" bson:"ip"`
+	Network     string             `json:"network" bson:"network"`
+	UserAgent   string             `json:"userAgent" bson:"userAgent"`
+	Fingerprint string             `json:"fingerprint" bson:"fingerprint"`
+	Time        time.Time          `json:"time" bson:"time"`
+	NewDevice   bool               `json:"newDevice" bson:"newDevice"`
+	NewNetwork  bool               `json:"newNetwork" bson:"newNetwork"`
+}
+
+type KnownDevice struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId      string             `json:"userId" bson:"userId"`
+	Fingerprint string             `json:"fingerprint" bson:"fingerprint"`
+	UserAgent   string             `json:"userAgent" bson:"userAgent"`
+	LastIp      string             `json:"lastIp" bson:"lastIp"`
+	Networks    []string           `json:"networks" bson:"networks"`
+	FirstSeen   time.Time          `json:"firstSeen" bson:"firstSeen"`
+	LastSeen    time.Time          `json:"lastSeen" bson:"lastSeen"`
+}
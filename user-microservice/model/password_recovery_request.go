@@ -7,6 +7,6 @@ import (
 
 type PasswordRecoveryRequest struct {
 	Id      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	UserId  string             `json:"userId"`
-	ValidTo time.Time          `json:"validTo"`
+	UserId  string             `json:"userId" bson:"userId"`
+	ValidTo time.Time          `json:"validTo" bson:"validTo"`
 }
@@ -7,6 +7,6 @@ import (
 
 type PasswordlessLogin struct {
 	Id           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	UserId       string             `json:"userId"`
-	CreationTime time.Time          `json:"creationTime"`
+	UserId       string             `json:"userId" bson:"userId"`
+	CreationTime time.Time          `json:"creationTime" bson:"creationTime"`
 }
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PositionFilter string
+
+const (
+	ANY_POSITION     PositionFilter = ""
+	CURRENT_POSITION PositionFilter = "CURRENT"
+	PAST_POSITION    PositionFilter = "PAST"
+)
+
+var PositionFilters = []PositionFilter{ANY_POSITION, CURRENT_POSITION, PAST_POSITION}
+
+// PeopleSearchQuery selects public profiles by their skills, interests and
+// work experience. Skills and Interests hold tag ids and match any of them
+// unless the matching All flag is set. Company, Title, Location and Position
+// have to hold for one and the same work experience.
+type PeopleSearchQuery struct {
+	Skills       []string
+	AllSkills    bool
+	Interests    []string
+	AllInterests bool
+	Company      string
+	Title        string
+	Location     string
+	Position     PositionFilter
+	ExcludeIds   []primitive.ObjectID
+	After        *primitive.ObjectID
+	Limit        int64
+	FacetLimit   int64
+}
+
+// HasExperienceFilter reports whether the query looks at work experience.
+func (query *PeopleSearchQuery) HasExperienceFilter() bool {
+	return query.Company != "" || query.Title != "" || query.Location != "" || query.Position != ANY_POSITION
+}
+
+// FacetCount is the number of matching users with a skill or a company.
+type FacetCount struct {
+	Value string `json:"value" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+// PeopleSearchResult holds one page of users in id order and the facets of
+// every match, not only of the page. The total and the facets are only
+// computed for the first page.
+type PeopleSearchResult struct {
+	Users     []*User
+	Total     int64
+	Skills    []*FacetCount
+	Companies []*FacetCount
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+)
+
+// PrivacySync is a privacy change that the connection service has yet to
+// apply. It is written in the same transaction as the change, keyed by user,
+// so a newer change replaces one that is still pending. Revision is the user
+// version the change produced.
+type PrivacySync struct {
+	UserId        string    `json:"userId" bson:"_id"`
+	Private       bool      `json:"private" bson:"private"`
+	Revision      int64     `json:"revision" bson:"revision"`
+	Attempts      int64     `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LastError     string    `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// ProfileCompleteness is the cached score of a profile. Missing is ordered
+// from the item worth the most to the one worth the least.
+type ProfileCompleteness struct {
+	Id         primitive.ObjectID    `json:"id" bson:"_id,omitempty"`
+	UserId     string                `json:"userId" bson:"userId"`
+	Percentage int64                 `json:"percentage" bson:"percentage"`
+	Missing    []*MissingProfileItem `json:"missing" bson:"missing"`
+	ComputedAt time.Time             `json:"computedAt" bson:"computedAt"`
+}
+
+type MissingProfileItem struct {
+	Item       ProfileItem `json:"item" bson:"item"`
+	Weight     int64       `json:"weight" bson:"weight"`
+	Suggestion string      `json:"suggestion" bson:"suggestion"`
+}
+
+type ProfileItem string
+
+const (
+	PROFILE_EXPERIENCE   ProfileItem = "experience"
+	PROFILE_SKILLS       ProfileItem = "skills"
+	PROFILE_BIO          ProfileItem = "bio"
+	PROFILE_TWO_FACTOR   ProfileItem = "twoFactor"
+	PROFILE_PHONE_NUMBER ProfileItem = "phoneNumber"
+	PROFILE_BIRTH_DATE   ProfileItem = "birthDate"
+	PROFILE_INTERESTS    ProfileItem = "interests"
+)
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type Project struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId      string             `json:"userId" bson:"userId"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description" bson:"description"`
+	Links       []string           `json:"links" bson:"links"`
+	StartDate   time.Time          `json:"startDate" bson:"startDate"`
+	EndDate     time.Time          `json:"endDate" bson:"endDate"`
+}
+
+// IsCurrent reports whether the project is still ongoing.
+func (project *Project) IsCurrent() bool {
+	return project.EndDate.IsZero()
+}
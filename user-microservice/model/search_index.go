@@ -0,0 +1,49 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrFeedUnsupported means the database cannot report changes, as a
+	// standalone Mongo server without an oplog cannot.
+	ErrFeedUnsupported = errors.New("change feed is not supported by the database")
+	// ErrFeedPositionLost means changes after the position are no longer
+	// available and the index has to be rebuilt.
+	ErrFeedPositionLost = errors.New("change feed position is no longer available")
+)
+
+// SearchIndex answers user searches. Hits are ordered by score, best first,
+// and then by user id. An index that keeps its own copy of the users records
+// how far into the UserChangeFeed it is, so that it can catch up after a
+// restart.
+type SearchIndex interface {
+	Search(ctx context.Context, query *UserSearchQuery) ([]*UserSearchHit, error)
+	Index(ctx context.Context, user *User) error
+	Remove(ctx context.Context, userId primitive.ObjectID) error
+	// Reset empties the index ahead of a full reindex.
+	Reset(ctx context.Context) error
+	// Checkpoint returns the feed position the index is current with, or
+	// nil when it has none and needs a full reindex.
+	Checkpoint() []byte
+	SetCheckpoint(position []byte)
+	Close() error
+}
+
+// UserChange is a write to a user document as reported by the change feed.
+// User is nil when the user was deleted.
+type UserChange struct {
+	UserId   primitive.ObjectID
+	User     *User
+	Position []byte
+}
+
+type UserChangeFeed interface {
+	// Position returns the current end of the feed.
+	Position(ctx context.Context) ([]byte, error)
+	// Follow passes every change after position to apply until ctx is done,
+	// apply fails or the feed does. A nil position starts at the current end.
+	Follow(ctx context.Context, position []byte, apply func(change *UserChange) error) error
+}
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type Session struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId    string             `json:"userId" bson:"userId"`
+	TokenHash string             `json:"tokenHash" bson:"tokenHash"`
+	IssuedAt  time.Time          `json:"issuedAt" bson:"issuedAt"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt"`
+	Revoked   bool               `json:"revoked" bson:"revoked"`
+}
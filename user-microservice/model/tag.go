@@ -0,0 +1,27 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tag is a canonical skill or interest. Users reference tags by id, so every
+// spelling listed in Aliases resolves to the same entry.
+type Tag struct {
+	Id       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Kind     TagKind            `json:"kind" bson:"kind"`
+	Name     string             `json:"name" bson:"name"`
+	Aliases  []string           `json:"aliases" bson:"aliases"`
+	Category string             `json:"category" bson:"category"`
+	// Keys holds the normalized name and aliases and is what lookups match on.
+	Keys  []string `json:"keys" bson:"keys"`
+	Usage int64    `json:"usage" bson:"usage"`
+}
+
+type TagKind string
+
+const (
+	SKILL    TagKind = "SKILL"
+	INTEREST TagKind = "INTEREST"
+)
+
+var TagKinds = []TagKind{SKILL, INTEREST}
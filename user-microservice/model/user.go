@@ -1,30 +1,51 @@
 package model
 
 import (
+	"errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 )
 
+// ErrVersionConflict is returned by the store when a document changed after
+// the caller read it.
+var ErrVersionConflict = errors.New("document was modified concurrently")
+
 type User struct {
-	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty" validate:"required,email"`
-	Name           string             `json:"name"`
-	Surname        string             `json:"surname"`
-	Email          string             `json:"email"`
-	PhoneNumber    string             `json:"phoneNumber"`
-	Gender         Gender             `json:"gender"`
-	BirthDate      time.Time          `json:"birthDate"`
-	Username       string             `json:"username"`
-	Password       string             `json:"password"`
-	Bio            string             `json:"bio"`
-	Skills         []string           `json:"skills"`
-	Interests      []string           `json:"interests"`
-	Private        bool               `json:"private"`
-	Role           UserRole           `json:"role"`
-	TFASecret      string             `json:"2faSecret"`
-	TFAEnabled     bool               `json:"2faEnabled"`
-	ApiToken       string             `json:"apiToken"`
-	Confirmed      bool               `json:"confirmed"`
+	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name           string             `json:"name" bson:"name"`
+	Surname        string             `json:"surname" bson:"surname"`
+	Email          string             `json:"email" bson:"email"`
+	PhoneNumber    string             `json:"phoneNumber" bson:"phoneNumber"`
+	Gender         Gender             `json:"gender" bson:"gender"`
+	BirthDate      time.Time          `json:"birthDate" bson:"birthDate"`
+	Username       string             `json:"username" bson:"username"`
+	Password       string             `json:"password" bson:"password"`
+	Bio            string             `json:"bio" bson:"bio"`
+	Skills         []string           `json:"skills" bson:"skills"`
+	Interests      []string           `json:"interests" bson:"interests"`
+	Private        bool               `json:"private" bson:"private"`
+	Role           UserRole           `json:"role" bson:"role"`
+	TFASecret      string             `json:"2faSecret" bson:"tfaSecret"`
+	TFAEnabled     bool               `json:"2faEnabled" bson:"tfaEnabled"`
+	ApiToken       string             `json:"apiToken" bson:"apiToken"`
+	Confirmed      bool               `json:"confirmed" bson:"confirmed"`
 	ConfirmationId string             `json:"confirmationId" bson:"confirmationId"`
+	Version        int64              `json:"version" bson:"version"`
+	ProfilePicture *Image             `json:"profilePicture" bson:"profilePicture"`
+	CoverImage     *Image             `json:"coverImage" bson:"coverImage"`
+	Search         *UserSearch        `json:"-" bson:"search,omitempty"`
+	PrivacyPending bool               `json:"-" bson:"privacyPending,omitempty"`
+}
+
+// UserProfile holds the fields users may change on their own profile. The bson
+// keys match those of User so it can be written straight onto a user document.
+type UserProfile struct {
+	Name        string    `json:"name" bson:"name"`
+	Surname     string    `json:"surname" bson:"surname"`
+	PhoneNumber string    `json:"phoneNumber" bson:"phoneNumber"`
+	Gender      Gender    `json:"gender" bson:"gender"`
+	BirthDate   time.Time `json:"birthDate" bson:"birthDate"`
+	Bio         string    `json:"bio" bson:"bio"`
 }
 
 type UserRole string
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type DeletionState string
+
+const (
+	DELETION_RUNNING   DeletionState = "RUNNING"
+	DELETION_COMPLETED               = "COMPLETED"
+)
+
+type DeletionStepName string
+
+const (
+	DELETE_ACCESS           DeletionStepName = "ACCESS"
+	DELETE_PROFILE_SECTIONS                  = "PROFILE_SECTIONS"
+	DELETE_ENDORSEMENTS                      = "ENDORSEMENTS"
+	DELETE_TAG_USAGE                         = "TAG_USAGE"
+	DELETE_IMAGES                            = "IMAGES"
+	DELETE_COMPLETENESS                      = "COMPLETENESS"
+	DELETE_USERNAME_HISTORY                  = "USERNAME_HISTORY"
+	DELETE_SEARCH_INDEX                      = "SEARCH_INDEX"
+	DELETE_CONNECTIONS                       = "CONNECTIONS"
+)
+
+// DeletionSteps lists the steps of every user deletion in the order they run.
+var DeletionSteps = []DeletionStepName{
+	DELETE_ACCESS, DELETE_PROFILE_SECTIONS, DELETE_ENDORSEMENTS, DELETE_TAG_USAGE, DELETE_IMAGES,
+	DELETE_COMPLETENESS, DELETE_USERNAME_HISTORY, DELETE_SEARCH_INDEX, DELETE_CONNECTIONS,
+}
+
+type DeletionStepState string
+
+const (
+	STEP_PENDING DeletionStepState = "PENDING"
+	STEP_FAILED                    = "FAILED"
+	STEP_DONE                      = "DONE"
+)
+
+type DeletionStep struct {
+	Name      DeletionStepName  `json:"name" bson:"name"`
+	State     DeletionStepState `json:"state" bson:"state"`
+	Attempts  int64             `json:"attempts" bson:"attempts"`
+	LastError string            `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	UpdatedAt time.Time         `json:"updatedAt" bson:"updatedAt"`
+}
+
+// UserDeletion is the persisted state of the saga that cleans up after a
+// deleted user. The user document itself is gone by the time it starts, so
+// it keeps the few things of the user that the steps need until they are
+// done.
+type UserDeletion struct {
+	Id            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId        string             `json:"userId" bson:"userId"`
+	State         DeletionState      `json:"state" bson:"state"`
+	Steps         []*DeletionStep    `json:"steps" bson:"steps"`
+	Skills        []string           `json:"skills,omitempty" bson:"skills,omitempty"`
+	Interests     []string           `json:"interests,omitempty" bson:"interests,omitempty"`
+	Images        []*Image           `json:"images,omitempty" bson:"images,omitempty"`
+	ReleasedTags  []string           `json:"releasedTags,omitempty" bson:"releasedTags,omitempty"`
+	Attempts      int64              `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time          `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	StartedAt     time.Time          `json:"startedAt" bson:"startedAt"`
+	CompletedAt   *time.Time         `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+}
+
+func (deletion *UserDeletion) Step(name DeletionStepName) *DeletionStep {
+	for _, step := range deletion.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UserSort string
+
+const (
+	SORT_CREATED  UserSort = "CREATED"
+	SORT_USERNAME UserSort = "USERNAME"
+	SORT_NAME     UserSort = "NAME"
+)
+
+var UserSorts = []UserSort{SORT_CREATED, SORT_USERNAME, SORT_NAME}
+
+// UserFilter narrows a user listing. Nil and empty fields match every user.
+type UserFilter struct {
+	Role       UserRole
+	Confirmed  *bool
+	Private    *bool
+	TFAEnabled *bool
+	// SkillId is the id of a skill tag the user must have.
+	SkillId string
+}
+
+// UserCursor is the position of the last user of a page: the value of the
+// sort field and the id, which breaks ties between equal values. Sorting by
+// creation uses the id alone.
+type UserCursor struct {
+	Value string             `json:"v,omitempty"`
+	Id    primitive.ObjectID `json:"id"`
+}
+
+// UserListQuery selects one page of users. After is nil for the first page.
+type UserListQuery struct {
+	Filter     UserFilter
+	Sort       UserSort
+	Descending bool
+	After      *UserCursor
+	Limit      int64
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+const (
+	searchGramSize = 3
+	maxBioWords    = 200
+)
+
+// UserSearch holds the folded forms of the searchable fields of a user. It is
+// stored on the user document and rebuilt on every write of those fields.
+// Words are matched by prefix, and Grams, the trigrams of the username, name
+// and surname, find names by any part of them.
+type UserSearch struct {
+	Username string   `json:"username" bson:"username"`
+	FullName string   `json:"fullName" bson:"fullName"`
+	Bio      string   `json:"bio" bson:"bio"`
+	Words    []string `json:"words" bson:"words"`
+	Grams    []string `json:"grams" bson:"grams"`
+}
+
+// UserSearchQuery selects one page of users matching every token, ranked by
+// relevance. Tokens are folded with FoldSearchText.
+type UserSearchQuery struct {
+	Tokens     []string
+	ExcludeIds []primitive.ObjectID
+	After      *UserSearchCursor
+	Limit      int64
+}
+
+// UserSearchCursor is the position of the last hit of a page.
+type UserSearchCursor struct {
+	Score int64              `json:"s"`
+	Id    primitive.ObjectID `json:"id"`
+}
+
+// UserSearchHit is a matching user. Indexes that do not store whole users
+// only fill in UserId.
+type UserSearchHit struct {
+	UserId primitive.ObjectID
+	User   *User
+	Score  int64
+}
+
+func NewUserSearch(user *User) *UserSearch {
+	search := &UserSearch{
+		Username: FoldSearchText(user.Username),
+		FullName: strings.Join(strings.Fields(FoldSearchText(user.Name+" "+user.Surname)), " "),
+		Bio:      strings.Join(strings.Fields(FoldSearchText(user.Bio)), " "),
+		Words:    []string{},
+		Grams:    []string{},
+	}
+	seenWords := map[string]bool{}
+	seenGrams := map[string]bool{}
+	addWords := func(text string, grams bool, limit int) {
+		for i, word := range SearchTokens(text) {
+			if limit > 0 && i >= limit {
+				return
+			}
+			if !seenWords[word] {
+				seenWords[word] = true
+				search.Words = append(search.Words, word)
+			}
+			if !grams {
+				continue
+			}
+			for _, gram := range SearchGrams(word) {
+				if !seenGrams[gram] {
+					seenGrams[gram] = true
+					search.Grams = append(search.Grams, gram)
+				}
+			}
+		}
+	}
+	// The whole username is a word too, so that it matches as typed.
+	if search.Username != "" {
+		seenWords[search.Username] = true
+		search.Words = append(search.Words, search.Username)
+	}
+	addWords(search.Username, true, 0)
+	addWords(search.FullName, true, 0)
+	addWords(search.Bio, false, maxBioWords)
+	return search
+}
+
+// FoldSearchText lowercases text, transliterates Serbian Cyrillic and strips
+// diacritics, so that "Đorđević", "Ђорђевић" and "djordjevic" compare equal.
+func FoldSearchText(text string) string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if latin, ok := serbianFolds[r]; ok {
+			builder.WriteString(latin)
+		} else {
+			builder.WriteRune(r)
+		}
+	}
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), builder.String())
+	if err != nil {
+		return builder.String()
+	}
+	return folded
+}
+
+// SearchTokens splits folded text into words at anything that is not a letter
+// or a digit.
+func SearchTokens(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchQueryTokens splits a folded query at whitespace and trims punctuation
+// around each token, keeping the inside of usernames such as "pera_99".
+func SearchQueryTokens(text string) []string {
+	var tokens []string
+	for _, field := range strings.Fields(text) {
+		token := strings.TrimFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// SearchGrams returns the trigrams of a folded word. Words shorter than a
+// trigram have none.
+func SearchGrams(word string) []string {
+	letters := []rune(word)
+	var grams []string
+	for i := 0; i+searchGramSize <= len(letters); i++ {
+		grams = append(grams, string(letters[i:i+searchGramSize]))
+	}
+	return grams
+}
+
+// serbianFolds covers the letters that do not decompose into a base letter
+// and a diacritic.
+var serbianFolds = map[rune]string{
+	'đ': "dj", 'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'ђ': "dj",
+	'е': "e", 'ж': "z", 'з': "z", 'и': "i", 'ј': "j", 'к': "k", 'л': "l",
+	'љ': "lj", 'м': "m", 'н': "n", 'њ': "nj", 'о': "o", 'п': "p", 'р': "r",
+	'с': "s", 'т': "t", 'ћ': "c", 'у': "u", 'ф': "f", 'х': "h", 'ц': "c",
+	'ч': "c", 'џ': "dz", 'ш': "s",
+}
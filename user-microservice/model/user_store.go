@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 type UserStore interface {
@@ -11,18 +12,56 @@ type UserStore interface {
 	GetByUsername(ctx context.Context, username string) (*User, error)
 	GetByConfirmationId(ctx context.Context, confirmationId string) (*User, error)
 	GetAll(ctx context.Context) ([]*User, error)
+	GetByIds(ctx context.Context, ids []primitive.ObjectID) ([]*User, error)
 	Create(ctx context.Context, user *User) (*User, error)
 	Update(ctx context.Context, userId primitive.ObjectID, user *User) (*User, error)
+	UpdateProfile(ctx context.Context, userId primitive.ObjectID, version int64, profile *UserProfile) (*User, error)
+	AddUserTags(ctx context.Context, userId primitive.ObjectID, kind TagKind, ids []string) ([]string, error)
+	RemoveUserTags(ctx context.Context, userId primitive.ObjectID, kind TagKind, ids []string) ([]string, error)
+	ReorderUserTags(ctx context.Context, userId primitive.ObjectID, kind TagKind, ids []string) error
+	ChangeEmail(ctx context.Context, userId primitive.ObjectID, from string, to string) (*User, error)
+	SetUserImage(ctx context.Context, userId primitive.ObjectID, kind ImageKind, image *Image) (*Image, error)
 	Delete(ctx context.Context, id primitive.ObjectID) error
 	DeleteAll(ctx context.Context)
-	GetAllWithoutAdmins(ctx context.Context) ([]*User, error)
+	SearchUsers(ctx context.Context, query *UserSearchQuery) ([]*UserSearchHit, error)
+	SearchPeople(ctx context.Context, query *PeopleSearchQuery) (*PeopleSearchResult, error)
+	ListUsers(ctx context.Context, query *UserListQuery) ([]*User, error)
 
 	//experience
 	GetExperiencesByUserId(ctx context.Context, id string) ([]*Experience, error)
+	GetExperience(ctx context.Context, id primitive.ObjectID) (*Experience, error)
 	CreateExperience(ctx context.Context, experience *Experience) (*Experience, error)
 	UpdateExperience(ctx context.Context, experienceId primitive.ObjectID, experience *Experience) (*Experience, error)
 	DeleteExperience(ctx context.Context, id primitive.ObjectID) error
 
+	//education
+	GetEducationsByUserId(ctx context.Context, userId string) ([]*Education, error)
+	GetEducation(ctx context.Context, id primitive.ObjectID) (*Education, error)
+	CreateEducation(ctx context.Context, education *Education) (*Education, error)
+	UpdateEducation(ctx context.Context, educationId primitive.ObjectID, education *Education) (*Education, error)
+	DeleteEducation(ctx context.Context, id primitive.ObjectID) error
+
+	//certification
+	GetCertificationsByUserId(ctx context.Context, userId string) ([]*Certification, error)
+	GetCertification(ctx context.Context, id primitive.ObjectID) (*Certification, error)
+	CreateCertification(ctx context.Context, certification *Certification) (*Certification, error)
+	UpdateCertification(ctx context.Context, certificationId primitive.ObjectID, certification *Certification) (*Certification, error)
+	DeleteCertification(ctx context.Context, id primitive.ObjectID) error
+
+	//language
+	GetLanguagesByUserId(ctx context.Context, userId string) ([]*Language, error)
+	GetLanguage(ctx context.Context, id primitive.ObjectID) (*Language, error)
+	CreateLanguage(ctx context.Context, language *Language) (*Language, error)
+	UpdateLanguage(ctx context.Context, languageId primitive.ObjectID, language *Language) (*Language, error)
+	DeleteLanguage(ctx context.Context, id primitive.ObjectID) error
+
+	//project
+	GetProjectsByUserId(ctx context.Context, userId string) ([]*Project, error)
+	GetProject(ctx context.Context, id primitive.ObjectID) (*Project, error)
+	CreateProject(ctx context.Context, project *Project) (*Project, error)
+	UpdateProject(ctx context.Context, projectId primitive.ObjectID, project *Project) (*Project, error)
+	DeleteProject(ctx context.Context, id primitive.ObjectID) error
+
 	//passwordRecoveryRequest
 	GetPasswordRecoveryRequest(ctx context.Context, id primitive.ObjectID) (*PasswordRecoveryRequest, error)
 	CreatePasswordRecoveryRequest(ctx context.Context, passwordRecoveryRequest *PasswordRecoveryRequest) (*PasswordRecoveryRequest, error)
@@ -31,4 +70,95 @@ type UserStore interface {
 	//passwordlessLoginCreate
 	CreatePasswordlessRequest(ctx context.Context, userId primitive.ObjectID) (string, error)
 	GetPasswordlessRequest(ctx context.Context, userId primitive.ObjectID, loginId primitive.ObjectID) (bool, error)
+
+	//session
+	CreateSession(ctx context.Context, session *Session) (*Session, error)
+	GetSessionByTokenHash(ctx context.Context, tokenHash string) (*Session, error)
+	RevokeUserSessions(ctx context.Context, userId string) error
+
+	//loginRecord
+	CreateLoginRecord(ctx context.Context, record *LoginRecord) (*LoginRecord, error)
+	GetLoginRecord(ctx context.Context, id primitive.ObjectID) (*LoginRecord, error)
+
+	//knownDevice
+	GetKnownDevices(ctx context.Context, userId string) ([]*KnownDevice, error)
+	CreateKnownDevice(ctx context.Context, device *KnownDevice) (*KnownDevice, error)
+	UpdateKnownDevice(ctx context.Context, deviceId primitive.ObjectID, device *KnownDevice) (*KnownDevice, error)
+	DeleteKnownDevice(ctx context.Context, id primitive.ObjectID) error
+
+	//audit
+	GetLastAuditEvent(ctx context.Context) (*AuditEvent, error)
+	GetAuditEvents(ctx context.Context, fromSequence int64, limit int64) ([]*AuditEvent, error)
+	CreateAuditEvent(ctx context.Context, event *AuditEvent) (*AuditEvent, error)
+	GetAuditCheckpoints(ctx context.Context) ([]*AuditCheckpoint, error)
+	CreateAuditCheckpoint(ctx context.Context, checkpoint *AuditCheckpoint) (*AuditCheckpoint, error)
+
+	//tag
+	GetTag(ctx context.Context, id primitive.ObjectID) (*Tag, error)
+	GetTagsByIds(ctx context.Context, ids []primitive.ObjectID) ([]*Tag, error)
+	GetTagByKey(ctx context.Context, kind TagKind, key string) (*Tag, error)
+	GetTags(ctx context.Context, kind TagKind) ([]*Tag, error)
+	SearchTags(ctx context.Context, kind TagKind, prefix string, limit int64) ([]*Tag, error)
+	CreateTag(ctx context.Context, tag *Tag) (*Tag, error)
+	UpdateTag(ctx context.Context, tagId primitive.ObjectID, tag *Tag) (*Tag, error)
+	DeleteTag(ctx context.Context, id primitive.ObjectID) error
+	IncrementTagUsage(ctx context.Context, id primitive.ObjectID, delta int64) error
+	GetUserIdsWithTag(ctx context.Context, kind TagKind, id string) ([]primitive.ObjectID, error)
+	CountUserTag(ctx context.Context, kind TagKind, id string) (int64, error)
+
+	//endorsement
+	GetEndorsementsByUserId(ctx context.Context, userId string) ([]*Endorsement, error)
+	GetEndorsement(ctx context.Context, userId string, skillId string, endorserId string) (*Endorsement, error)
+	CreateEndorsement(ctx context.Context, endorsement *Endorsement) (*Endorsement, error)
+	DeleteEndorsement(ctx context.Context, id primitive.ObjectID) error
+	DeleteSkillEndorsements(ctx context.Context, userId string, skillId string) error
+	ReplaceEndorsementSkill(ctx context.Context, fromId string, toId string) error
+	DeleteUserEndorsements(ctx context.Context, userId string) error
+
+	//profileCompleteness
+	GetProfileCompleteness(ctx context.Context, userId string) (*ProfileCompleteness, error)
+	SaveProfileCompleteness(ctx context.Context, completeness *ProfileCompleteness) (*ProfileCompleteness, error)
+	DeleteProfileCompleteness(ctx context.Context, userId string) error
+
+	//usernameHistory
+	CreateUsernameChange(ctx context.Context, change *UsernameChange) (*UsernameChange, error)
+	GetLatestUsernameChange(ctx context.Context, oldUsername string) (*UsernameChange, error)
+	CountUsernameChanges(ctx context.Context, userId string, since time.Time) (int64, error)
+	DeleteUsernameChanges(ctx context.Context, userId string) error
+
+	//emailChange
+	CreateEmailChange(ctx context.Context, change *EmailChange) (*EmailChange, error)
+	GetEmailChange(ctx context.Context, id primitive.ObjectID) (*EmailChange, error)
+	UpdateEmailChange(ctx context.Context, change *EmailChange) error
+	DeletePendingEmailChanges(ctx context.Context, userId string) error
+
+	//outbox
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	CreateOutboxEvent(ctx context.Context, event *OutboxEvent) (*OutboxEvent, error)
+	GetPendingOutboxEvents(ctx context.Context, after primitive.ObjectID, limit int64) ([]*OutboxEvent, error)
+	MarkOutboxEventPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	MarkOutboxEventFailed(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time, lastError string) error
+
+	//lease
+	AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name string, owner string) error
+
+	//userDeletion
+	CreateUserDeletion(ctx context.Context, deletion *UserDeletion) (*UserDeletion, error)
+	GetUserDeletion(ctx context.Context, userId string) (*UserDeletion, error)
+	GetDueUserDeletions(ctx context.Context, now time.Time, limit int64) ([]*UserDeletion, error)
+	UpdateUserDeletion(ctx context.Context, deletion *UserDeletion) error
+	MarkDeletionTagReleased(ctx context.Context, userId string, tagId string) (bool, error)
+	DeleteUserAccess(ctx context.Context, userId string) error
+	DeleteUserProfileSections(ctx context.Context, userId string) error
+
+	//privacy
+	SetUserPrivacy(ctx context.Context, userId primitive.ObjectID, private bool) (*User, bool, error)
+	ClearPrivacyPending(ctx context.Context, userId primitive.ObjectID, private bool) error
+	GetPrivacyPendingUsers(ctx context.Context, after primitive.ObjectID, limit int64) ([]*User, error)
+	SavePrivacySync(ctx context.Context, sync *PrivacySync) error
+	GetPrivacySync(ctx context.Context, userId string) (*PrivacySync, error)
+	GetDuePrivacySyncs(ctx context.Context, now time.Time, limit int64) ([]*PrivacySync, error)
+	MarkPrivacySyncFailed(ctx context.Context, userId string, revision int64, nextAttemptAt time.Time, lastError string) error
+	DeletePrivacySync(ctx context.Context, userId string, revision int64) error
 }
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// UsernameChange records a rename. OldUsername stays reserved for UserId until
+// ReservedUntil and keeps resolving to the user afterwards unless somebody
+// else claims it.
+type UsernameChange struct {
+	Id            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId        string             `json:"userId" bson:"userId"`
+	OldUsername   string             `json:"oldUsername" bson:"oldUsername"`
+	NewUsername   string             `json:"newUsername" bson:"newUsername"`
+	Timestamp     time.Time          `json:"timestamp" bson:"timestamp"`
+	ReservedUntil time.Time          `json:"reservedUntil" bson:"reservedUntil"`
+}
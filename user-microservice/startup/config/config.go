@@ -4,34 +4,86 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	Port                  string
-	UserDBHost            string
-	UserDBPort            string
-	UserServiceName       string
-	ExpiresIn             time.Duration
-	CommonPasswords       []string
-	ConnectionServiceHost string
-	ConnectionServicePort string
-	Email                 string
-	EmailPassword         string
+	Port                      string
+	UserDBHost                string
+	UserDBPort                string
+	UserServiceName           string
+	ExpiresIn                 time.Duration
+	CommonPasswords           []string
+	ConnectionServiceHost     string
+	ConnectionServicePort     string
+	Email                     string
+	EmailPassword             string
+	AuditSigningKey           string
+	AuditCheckpointEvery      int64
+	CompletenessWeights       map[string]int64
+	ImageStore                string
+	ImageDir                  string
+	ImageMaxBytes             int64
+	ImageMaxPixels            int64
+	UsernameCooldown          time.Duration
+	UsernameChangeLimit       int64
+	UsernameChangeWindow      time.Duration
+	ReservedUsernames         []string
+	SearchBackend             string
+	SearchIndexDir            string
+	EventPublisher            string
+	EventPublisherURL         string
+	ConnectionReadTimeout     time.Duration
+	ConnectionWriteTimeout    time.Duration
+	ConnectionRetries         int64
+	ConnectionRetryBackoff    time.Duration
+	ConnectionBreakerFailures int64
+	ConnectionBreakerCooldown time.Duration
+	SearchDegradedMode        string
+	MetricsPort               string
+	TrustedProxies            []string
+	SessionlessTokensUntil    time.Time
 }
 
 func NewConfig() *Config {
 	return &Config{
-		Port:                  getEnv("USER_SERVICE_PORT", "8085"),
-		UserDBHost:            getEnv("USER_DB_HOST", "dislinkt:WiYf6BvFmSpJS2Ob@xws.cjx50.mongodb.net/usersDB"),
-		UserDBPort:            getEnv("USER_DB_PORT", ""),
-		UserServiceName:       getEnv("USER_SERVICE_NAME", "user_service"),
-		ExpiresIn:             30 * time.Minute,
-		CommonPasswords:       getPasswords(),
-		ConnectionServiceHost: getEnv("CONNECTION_SERVICE_HOST", "localhost"),
-		ConnectionServicePort: getEnv("CONNECTION_SERVICE_PORT", "8087"),
-		Email:                 getEnv("SERVICE_EMAIL", "xwstim1@outlook.com"),
-		EmailPassword:         getEnv("EMAIL_PASSWORD", "XWS.tim1"),
+		Port:                      getEnv("USER_SERVICE_PORT", "8085"),
+		UserDBHost:                getEnv("USER_DB_HOST", "dislinkt:WiYf6BvFmSpJS2Ob@xws.cjx50.mongodb.net/usersDB"),
+		UserDBPort:                getEnv("USER_DB_PORT", ""),
+		UserServiceName:           getEnv("USER_SERVICE_NAME", "user_service"),
+		ExpiresIn:                 30 * time.Minute,
+		CommonPasswords:           getPasswords(),
+		ConnectionServiceHost:     getEnv("CONNECTION_SERVICE_HOST", "localhost"),
+		ConnectionServicePort:     getEnv("CONNECTION_SERVICE_PORT", "8087"),
+		Email:                     getEnv("SERVICE_EMAIL", "xwstim1@outlook.com"),
+		EmailPassword:             getEnv("EMAIL_PASSWORD", "XWS.tim1"),
+		AuditSigningKey:           getEnv("AUDIT_SIGNING_KEY", ""),
+		AuditCheckpointEvery:      getEnvInt("AUDIT_CHECKPOINT_EVERY", 100),
+		CompletenessWeights:       getEnvWeights("PROFILE_COMPLETENESS_WEIGHTS"),
+		ImageStore:                getEnv("IMAGE_STORE", "gridfs"),
+		ImageDir:                  getEnv("IMAGE_DIR", "images"),
+		ImageMaxBytes:             getEnvInt("IMAGE_MAX_BYTES", 5<<20),
+		ImageMaxPixels:            getEnvInt("IMAGE_MAX_PIXELS", 25000000),
+		UsernameCooldown:          time.Duration(getEnvInt("USERNAME_COOLDOWN_DAYS", 90)) * 24 * time.Hour,
+		UsernameChangeLimit:       getEnvInt("USERNAME_CHANGE_LIMIT", 2),
+		UsernameChangeWindow:      time.Duration(getEnvInt("USERNAME_CHANGE_WINDOW_DAYS", 30)) * 24 * time.Hour,
+		ReservedUsernames:         getEnvList("RESERVED_USERNAMES"),
+		SearchBackend:             getEnv("SEARCH_BACKEND", "mongo"),
+		SearchIndexDir:            getEnv("SEARCH_INDEX_DIR", "search-index"),
+		EventPublisher:            getEnv("EVENT_PUBLISHER", "memory"),
+		EventPublisherURL:         getEnv("EVENT_PUBLISHER_URL", ""),
+		ConnectionReadTimeout:     time.Duration(getEnvInt("CONNECTION_READ_TIMEOUT_MS", 2000)) * time.Millisecond,
+		ConnectionWriteTimeout:    time.Duration(getEnvInt("CONNECTION_WRITE_TIMEOUT_MS", 10000)) * time.Millisecond,
+		ConnectionRetries:         getEnvInt("CONNECTION_RETRIES", 2),
+		ConnectionRetryBackoff:    time.Duration(getEnvInt("CONNECTION_RETRY_BACKOFF_MS", 100)) * time.Millisecond,
+		ConnectionBreakerFailures: getEnvInt("CONNECTION_BREAKER_FAILURES", 5),
+		ConnectionBreakerCooldown: time.Duration(getEnvInt("CONNECTION_BREAKER_COOLDOWN_SECONDS", 30)) * time.Second,
+		SearchDegradedMode:        getEnv("SEARCH_DEGRADED_MODE", "hide"),
+		MetricsPort:               getEnv("METRICS_PORT", ""),
+		TrustedProxies:            getEnvList("TRUSTED_PROXIES"),
+		SessionlessTokensUntil:    getEnvTime("SESSIONLESS_TOKENS_UNTIL"),
 	}
 }
 
@@ -64,3 +116,50 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int64) int64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// getEnvTime parses an RFC 3339 time such as "2022-06-01T12:00:00Z". A
+// missing or malformed value gives the zero time.
+func getEnvTime(key string) time.Time {
+	value, _ := time.Parse(time.RFC3339, os.Getenv(key))
+	return value
+}
+
+// getEnvList parses a comma separated list, leaving out empty entries.
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvWeights parses a list such as "bio=20,skills=10". Malformed entries
+// are skipped.
+func getEnvWeights(key string) map[string]int64 {
+	weights := map[string]int64{}
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return weights
+	}
+	for _, entry := range strings.Split(value, ",") {
+		name, weight, found := strings.Cut(entry, "=")
+		if !found {
+			continue
+		}
+		if parsed, err := strconv.ParseInt(strings.TrimSpace(weight), 10, 64); err == nil && parsed >= 0 {
+			weights[strings.TrimSpace(name)] = parsed
+		}
+	}
+	return weights
+}
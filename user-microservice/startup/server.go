@@ -2,8 +2,11 @@ package startup
 
 import (
 	"context"
+	"expvar"
 	"fmt"
+	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
 	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/services"
 	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/token"
 	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/tracer"
 	otgo "github.com/opentracing/opentracing-go"
@@ -12,8 +15,13 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/http"
+	"strconv"
+	"time"
 	"user-microservice/application"
 	"user-microservice/infrastructure/api"
+	"user-microservice/infrastructure/clients"
+	"user-microservice/infrastructure/messaging"
 	"user-microservice/infrastructure/persistance"
 	"user-microservice/model"
 	"user-microservice/startup/config"
@@ -25,6 +33,8 @@ type Server struct {
 	closer      io.Closer
 	jwtManager  *token.JwtManager
 	mongoClient *mongo.Client
+	searchIndex model.SearchIndex
+	publisher   model.EventPublisher
 }
 
 func NewServer(config *config.Config) *Server {
@@ -49,17 +59,183 @@ func (server *Server) GetCloser() io.Closer {
 
 func (server *Server) Start() {
 	server.mongoClient = server.initMongoClient()
+	server.initMigrations(server.mongoClient)
+	server.initIndexes(server.mongoClient)
 	userStore := server.initUserStore(server.mongoClient)
-	userService := server.initUserService(userStore, server.config)
-	authService := server.initAuthService(userStore)
-	experienceService := server.initExperienceService(userStore)
-	userHandler := server.initUserHandler(userService, authService, experienceService)
+	server.searchIndex = server.initSearchIndex(userStore)
+	server.publisher = server.initEventPublisher()
+	connectionClient := server.initConnectionClient()
+	eventService := server.initEventService(userStore, server.publisher, server.mongoClient)
+	auditService := server.initAuditService(userStore)
+	completenessService := server.initCompletenessService(userStore)
+	tagService := server.initTagService(userStore, completenessService)
+	blobStore := server.initBlobStore(server.mongoClient)
+	imageService := server.initImageService(userStore, blobStore)
+	usernameService := server.initUsernameService(userStore, auditService, eventService)
+	emailChangeService := server.initEmailChangeService(userStore, auditService)
+	userService := server.initUserService(userStore, server.config, connectionClient, auditService, tagService, completenessService, usernameService, server.searchIndex, eventService)
+	privacyService := server.initPrivacyService(userStore, connectionClient, eventService)
+	deletionService := server.initDeletionService(userStore, connectionClient, tagService, imageService, completenessService, server.searchIndex, eventService)
+	deviceService := server.initDeviceService(userStore)
+	authService := server.initAuthService(userStore, auditService, deviceService, completenessService, eventService)
+	experienceService := server.initExperienceService(userStore, completenessService)
+	educationService := server.initEducationService(userStore)
+	certificationService := server.initCertificationService(userStore)
+	languageService := server.initLanguageService(userStore)
+	projectService := server.initProjectService(userStore)
+	endorsementService := server.initEndorsementService(userStore, connectionClient, tagService)
+	peopleSearchService := server.initPeopleSearchService(userStore, server.config, connectionClient, tagService)
+	userHandler := server.initUserHandler(userService, authService, experienceService, educationService,
+		certificationService, languageService, projectService, deviceService, tagService, endorsementService, completenessService,
+		imageService, usernameService, emailChangeService, peopleSearchService, deletionService, privacyService)
+	if server.config.SearchBackend == "index" {
+		searchIndexService := server.initSearchIndexService(userStore, server.mongoClient)
+		go searchIndexService.Follow(context.Background())
+	}
+	go eventService.Relay(context.Background())
+	go deletionService.Run(context.Background())
+	go privacyService.Run(context.Background())
+	server.startMetricsServer()
 
 	server.startGrpcServer(userHandler)
 }
 
+// VerifyAuditTrail walks the audit chain once and reports the first break.
+// It returns false when the chain could not be verified.
+func (server *Server) VerifyAuditTrail() bool {
+	server.mongoClient = server.initMongoClient()
+	defer server.Stop()
+	auditService := server.initAuditService(server.initUserStore(server.mongoClient))
+
+	result, err := auditService.Verify(context.TODO())
+	if err != nil {
+		fmt.Println("audit verification failed: " + err.Error())
+		return false
+	}
+	if !result.Valid {
+		fmt.Printf("audit chain broken at sequence %d: %s (%d events verified)\n", result.BrokenAt, result.Reason, result.Checked)
+		return false
+	}
+	fmt.Printf("audit chain intact: %d events, %d signed checkpoints\n", result.Checked, result.Checkpoints)
+	return true
+}
+
+// BackfillTags rewrites free-text skills and interests to canonical tag ids.
+// It returns false when the backfill did not finish.
+func (server *Server) BackfillTags() bool {
+	server.mongoClient = server.initMongoClient()
+	defer server.Stop()
+	userStore := server.initUserStore(server.mongoClient)
+	tagService := server.initTagService(userStore, server.initCompletenessService(userStore))
+
+	result, err := tagService.Backfill(context.TODO())
+	if err != nil {
+		fmt.Println("tag backfill failed: " + err.Error())
+		return false
+	}
+	fmt.Printf("tag backfill done: %d users checked, %d rewritten, %d tags\n", result.Users, result.Rewritten, result.Tags)
+	return true
+}
+
+// ReindexSearch rebuilds the embedded search index from the database. It is
+// meant to run while the service is stopped, since the index file belongs to
+// a single process. It returns false when the rebuild did not finish.
+func (server *Server) ReindexSearch() bool {
+	server.mongoClient = server.initMongoClient()
+	defer server.Stop()
+	userStore := server.initUserStore(server.mongoClient)
+	server.config.SearchBackend = "index"
+	server.searchIndex = server.initSearchIndex(userStore)
+	searchIndexService := server.initSearchIndexService(userStore, server.mongoClient)
+
+	count, err := searchIndexService.Reindex(context.TODO())
+	if err != nil {
+		fmt.Println("reindex failed: " + err.Error())
+		return false
+	}
+	fmt.Printf("search index rebuilt: %d users\n", count)
+	return true
+}
+
+// Migrate runs the migrate subcommand: "up [version]" applies pending
+// migrations, "down [steps]" rolls back the latest ones and "status" lists
+// them. It returns false when the command failed.
+func (server *Server) Migrate(args []string) bool {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Println("usage: migrate up [version] | down [steps] | status")
+		return false
+	}
+	var number int64
+	if len(args) == 2 {
+		var err error
+		number, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil || number < 0 {
+			fmt.Println("invalid number: " + args[1])
+			return false
+		}
+	}
+	server.mongoClient = server.initMongoClient()
+	defer server.Stop()
+	migrator := persistance.NewMigrator(server.mongoClient)
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(context.TODO(), number)
+		for _, migration := range applied {
+			fmt.Printf("applied %d: %s\n", migration.Version, migration.Description)
+		}
+		if err != nil {
+			fmt.Println("migration failed: " + err.Error())
+			return false
+		}
+		fmt.Printf("%d migrations applied\n", len(applied))
+	case "down":
+		if number == 0 {
+			number = 1
+		}
+		rolledBack, err := migrator.Down(context.TODO(), int(number))
+		for _, migration := range rolledBack {
+			fmt.Printf("rolled back %d: %s\n", migration.Version, migration.Description)
+		}
+		if err != nil {
+			fmt.Println("rollback failed: " + err.Error())
+			return false
+		}
+		fmt.Printf("%d migrations rolled back\n", len(rolledBack))
+	case "status":
+		statuses, err := migrator.Status(context.TODO())
+		if err != nil {
+			fmt.Println("cannot read migration status: " + err.Error())
+			return false
+		}
+		for _, status := range statuses {
+			if status.Applied {
+				fmt.Printf("%4d  applied %s  %s\n", status.Version, status.AppliedAt.Format(time.RFC3339), status.Description)
+			} else {
+				fmt.Printf("%4d  pending %20s  %s\n", status.Version, "", status.Description)
+			}
+		}
+	default:
+		fmt.Println("unknown migrate command: " + args[0])
+		return false
+	}
+	return true
+}
+
 func (server *Server) Stop() {
 	log.Println("stopping server")
+	if server.searchIndex != nil {
+		err := server.searchIndex.Close()
+		if err != nil {
+			log.Printf("failed to close the search index: %v", err)
+		}
+	}
+	if server.publisher != nil {
+		err := server.publisher.Close()
+		if err != nil {
+			log.Printf("failed to close the event publisher: %v", err)
+		}
+	}
 	server.mongoClient.Disconnect(context.TODO())
 }
 
@@ -71,12 +247,33 @@ func (server *Server) initMongoClient() *mongo.Client {
 	return client
 }
 
+// initMigrations brings the schema up to date before anything reads from the
+// database. Replicas starting together wait for the one holding the lock.
+func (server *Server) initMigrations(client *mongo.Client) {
+	_, err := persistance.NewMigrator(client).Up(context.TODO(), 0)
+	if err != nil {
+		log.Fatalf("failed to migrate the database: %v", err)
+	}
+}
+
+// initIndexes stops the server when an index cannot be built, since the
+// unique ones are what keeps usernames and emails from being taken twice.
+func (server *Server) initIndexes(client *mongo.Client) {
+	err := persistance.EnsureIndexes(context.TODO(), client)
+	if err != nil {
+		log.Fatalf("failed to create indexes: %v", err)
+	}
+}
+
 func (server *Server) startGrpcServer(userHandler *api.UserHandler) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%s", server.config.Port))
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(api.UnaryErrorInterceptor, api.UnaryValidationInterceptor),
+		grpc.ChainStreamInterceptor(api.StreamErrorInterceptor),
+	)
 	log.Println(fmt.Sprintf("started grpc server on localhost:%s", server.config.Port))
 	userService.RegisterUserServiceServer(grpcServer, userHandler)
 	if err := grpcServer.Serve(listener); err != nil {
@@ -96,21 +293,187 @@ func (server *Server) initUserStore(client *mongo.Client) model.UserStore {
 	return store
 }
 
-func (server *Server) initUserService(store model.UserStore, config *config.Config) *application.UserService {
-	return application.NewUserService(store, config)
+func (server *Server) initUserService(store model.UserStore, config *config.Config, connectionClient connectionService.ConnectionServiceClient, auditService *application.AuditService, tagService *application.TagService, completenessService *application.CompletenessService, usernameService *application.UsernameService, searchIndex model.SearchIndex, eventService *application.EventService) *application.UserService {
+	return application.NewUserService(store, config, connectionClient, auditService, tagService, completenessService, usernameService, searchIndex, eventService)
+}
+
+func (server *Server) initDeletionService(store model.UserStore, connectionClient connectionService.ConnectionServiceClient, tagService *application.TagService, imageService *application.ImageService, completenessService *application.CompletenessService, searchIndex model.SearchIndex, eventService *application.EventService) *application.DeletionService {
+	return application.NewDeletionService(store, connectionClient, tagService, imageService, completenessService, searchIndex, eventService)
+}
+
+// initSearchIndex picks what answers user searches from SEARCH_BACKEND,
+// either "mongo", querying the user collection, or "index", an embedded index
+// kept under SEARCH_INDEX_DIR.
+func (server *Server) initSearchIndex(store model.UserStore) model.SearchIndex {
+	if server.config.SearchBackend != "index" {
+		return persistance.NewMongoSearchIndex(store)
+	}
+	index, err := persistance.NewInvertedIndex(server.config.SearchIndexDir)
+	if err != nil {
+		log.Fatalf("failed to open the search index: %v", err)
+	}
+	return index
+}
+
+func (server *Server) initSearchIndexService(store model.UserStore, client *mongo.Client) *application.SearchIndexService {
+	return application.NewSearchIndexService(store, server.searchIndex, persistance.NewUserChangeFeed(client))
+}
+
+func (server *Server) initEmailChangeService(store model.UserStore, auditService *application.AuditService) *application.EmailChangeService {
+	return application.NewEmailChangeService(store, auditService)
+}
+
+func (server *Server) initUsernameService(store model.UserStore, auditService *application.AuditService, eventService *application.EventService) *application.UsernameService {
+	return application.NewUsernameService(store, server.config, auditService, eventService)
+}
+
+// initEventPublisher picks where domain events go from EVENT_PUBLISHER, either
+// "http", posting them to EVENT_PUBLISHER_URL, or "memory", the default, an
+// in-process bus for running without a broker.
+func (server *Server) initEventPublisher() model.EventPublisher {
+	switch server.config.EventPublisher {
+	case "http":
+		if server.config.EventPublisherURL == "" {
+			log.Fatal("EVENT_PUBLISHER_URL is required by the http event publisher")
+		}
+		return messaging.NewHttpEventPublisher(server.config.EventPublisherURL)
+	case "memory":
+		log.Println("publishing domain events in process, set EVENT_PUBLISHER=http to deliver them to other services")
+		return messaging.NewEventBus()
+	}
+	log.Fatalf("EVENT_PUBLISHER must be http or memory, got %q", server.config.EventPublisher)
+	return nil
+}
+
+func (server *Server) initEventService(store model.UserStore, publisher model.EventPublisher, client *mongo.Client) *application.EventService {
+	return application.NewEventService(store, publisher, persistance.NewOutboxFeed(client))
+}
+
+// initBlobStore picks where uploaded images are kept from IMAGE_STORE, either
+// "gridfs" in the user database or "filesystem" under IMAGE_DIR.
+func (server *Server) initBlobStore(client *mongo.Client) model.BlobStore {
+	if server.config.ImageStore == "filesystem" {
+		return persistance.NewFileSystemBlobStore(server.config.ImageDir)
+	}
+	store, err := persistance.NewGridFSBlobStore(client, "images")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return store
+}
+
+func (server *Server) initImageService(store model.UserStore, blobStore model.BlobStore) *application.ImageService {
+	return application.NewImageService(store, blobStore, server.config)
+}
+
+func (server *Server) initTagService(store model.UserStore, completenessService *application.CompletenessService) *application.TagService {
+	return application.NewTagService(store, completenessService)
+}
+
+func (server *Server) initCompletenessService(store model.UserStore) *application.CompletenessService {
+	return application.NewCompletenessService(store, server.config)
+}
+
+func (server *Server) initPeopleSearchService(store model.UserStore, config *config.Config, connectionClient connectionService.ConnectionServiceClient, tagService *application.TagService) *application.PeopleSearchService {
+	return application.NewPeopleSearchService(store, config, connectionClient, tagService)
+}
+
+func (server *Server) initEndorsementService(store model.UserStore, connectionClient connectionService.ConnectionServiceClient, tagService *application.TagService) *application.EndorsementService {
+	return application.NewEndorsementService(store, connectionClient, tagService)
 }
 
 func (server *Server) initUserHandler(
 	service *application.UserService,
 	authService *application.AuthService,
-	experienceService *application.ExperienceService) *api.UserHandler {
-	return api.NewUserHandler(service, authService, experienceService)
+	experienceService *application.ExperienceService,
+	educationService *application.EducationService,
+	certificationService *application.CertificationService,
+	languageService *application.LanguageService,
+	projectService *application.ProjectService,
+	deviceService *application.DeviceService,
+	tagService *application.TagService,
+	endorsementService *application.EndorsementService,
+	completenessService *application.CompletenessService,
+	imageService *application.ImageService,
+	usernameService *application.UsernameService,
+	emailChangeService *application.EmailChangeService,
+	peopleSearchService *application.PeopleSearchService,
+	deletionService *application.DeletionService,
+	privacyService *application.PrivacyService) *api.UserHandler {
+	trustedProxies, err := api.ParseTrustedProxies(server.config.TrustedProxies)
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES must list addresses or CIDR ranges: %v", err)
+	}
+	return api.NewUserHandler(service, authService, experienceService, educationService,
+		certificationService, languageService, projectService, deviceService, tagService, endorsementService,
+		completenessService, imageService, usernameService, emailChangeService, peopleSearchService, deletionService, privacyService,
+		trustedProxies)
+}
+
+func (server *Server) initPrivacyService(store model.UserStore, connectionClient connectionService.ConnectionServiceClient, eventService *application.EventService) *application.PrivacyService {
+	return application.NewPrivacyService(store, connectionClient, eventService)
+}
+
+// initConnectionClient connects to the connection service once for all
+// services, with the deadlines, retries and circuit breaker set by the
+// CONNECTION_* variables.
+func (server *Server) initConnectionClient() connectionService.ConnectionServiceClient {
+	address := fmt.Sprintf("%s:%s", server.config.ConnectionServiceHost, server.config.ConnectionServicePort)
+	return clients.NewConnectionClient(services.NewConnectionClient(address), clients.ConnectionClientOptions{
+		ReadTimeout:     server.config.ConnectionReadTimeout,
+		WriteTimeout:    server.config.ConnectionWriteTimeout,
+		Retries:         server.config.ConnectionRetries,
+		RetryBackoff:    server.config.ConnectionRetryBackoff,
+		BreakerFailures: server.config.ConnectionBreakerFailures,
+		BreakerCooldown: server.config.ConnectionBreakerCooldown,
+		MetricsName:     "connectionService",
+	})
+}
+
+// startMetricsServer serves the published metrics as JSON on METRICS_PORT
+// under /debug/vars. Without a port they are not served.
+func (server *Server) startMetricsServer() {
+	if server.config.MetricsPort == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	go func() {
+		err := http.ListenAndServe(fmt.Sprintf(":%s", server.config.MetricsPort), mux)
+		if err != nil {
+			log.Printf("metrics server stopped: %v", err)
+		}
+	}()
+}
+
+func (server *Server) initAuthService(store model.UserStore, auditService *application.AuditService, deviceService *application.DeviceService, completenessService *application.CompletenessService, eventService *application.EventService) *application.AuthService {
+	return application.NewAuthService(store, server.jwtManager, server.config, auditService, deviceService, completenessService, eventService)
+}
+
+func (server *Server) initAuditService(store model.UserStore) *application.AuditService {
+	return application.NewAuditService(store, server.config)
+}
+
+func (server *Server) initExperienceService(store model.UserStore, completenessService *application.CompletenessService) *application.ExperienceService {
+	return application.NewExperienceService(store, completenessService)
+}
+
+func (server *Server) initEducationService(store model.UserStore) *application.EducationService {
+	return application.NewEducationService(store)
+}
+
+func (server *Server) initCertificationService(store model.UserStore) *application.CertificationService {
+	return application.NewCertificationService(store)
+}
+
+func (server *Server) initLanguageService(store model.UserStore) *application.LanguageService {
+	return application.NewLanguageService(store)
 }
 
-func (server *Server) initAuthService(store model.UserStore) *application.AuthService {
-	return application.NewAuthService(store, server.jwtManager)
+func (server *Server) initProjectService(store model.UserStore) *application.ProjectService {
+	return application.NewProjectService(store)
 }
 
-func (server *Server) initExperienceService(store model.UserStore) *application.ExperienceService {
-	return application.NewExperienceService(store)
+func (server *Server) initDeviceService(store model.UserStore) *application.DeviceService {
+	return application.NewDeviceService(store)
 }